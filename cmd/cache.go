@@ -0,0 +1,208 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/audiotool/pasta/pkg/cache"
+	"github.com/spf13/cobra"
+)
+
+var maxSizeFlag string
+
+var cacheCmd = &cobra.Command{
+	Use:   "cache",
+	Short: "cache manages the cache of downloaded files shared by all pasta runs",
+	Long: `cache manages the cache of downloaded files shared by all pasta runs.
+
+Blobs, archives and the file listings of commits are cached by their sha, so they're not
+downloaded again. The cache is located in $PASTA_CACHE_DIR, or the directory "pasta" in the
+user's cache directory, e.g. $XDG_CACHE_HOME/pasta. After every run, the least recently used
+entries are removed until the cache is smaller than $PASTA_CACHE_SIZE, 1GiB by default.`,
+}
+
+var cacheLsCmd = &cobra.Command{
+	Use:   "ls",
+	Short: "ls lists the repositories with cached files",
+	Run: func(cmd *cobra.Command, args []string) {
+		c := loadCache()
+
+		entries, err := c.Entries()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error while listing cache: %v\n", err)
+			os.Exit(-4)
+		}
+
+		fmt.Printf("Cache directory: %v\n\n", c.Dir)
+
+		if err := printCacheTable(os.Stdout, entries); err != nil {
+			fmt.Fprintf(os.Stderr, "Error while printing cache: %v\n", err)
+			os.Exit(-4)
+		}
+
+		limit := "no limit"
+		if c.MaxSize > 0 {
+			limit = "limit " + formatSize(c.MaxSize)
+		}
+
+		fmt.Printf("\n%v entries, %v (%v)\n", len(entries), formatSize(totalSize(entries)), limit)
+	},
+}
+
+var cachePruneCmd = &cobra.Command{
+	Use:   "prune",
+	Short: "prune removes the least recently used files until the cache is smaller than its size limit",
+	Run: func(cmd *cobra.Command, args []string) {
+		c := loadCache()
+
+		maxSize := c.MaxSize
+		if maxSizeFlag != "" {
+			size, err := cache.ParseSize(maxSizeFlag)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Invalid --max-size: %v\n", err)
+				os.Exit(-2)
+			}
+
+			maxSize = size
+		} else if maxSize == 0 {
+			fmt.Println("The cache has no size limit, nothing to prune")
+			return
+		}
+
+		removed, err := c.Prune(maxSize)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error while pruning cache: %v\n", err)
+			os.Exit(-4)
+		}
+
+		fmt.Printf("Removed %v entries, freed %v\n", len(removed), formatSize(totalSize(removed)))
+	},
+}
+
+var cacheClearCmd = &cobra.Command{
+	Use:   "clear",
+	Short: "clear removes all cached files",
+	Run: func(cmd *cobra.Command, args []string) {
+		c := loadCache()
+
+		if err := c.Clear(); err != nil {
+			fmt.Fprintf(os.Stderr, "Error while clearing cache: %v\n", err)
+			os.Exit(-4)
+		}
+
+		fmt.Printf("Removed cache directory %v\n", c.Dir)
+	},
+}
+
+// loadCache returns the cache, exiting if it is misconfigured.
+func loadCache() *cache.Cache {
+	c, err := cache.Default()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error while opening cache: %v\n", err)
+		os.Exit(-2)
+	}
+
+	return c
+}
+
+// openCache returns the cache for copying dependencies, or nil if it is misconfigured,
+// in which case nothing is cached.
+func openCache() *cache.Cache {
+	c, err := cache.Default()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: not caching downloads: %v\n", err)
+		return nil
+	}
+
+	return c
+}
+
+// pruneCache prunes c to its size limit. Failing to do so only warns, the run succeeded.
+func pruneCache(c *cache.Cache) {
+	if c == nil || c.MaxSize == 0 {
+		return
+	}
+
+	if _, err := c.Prune(c.MaxSize); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+	}
+}
+
+// printCacheTable prints one row per repository with cached entries, most recently used first.
+func printCacheTable(out io.Writer, entries []cache.Entry) error {
+	type repo struct {
+		copier, name string
+		entries      int
+		size         int64
+		used         time.Time
+	}
+
+	var repos []*repo
+	byName := make(map[[2]string]*repo)
+
+	for _, e := range entries {
+		r, ok := byName[[2]string{e.Copier, e.Repo}]
+		if !ok {
+			r = &repo{copier: e.Copier, name: e.Repo}
+			byName[[2]string{e.Copier, e.Repo}] = r
+			repos = append(repos, r)
+		}
+
+		r.entries++
+		r.size += e.Size
+
+		if e.Used.After(r.used) {
+			r.used = e.Used
+		}
+	}
+
+	sort.SliceStable(repos, func(i, j int) bool {
+		return repos[i].used.After(repos[j].used)
+	})
+
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "COPIER\tREPOSITORY\tENTRIES\tSIZE\tLAST USED")
+
+	for _, r := range repos {
+		fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\n", r.copier, r.name, r.entries, formatSize(r.size), r.used.Format("2006-01-02 15:04"))
+	}
+
+	return w.Flush()
+}
+
+func totalSize(entries []cache.Entry) int64 {
+	var size int64
+	for _, e := range entries {
+		size += e.Size
+	}
+
+	return size
+}
+
+// formatSize formats a number of bytes for humans, e.g. "1.5 MiB".
+func formatSize(size int64) string {
+	if size < 1<<10 {
+		return fmt.Sprintf("%v B", size)
+	}
+
+	value, unit := float64(size)/(1<<10), "KiB"
+	for _, u := range []string{"MiB", "GiB", "TiB"} {
+		if value < 1<<10 {
+			break
+		}
+
+		value, unit = value/(1<<10), u
+	}
+
+	return fmt.Sprintf("%.1f %v", value, unit)
+}
+
+func init() {
+	cachePruneCmd.Flags().StringVar(&maxSizeFlag, "max-size", "", "size to prune the cache to, e.g. 500M, instead of $PASTA_CACHE_SIZE")
+	cacheCmd.AddCommand(cacheLsCmd, cachePruneCmd, cacheClearCmd)
+	RootCmd.AddCommand(cacheCmd)
+}
@@ -0,0 +1,65 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/audiotool/pasta/pkg/pasta"
+	"github.com/spf13/cobra"
+)
+
+var checkCmd = &cobra.Command{
+	Use:   "check",
+	Short: "check fails if the pasted files differ from the sources recorded in pasta.result.yaml",
+	Run: func(cmd *cobra.Command, args []string) {
+		pathToYaml, cfg := loadPastaConf()
+
+		ctx := context.Background()
+		drifts, err := pasta.Check(ctx, cfg.registry, cfg.dependencies, cfg.KeepDirs, pathToYaml)
+
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error while checking dependencies: %v\n", err)
+			os.Exit(-4)
+		}
+
+		drifted := 0
+
+		for _, drift := range drifts {
+			if drift.Clean() {
+				continue
+			}
+
+			drifted++
+
+			if drift.Skipped {
+				fmt.Printf("Dependency %v (to %v): skipped when pasta last ran\n\n", drift.URL, drift.Target)
+				continue
+			}
+
+			fmt.Printf("Dependency %v (to %v):\n", drift.URL, drift.Target)
+			printPaths("added", drift.Added)
+			printPaths("removed", drift.Removed)
+			printPaths("modified", drift.Modified)
+			fmt.Println()
+		}
+
+		if drifted > 0 {
+			fmt.Fprintf(os.Stderr, "%v of %v dependencies differ from their source, run pasta to update them\n", drifted, len(drifts))
+			os.Exit(-5)
+		}
+
+		fmt.Println("All dependencies match their source")
+	},
+}
+
+func printPaths(kind string, paths []string) {
+	for _, p := range paths {
+		fmt.Printf("  %-9s %v\n", kind+":", p)
+	}
+}
+
+func init() {
+	addOfflineFlag(checkCmd)
+	RootCmd.AddCommand(checkCmd)
+}
@@ -0,0 +1,53 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/audiotool/pasta/pkg/pasta"
+	"github.com/spf13/cobra"
+)
+
+var fetchCmd = &cobra.Command{
+	Use:   "fetch",
+	Short: "fetch stores the files of all dependencies in the cache, so pasta can run --offline",
+	Long: `fetch stores the files of all dependencies in the cache, so pasta can run --offline.
+
+Dependencies are fetched at the reference recorded in pasta.result.yaml, the working tree
+isn't touched.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		pathToYaml, cfg := loadPastaConf()
+
+		if cfg.cache == nil {
+			fmt.Fprintln(os.Stderr, "Error while fetching dependencies: the cache can't be used")
+			os.Exit(-4)
+		}
+
+		ctx := context.Background()
+		fetched, err := pasta.Fetch(ctx, cfg.registry, cfg.dependencies, pathToYaml)
+
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error while fetching dependencies: %v\n", err)
+			os.Exit(-4)
+		}
+
+		for _, f := range fetched {
+			switch {
+			case f.Reference == "":
+				fmt.Printf("Nothing to fetch for %v, it is always copied as it is\n", f.URL)
+			case !f.Locked:
+				fmt.Printf("Fetched %v at %v\n", f.URL, f.Reference)
+				fmt.Fprintf(os.Stderr, "Warning: %v (to %v) is not locked in %v yet, run pasta before running it offline\n", f.URL, f.Target, pasta.ResultFile)
+			default:
+				fmt.Printf("Fetched %v at %v\n", f.URL, f.Reference)
+			}
+		}
+
+		fmt.Printf("Cached in %v\n", cfg.cache.Dir)
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(fetchCmd)
+}
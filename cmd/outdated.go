@@ -0,0 +1,145 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"text/tabwriter"
+
+	"github.com/audiotool/pasta/pkg/pasta"
+	"github.com/spf13/cobra"
+)
+
+var jsonFlag bool
+
+var outdatedCmd = &cobra.Command{
+	Use:   "outdated",
+	Short: "outdated lists dependencies whose ref resolves to something newer than what was copied",
+	Long: `outdated lists dependencies whose ref resolves to something newer than what was copied.
+
+For every dependency, the reference recorded in pasta.result.yaml (current) is compared with
+what its ref resolves to now (wanted). The newest version tag of the source is listed as well
+(latest), which tells whether a dependency pinned to a tag or sha could be moved forward.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		pathToYaml, cfg := loadPastaConf()
+
+		ctx := context.Background()
+		statuses, err := pasta.Outdated(ctx, cfg.registry, cfg.dependencies, pathToYaml)
+
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error while resolving dependencies: %v\n", err)
+			os.Exit(-4)
+		}
+
+		if jsonFlag {
+			err = printOutdatedJSON(os.Stdout, statuses)
+		} else {
+			err = printOutdatedTable(os.Stdout, statuses)
+		}
+
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error while printing dependencies: %v\n", err)
+			os.Exit(-4)
+		}
+	},
+}
+
+// outdatedJSON is the json representation of a dependency's status.
+type outdatedJSON struct {
+	URL        string `json:"url"`
+	To         string `json:"to"`
+	Ref        string `json:"ref,omitempty"`
+	Current    string `json:"current,omitempty"`
+	CurrentTag string `json:"current_tag,omitempty"`
+	Wanted     string `json:"wanted,omitempty"`
+	WantedTag  string `json:"wanted_tag,omitempty"`
+	Latest     string `json:"latest,omitempty"`
+	// Behind is null if unknown
+	Behind   *int   `json:"behind"`
+	Outdated bool   `json:"outdated"`
+	Error    string `json:"error,omitempty"`
+}
+
+func printOutdatedJSON(w io.Writer, statuses []pasta.Status) error {
+	out := make([]outdatedJSON, 0, len(statuses))
+
+	for _, s := range statuses {
+		o := outdatedJSON{
+			URL:        s.URL,
+			To:         s.Target,
+			Ref:        s.Ref,
+			Current:    s.Current,
+			CurrentTag: s.CurrentTag,
+			Wanted:     s.Wanted,
+			WantedTag:  s.WantedTag,
+			Latest:     s.Latest,
+			Outdated:   s.Outdated(),
+		}
+
+		if s.Behind >= 0 {
+			behind := s.Behind
+			o.Behind = &behind
+		}
+
+		if s.Err != nil {
+			o.Error = s.Err.Error()
+		}
+
+		out = append(out, o)
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+
+	return enc.Encode(out)
+}
+
+func printOutdatedTable(w io.Writer, statuses []pasta.Status) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+
+	fmt.Fprintln(tw, "TO\tURL\tCURRENT\tWANTED\tLATEST\tBEHIND")
+
+	for _, s := range statuses {
+		if s.Err != nil {
+			fmt.Fprintf(tw, "%v\t%v\t%v\t%v\t\t\n", s.Target, s.URL, formatRef(s.Current, s.CurrentTag), s.Err)
+			continue
+		}
+
+		behind := "?"
+		if s.Behind >= 0 {
+			behind = strconv.Itoa(s.Behind)
+		}
+
+		fmt.Fprintf(tw, "%v\t%v\t%v\t%v\t%v\t%v\n", s.Target, s.URL, formatRef(s.Current, s.CurrentTag), formatRef(s.Wanted, s.WantedTag), dash(s.Latest), behind)
+	}
+
+	return tw.Flush()
+}
+
+// formatRef formats a reference for humans: the tag if there is one, otherwise the abbreviated sha.
+func formatRef(reference, tag string) string {
+	switch {
+	case tag != "":
+		return tag
+	case len(reference) > 12:
+		return reference[:12]
+	default:
+		return dash(reference)
+	}
+}
+
+func dash(s string) string {
+	if s == "" {
+		return "-"
+	}
+
+	return s
+}
+
+func init() {
+	outdatedCmd.Flags().BoolVar(&jsonFlag, "json", false, "print the result as json")
+	RootCmd.AddCommand(outdatedCmd)
+}
@@ -2,64 +2,149 @@ package cmd
 
 import (
 	"fmt"
+	"net/url"
 	"os"
 	"path"
 	"path/filepath"
 	"strings"
 
+	"github.com/audiotool/pasta/pkg/cache"
 	"github.com/audiotool/pasta/pkg/copier"
+	"github.com/audiotool/pasta/pkg/github"
+	"github.com/audiotool/pasta/pkg/gitlab"
 	"github.com/audiotool/pasta/pkg/pasta"
+	"github.com/audiotool/pasta/pkg/plugin"
 	"gopkg.in/yaml.v3"
 )
 
 type pastaConf struct {
 	KeepDirs bool          `yaml:"keep_dirs"`
+	Github   *githubConf   `yaml:"github"`
+	Gitlab   *gitlabConf   `yaml:"gitlab"`
 	Deps     []*copierConf `yaml:"deps"`
+	Plugins  []pluginConf  `yaml:"plugins"`
 
 	dependencies []pasta.Dependency
+	// cache is shared by all dependencies, nil if nothing is cached
+	cache *cache.Cache
+	// registry contains the copiers of Registry and all plugins, set by usePlugins
+	registry *pasta.Registry
+}
+
+// pluginConf declares a plugin, an executable copying dependencies in another process.
+type pluginConf struct {
+	Name string `yaml:"name"`
+	// Path is the path of the executable relative to pasta.yaml, empty to look up
+	// pasta-copier-<name> in $PATH
+	Path string `yaml:"path"`
+}
+
+// githubConf configures the GitHub Enterprise Server instance repositories are copied from.
+type githubConf struct {
+	APIURL    string   `yaml:"api_url"`
+	UploadURL string   `yaml:"upload_url"`
+	Hosts     []string `yaml:"hosts"`
+}
+
+func (conf *githubConf) copier() *github.Copier {
+	return &github.Copier{
+		APIURL:    conf.APIURL,
+		UploadURL: conf.UploadURL,
+		Hosts:     conf.Hosts,
+	}
+}
+
+// gitlabConf configures the self-hosted GitLab instance projects are copied from.
+type gitlabConf struct {
+	APIURL string   `yaml:"api_url"`
+	Hosts  []string `yaml:"hosts"`
+}
+
+func (conf *gitlabConf) copier() *gitlab.Copier {
+	return &gitlab.Copier{
+		APIURL: conf.APIURL,
+		Hosts:  conf.Hosts,
+	}
 }
 
 type copierConf struct {
-	URL     string            `yaml:"url"`
-	From    string            `yaml:"from"`
-	To      string            `yaml:"to"`
-	Include string            `yaml:"include"`
-	Exclude string            `yaml:"exclude"`
-	Options map[string]string `yaml:"options"`
-	Files   []string          `yaml:"files"`
+	URL     string       `yaml:"url"`
+	From    string       `yaml:"from"`
+	To      string       `yaml:"to"`
+	Include patternsConf `yaml:"include"`
+	Exclude patternsConf `yaml:"exclude"`
+	// IncludeGlob and ExcludeGlob are gitignore-style alternatives to Include and Exclude
+	IncludeGlob []string          `yaml:"include_glob"`
+	ExcludeGlob []string          `yaml:"exclude_glob"`
+	Options     map[string]string `yaml:"options"`
+	Files       []fileConf        `yaml:"files"`
+	Rename      []string          `yaml:"rename"`
+	Flatten     bool              `yaml:"flatten"`
+	// Symlinks is one of preserve, follow and skip, empty means preserve
+	Symlinks string `yaml:"symlinks"`
 }
 
-func (conf *copierConf) ToCopierOptions() (*copier.CopyConfig, error) {
-	files := make(map[string]bool, len(conf.Files))
-	for _, file := range conf.Files {
-		files[file] = true
+// patternsConf is a list of patterns, which can also be given as a single string.
+type patternsConf []string
+
+func (conf *patternsConf) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		var pattern string
+		if err := value.Decode(&pattern); err != nil {
+			return err
+		}
+
+		*conf = patternsConf{pattern}
+		return nil
 	}
 
-	// prepare & compile include regexp
-	includeRegexp, err := pasta.IncludeRegexp(conf.Include)
+	return value.Decode((*[]string)(conf))
+}
 
-	if err != nil {
-		return nil, err
+// fileConf is an entry of `files`, either the path of a file relative to `from`, or a map
+// with the keys `src`, the path relative to `from`, and `dst`, the path relative to `to`
+// the file is copied to.
+type fileConf struct {
+	Src string `yaml:"src"`
+	// Dst is empty if the file keeps its path
+	Dst string `yaml:"dst"`
+}
+
+func (conf *fileConf) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		return value.Decode(&conf.Src)
+	}
+
+	// decode into a type without UnmarshalYAML, which would recurse otherwise
+	type plain fileConf
+	return value.Decode((*plain)(conf))
+}
+
+func (conf *copierConf) ToCopierOptions() (*copier.CopyConfig, error) {
+	rules := copier.Rules{
+		Include:     conf.Include,
+		Exclude:     conf.Exclude,
+		IncludeGlob: conf.IncludeGlob,
+		ExcludeGlob: conf.ExcludeGlob,
 	}
 
-	// prepare & compile excludeRegexp regexp
-	excludeRegexp, err := pasta.ExcludeRegexp(conf.Exclude)
+	for _, file := range conf.Files {
+		rules.Files = append(rules.Files, file.Src)
+	}
 
+	keep, err := pasta.KeepFunc(rules)
 	if err != nil {
 		return nil, err
 	}
 
 	return &copier.CopyConfig{
-		URL:  conf.URL,
-		From: conf.From,
-		Keep: func(path string) bool {
-			if len(files) > 0 {
-				return files[path]
-			}
-			return includeRegexp.MatchString(path) && !excludeRegexp.MatchString(path)
-		},
+		URL:         conf.URL,
+		From:        conf.From,
+		Keep:        keep,
+		Rules:       rules,
 		Options:     conf.Options,
-		ClearTarget: len(files) == 0,
+		ClearTarget: len(conf.Files) == 0,
+		Symlinks:    conf.Symlinks,
 	}, nil
 }
 
@@ -92,20 +177,206 @@ func newPastaConf(pathToYaml string) (*pastaConf, error) {
 			return nil, fmt.Errorf("couldn't create temp directory for dependency %v: %v", i, err)
 		}
 
+		option.Root = path.Dir(pathToYaml)
+
 		dep := pasta.Dependency{
 			Option: *option,
 			Target: path.Join(path.Dir(pathToYaml), config.To),
 		}
 
+		dep.Rewrite, _ = config.rewrite()
+
+		dep.Copier = c.selfHostedCopier(config)
+
 		c.dependencies = append(c.dependencies, dep)
 	}
 
 	return &c, nil
 }
 
+// rewrite returns the function mapping the paths of copied files to the paths they're copied
+// to, or nil if they keep their paths. Destinations given in `files` take precedence over
+// `rename` and `flatten`.
+func (conf *copierConf) rewrite() (func(p string) string, error) {
+	rewrite, err := pasta.RewriteFunc(conf.Rename, conf.Flatten)
+	if err != nil {
+		return nil, err
+	}
+
+	dsts := make(map[string]string)
+	for _, file := range conf.Files {
+		if file.Dst != "" {
+			dsts[file.Src] = file.Dst
+		}
+	}
+
+	if len(dsts) == 0 {
+		return rewrite, nil
+	}
+
+	return func(p string) string {
+		if dst, ok := dsts[p]; ok {
+			return dst
+		}
+
+		if rewrite != nil {
+			return rewrite(p)
+		}
+
+		return p
+	}, nil
+}
+
+// useCache makes all dependencies look up downloads in shared, and store them there.
+func (c *pastaConf) useCache(shared *cache.Cache) {
+	c.cache = shared
+
+	for i := range c.dependencies {
+		c.dependencies[i].Option.Cache = shared
+	}
+}
+
+// goOffline makes all dependencies copy the references recorded in pasta.result.yaml from
+// the cache, without accessing the network.
+func (c *pastaConf) goOffline() {
+	for i := range c.dependencies {
+		c.dependencies[i].Option.Offline = true
+	}
+}
+
+// usePlugins sets the registry of c to a copy of base, with the plugins declared in pasta.yaml
+// and those found on the search path pathList added to it. Declared plugins are matched before
+// the copiers of base, and take precedence over plugins of the same name found on the search path.
+// Plugins found on the search path are matched last. Relative paths of declared plugins are
+// relative to root.
+func (c *pastaConf) usePlugins(base *pasta.Registry, root, pathList string) error {
+	c.registry = base.Clone()
+	declared := make(map[string]bool)
+
+	for _, conf := range c.Plugins {
+		p, err := conf.copier(root, pathList)
+		if err != nil {
+			return err
+		}
+
+		declared[conf.Name] = true
+		c.registry.RegisterPriority(p, pasta.PriorityDeclared)
+	}
+
+	for _, p := range plugin.Discover(pathList) {
+		if !declared[p.Name] {
+			c.registry.RegisterPriority(p, pasta.PriorityDiscovered)
+		}
+	}
+
+	return nil
+}
+
+func (conf *pluginConf) copier(root, pathList string) (*plugin.Copier, error) {
+	if conf.Path == "" {
+		return plugin.Find(conf.Name, pathList)
+	}
+
+	p := conf.Path
+	if !filepath.IsAbs(p) {
+		p = filepath.Join(root, p)
+	}
+
+	if _, err := os.Stat(p); err != nil {
+		return nil, fmt.Errorf("plugin %v: %v", conf.Name, err)
+	}
+
+	return &plugin.Copier{Name: conf.Name, Path: p}, nil
+}
+
+// selfHostedCopier returns the copier configured for the self-hosted instance serving
+// the dependency, or nil if the copier matching its url should be used.
+func (c *pastaConf) selfHostedCopier(config *copierConf) copier.Copier {
+	gh := &github.Copier{}
+	if c.Github != nil {
+		gh = c.Github.copier()
+	}
+
+	gl := &gitlab.Copier{}
+	if c.Gitlab != nil {
+		gl = c.Gitlab.copier()
+	}
+
+	switch {
+	case (&github.Copier{}).Matches(config.URL), (&gitlab.Copier{}).Matches(config.URL):
+		// github.com and gitlab.com are served by the default copiers
+		return nil
+	case gh.Matches(config.URL):
+		return gh
+	case gl.Matches(config.URL):
+		return gl
+	}
+
+	// an instance configured for this dependency only, validate rejects ambiguous ones
+	switch instance, _ := instanceType(config); instance {
+	case instanceGithub:
+		return gh
+	case instanceGitlab:
+		return gl
+	default:
+		return nil
+	}
+}
+
+// Types of self-hosted instances, given by the option "instance".
+const (
+	instanceGithub = "github"
+	instanceGitlab = "gitlab"
+)
+
+// instanceType returns the type of the self-hosted instance the dependency configures with the
+// option "api_url", or "" if it doesn't configure one. Unless the option "instance" names the
+// type, it is inferred from the endpoints, which end in /api/v3 for GitHub Enterprise Server
+// and /api/v4 for GitLab.
+func instanceType(config *copierConf) (string, error) {
+	instance, apiURL := config.Options["instance"], config.Options["api_url"]
+
+	switch {
+	case instance == instanceGithub, instance == instanceGitlab:
+		return instance, nil
+	case instance != "":
+		return "", fmt.Errorf("instance must be one of %v or %v", instanceGithub, instanceGitlab)
+	case apiURL == "":
+		return "", nil
+	case strings.HasSuffix(strings.TrimSuffix(apiURL, "/"), "/api/v3"), config.Options["upload_url"] != "":
+		return instanceGithub, nil
+	case strings.HasSuffix(strings.TrimSuffix(apiURL, "/"), "/api/v4"):
+		return instanceGitlab, nil
+	default:
+		return "", fmt.Errorf("can't tell whether api_url %v serves GitHub or GitLab, set the option instance to %v or %v", apiURL, instanceGithub, instanceGitlab)
+	}
+}
+
 func (c *pastaConf) validate() error {
 	var err error
 
+	if c.Github != nil {
+		if err = validateInstance([]string{c.Github.APIURL, c.Github.UploadURL}, c.Github.Hosts); err != nil {
+			return fmt.Errorf("github: %v", err)
+		}
+	}
+
+	if c.Gitlab != nil {
+		if err = validateInstance([]string{c.Gitlab.APIURL}, c.Gitlab.Hosts); err != nil {
+			return fmt.Errorf("gitlab: %v", err)
+		}
+	}
+
+	for i, conf := range c.Plugins {
+		if conf.Name == "" {
+			return fmt.Errorf("plugin %v: 'name' is required", i)
+		}
+
+		if strings.ContainsAny(conf.Name, `/\`) {
+			return fmt.Errorf("plugin %v: 'name' must not contain path separators", i)
+		}
+	}
+
 	for i, config := range c.Deps {
 		if config.URL == "" {
 			return fmt.Errorf("dependency %v: 'url' is required", i)
@@ -122,21 +393,78 @@ func (c *pastaConf) validate() error {
 		}
 
 		// files is exclusive with include/exclude
-		if len(config.Files) > 0 && (config.Include != "" || config.Exclude != "") {
+		if len(config.Files) > 0 && (len(config.Include) > 0 || len(config.Exclude) > 0) {
 			return fmt.Errorf("dependency %v: files and include/exclude are mutually exclusive", i)
 		}
 
+		globs := len(config.IncludeGlob) > 0 || len(config.ExcludeGlob) > 0
+
+		// globs are exclusive with both files and include/exclude regexes
+		if globs && len(config.Files) > 0 {
+			return fmt.Errorf("dependency %v: files and include_glob/exclude_glob are mutually exclusive", i)
+		}
+
+		if globs && (len(config.Include) > 0 || len(config.Exclude) > 0) {
+			return fmt.Errorf("dependency %v: include/exclude and include_glob/exclude_glob are mutually exclusive", i)
+		}
+
+		if _, err = instanceType(config); err != nil {
+			return fmt.Errorf("dependency %v: %v", i, err)
+		}
+
+		switch config.Symlinks {
+		case "", copier.SymlinksPreserve, copier.SymlinksFollow, copier.SymlinksSkip:
+		default:
+			return fmt.Errorf("dependency %v: symlinks must be one of %v, %v or %v", i, copier.SymlinksPreserve, copier.SymlinksFollow, copier.SymlinksSkip)
+		}
+
 		// convert pastaConf to CopierOptions
 		_, err = config.ToCopierOptions()
 
 		if err != nil {
 			return fmt.Errorf("couldn't parse dependency %v: %v", i, err)
 		}
+
+		err = validateFilesField(config, i)
+		if err != nil {
+			return err
+		}
+
+		if _, err = config.rewrite(); err != nil {
+			return fmt.Errorf("dependency %v: %v", i, err)
+		}
 	}
 
 	return err
 }
 
+// validateInstance validates the configuration of a self-hosted instance, given its endpoints,
+// starting with the API endpoint, and its hosts.
+func validateInstance(endpoints []string, hosts []string) error {
+	for _, u := range endpoints {
+		if u == "" {
+			continue
+		}
+
+		parsed, err := url.Parse(u)
+		if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+			return fmt.Errorf("%v must be an absolute http(s) url", u)
+		}
+	}
+
+	if endpoints[0] == "" && len(hosts) == 0 {
+		return fmt.Errorf("one of 'api_url' or 'hosts' is required")
+	}
+
+	for _, host := range hosts {
+		if host == "" || strings.Contains(host, "/") {
+			return fmt.Errorf("host %#v must be a host name, without scheme or path", host)
+		}
+	}
+
+	return nil
+}
+
 func validateFromField(config *copierConf, i int) error {
 	// If From is `/`, we want to copy all files.
 	// Since file paths dont start with `/`, we set empty string (so hasPrefix returns true).
@@ -167,6 +495,35 @@ func validateFromField(config *copierConf, i int) error {
 	return nil
 }
 
+func validateFilesField(config *copierConf, i int) error {
+	seen := make(map[string]bool)
+	for _, file := range config.Files {
+		if file.Src == "" {
+			return fmt.Errorf("dependency %v: 'src' of files is required", i)
+		}
+
+		src := path.Clean(file.Src)
+		if seen[src] {
+			return fmt.Errorf("dependency %v: file %v is listed more than once in files", i, file.Src)
+		}
+		seen[src] = true
+
+		if file.Dst == "" {
+			continue
+		}
+
+		if filepath.Clean(file.Dst) != file.Dst {
+			return fmt.Errorf("dependency %v: 'dst' of file %v must contain a clean path", i, file.Src)
+		}
+
+		if strings.HasPrefix(file.Dst, "/") || !filepath.IsLocal(file.Dst) {
+			return fmt.Errorf("dependency %v: 'dst' of file %v must be a relative path inside of 'to'", i, file.Src)
+		}
+	}
+
+	return nil
+}
+
 func validateToField(config *copierConf, i int) error {
 	if config.To == "." {
 		if len(config.Files) == 0 {
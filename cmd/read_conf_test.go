@@ -1,7 +1,17 @@
 package cmd
 
 import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
+
+	"github.com/audiotool/pasta/pkg/gitlab"
+	"github.com/audiotool/pasta/pkg/pasta"
+	"github.com/audiotool/pasta/pkg/plugin"
+	"gopkg.in/yaml.v3"
 )
 
 func TestPastaConfValidate(t *testing.T) {
@@ -18,7 +28,7 @@ func TestPastaConfValidate(t *testing.T) {
 						URL:     "https://example.com",
 						From:    "path/to/source/",
 						To:      "path/to/destination/",
-						Include: "*.go",
+						Include: patternsConf{"*.go"},
 					},
 				},
 			},
@@ -94,7 +104,7 @@ func TestPastaConfValidate(t *testing.T) {
 						URL:   "https://example.com",
 						From:  "path/to/source/",
 						To:    ".",
-						Files: []string{"file1.txt", "file2.txt"},
+						Files: []fileConf{{Src: "file1.txt"}, {Src: "file2.txt"}},
 					},
 				},
 			},
@@ -108,7 +118,7 @@ func TestPastaConfValidate(t *testing.T) {
 						URL:   "https://example.com",
 						From:  "path/to/source/",
 						To:    "path/to/destination/",
-						Files: []string{"file1.txt", "file2.txt"},
+						Files: []fileConf{{Src: "file1.txt"}, {Src: "file2.txt"}},
 					},
 				},
 			},
@@ -161,9 +171,9 @@ func TestPastaConfValidate(t *testing.T) {
 						URL:     "https://example.com",
 						From:    "path/to/source/",
 						To:      "path/to/destination/",
-						Include: "*.go",
-						Exclude: "*.txt",
-						Files:   []string{"file1.txt", "file2.txt"},
+						Include: patternsConf{"*.go"},
+						Exclude: patternsConf{"*.txt"},
+						Files:   []fileConf{{Src: "file1.txt"}, {Src: "file2.txt"}},
 					},
 				},
 			},
@@ -190,8 +200,8 @@ func TestPastaConfValidate(t *testing.T) {
 						URL:     "https://example.com",
 						From:    "path/to/source/",
 						To:      ".",
-						Include: "*.go",
-						Files:   []string{"file1.txt", "file2.txt"},
+						Include: patternsConf{"*.go"},
+						Files:   []fileConf{{Src: "file1.txt"}, {Src: "file2.txt"}},
 					},
 				},
 			},
@@ -205,13 +215,336 @@ func TestPastaConfValidate(t *testing.T) {
 						URL:     "https://example.com",
 						From:    "path/to/source/",
 						To:      ".",
-						Exclude: "*.txt",
-						Files:   []string{"file1.txt", "file2.txt"},
+						Exclude: patternsConf{"*.txt"},
+						Files:   []fileConf{{Src: "file1.txt"}, {Src: "file2.txt"}},
+					},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "valid dot to with file destinations",
+			conf: &pastaConf{
+				Deps: []*copierConf{
+					{
+						URL:   "https://example.com",
+						From:  "/",
+						To:    ".",
+						Files: []fileConf{{Src: "LICENSE", Dst: "third_party/foo/LICENSE.upstream"}},
+					},
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name: "invalid file destination outside of to",
+			conf: &pastaConf{
+				Deps: []*copierConf{
+					{
+						URL:   "https://example.com",
+						From:  "path/to/source/",
+						To:    "path/to/destination/",
+						Files: []fileConf{{Src: "LICENSE", Dst: "../LICENSE"}},
+					},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid absolute file destination",
+			conf: &pastaConf{
+				Deps: []*copierConf{
+					{
+						URL:   "https://example.com",
+						From:  "path/to/source/",
+						To:    ".",
+						Files: []fileConf{{Src: "LICENSE", Dst: "/etc/LICENSE"}},
+					},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid unclean file destination",
+			conf: &pastaConf{
+				Deps: []*copierConf{
+					{
+						URL:   "https://example.com",
+						From:  "path/to/source/",
+						To:    ".",
+						Files: []fileConf{{Src: "LICENSE", Dst: "third_party//LICENSE"}},
+					},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid file without src",
+			conf: &pastaConf{
+				Deps: []*copierConf{
+					{
+						URL:   "https://example.com",
+						From:  "path/to/source/",
+						To:    ".",
+						Files: []fileConf{{Dst: "LICENSE"}},
+					},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid duplicate src in files",
+			conf: &pastaConf{
+				Deps: []*copierConf{
+					{
+						URL:   "https://example.com",
+						From:  "path/to/source/",
+						To:    ".",
+						Files: []fileConf{{Src: "LICENSE", Dst: "a/LICENSE"}, {Src: "./LICENSE", Dst: "b/LICENSE"}},
+					},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "valid include and exclude lists",
+			conf: &pastaConf{
+				Deps: []*copierConf{
+					{
+						URL:     "https://example.com",
+						From:    "path/to/source/",
+						To:      "path/to/destination/",
+						Include: patternsConf{"a/.*", "b/.*"},
+						Exclude: patternsConf{".*_test\\.go"},
+					},
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name: "invalid pattern in include list",
+			conf: &pastaConf{
+				Deps: []*copierConf{
+					{
+						URL:     "https://example.com",
+						From:    "path/to/source/",
+						To:      "path/to/destination/",
+						Include: patternsConf{"a/.*", "b/(.*"},
 					},
 				},
 			},
 			wantErr: true,
 		},
+		{
+			name: "valid globs",
+			conf: &pastaConf{
+				Deps: []*copierConf{
+					{
+						URL:         "https://example.com",
+						From:        "path/to/source/",
+						To:          "path/to/destination/",
+						IncludeGlob: []string{"**/*.{png,svg}"},
+						ExcludeGlob: []string{"testdata/", "!testdata/keep.png"},
+					},
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name: "invalid globs with include",
+			conf: &pastaConf{
+				Deps: []*copierConf{
+					{
+						URL:         "https://example.com",
+						From:        "path/to/source/",
+						To:          "path/to/destination/",
+						Include:     patternsConf{".*\\.png"},
+						ExcludeGlob: []string{"testdata/"},
+					},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid globs with files",
+			conf: &pastaConf{
+				Deps: []*copierConf{
+					{
+						URL:         "https://example.com",
+						From:        "path/to/source/",
+						To:          ".",
+						IncludeGlob: []string{"*.png"},
+						Files:       []fileConf{{Src: "a.png"}},
+					},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid glob",
+			conf: &pastaConf{
+				Deps: []*copierConf{
+					{
+						URL:         "https://example.com",
+						From:        "path/to/source/",
+						To:          "path/to/destination/",
+						IncludeGlob: []string{"*.{png"},
+					},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "valid rename and flatten",
+			conf: &pastaConf{
+				Deps: []*copierConf{
+					{
+						URL:     "https://example.com",
+						From:    "path/to/source/",
+						To:      "path/to/destination/",
+						Rename:  []string{`^v1/(.*)\.proto$ -> api/$1.proto`},
+						Flatten: true,
+					},
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name: "valid symlinks",
+			conf: &pastaConf{
+				Deps: []*copierConf{
+					{
+						URL:      "https://example.com",
+						From:     "path/to/source/",
+						To:       "path/to/destination/",
+						Symlinks: "follow",
+					},
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name: "invalid symlinks",
+			conf: &pastaConf{
+				Deps: []*copierConf{
+					{
+						URL:      "https://example.com",
+						From:     "path/to/source/",
+						To:       "path/to/destination/",
+						Symlinks: "copy",
+					},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid rename without separator",
+			conf: &pastaConf{
+				Deps: []*copierConf{
+					{
+						URL:    "https://example.com",
+						From:   "path/to/source/",
+						To:     "path/to/destination/",
+						Rename: []string{`^v1/(.*)$`},
+					},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid rename regexp",
+			conf: &pastaConf{
+				Deps: []*copierConf{
+					{
+						URL:    "https://example.com",
+						From:   "path/to/source/",
+						To:     "path/to/destination/",
+						Rename: []string{`^v1/(.*$ -> $1`},
+					},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "valid github enterprise config",
+			conf: &pastaConf{
+				Github: &githubConf{
+					APIURL: "https://git.corp.example/api/v3/",
+					Hosts:  []string{"git.corp.example"},
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name: "invalid github api_url",
+			conf: &pastaConf{
+				Github: &githubConf{APIURL: "git.corp.example/api/v3/"},
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid github host",
+			conf: &pastaConf{
+				Github: &githubConf{Hosts: []string{"https://git.corp.example"}},
+			},
+			wantErr: true,
+		},
+		{
+			name: "valid gitlab config",
+			conf: &pastaConf{
+				Gitlab: &gitlabConf{Hosts: []string{"gitlab.corp.example"}},
+			},
+			wantErr: false,
+		},
+		{
+			name: "gitlab config without api_url and hosts",
+			conf: &pastaConf{
+				Gitlab: &gitlabConf{},
+			},
+			wantErr: true,
+		},
+		{
+			name: "valid plugin",
+			conf: &pastaConf{
+				Plugins: []pluginConf{{Name: "s3"}, {Name: "rsync", Path: "tools/pasta-copier-rsync"}},
+			},
+			wantErr: false,
+		},
+		{
+			name: "plugin without name",
+			conf: &pastaConf{
+				Plugins: []pluginConf{{Path: "tools/pasta-copier-rsync"}},
+			},
+			wantErr: true,
+		},
+		{
+			name: "plugin name with path separator",
+			conf: &pastaConf{
+				Plugins: []pluginConf{{Name: "../s3"}},
+			},
+			wantErr: true,
+		},
+		{
+			name: "api_url of unknown type",
+			conf: &pastaConf{
+				Deps: []*copierConf{{URL: "https://git.corp.example/o/r", From: "proto/", To: "out/", Options: map[string]string{"api_url": "https://git.corp.example/api"}}},
+			},
+			wantErr: true,
+		},
+		{
+			name: "api_url with instance",
+			conf: &pastaConf{
+				Deps: []*copierConf{{URL: "https://git.corp.example/o/r", From: "proto/", To: "out/", Options: map[string]string{"api_url": "https://git.corp.example/api", "instance": "gitlab"}}},
+			},
+			wantErr: false,
+		},
+		{
+			name: "unknown instance",
+			conf: &pastaConf{
+				Deps: []*copierConf{{URL: "https://git.corp.example/o/r", From: "proto/", To: "out/", Options: map[string]string{"instance": "gitea"}}},
+			},
+			wantErr: true,
+		},
 	}
 
 	for _, tt := range tests {
@@ -224,3 +557,221 @@ func TestPastaConfValidate(t *testing.T) {
 		})
 	}
 }
+
+func TestSelfHostedCopier(t *testing.T) {
+	conf := &pastaConf{
+		Github: &githubConf{Hosts: []string{"git.corp.example"}},
+		Gitlab: &gitlabConf{Hosts: []string{"gitlab.corp.example"}},
+	}
+
+	tests := []struct {
+		url     string
+		options map[string]string
+		want    string
+	}{
+		{url: "https://github.com/o/r", want: "<nil>"},
+		{url: "https://gitlab.com/g/p", want: "<nil>"},
+		{url: "https://git.corp.example/o/r", want: "*github.Copier"},
+		{url: "https://gitlab.corp.example/g/s/p", want: "*gitlab.Copier"},
+		{url: "https://other.example/o/r", options: map[string]string{"api_url": "https://other.example/api/v3/"}, want: "*github.Copier"},
+		{url: "https://other.example/g/p", options: map[string]string{"api_url": "https://other.example/api/v4"}, want: "*gitlab.Copier"},
+		{url: "https://other.example/g/p", options: map[string]string{"api_url": "https://other.example/gl", "instance": "gitlab"}, want: "*gitlab.Copier"},
+		{url: "https://other.example/o/r", options: map[string]string{"api_url": "https://other.example/gh", "instance": "github"}, want: "*github.Copier"},
+		{url: "https://other.example/o/r.git", want: "<nil>"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.url, func(t *testing.T) {
+			got := fmt.Sprintf("%T", conf.selfHostedCopier(&copierConf{URL: tt.url, Options: tt.options}))
+			if got != tt.want {
+				t.Errorf("selfHostedCopier() = %v, expected %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewPastaConfGitlabAPIURL(t *testing.T) {
+	pathToYaml := filepath.Join(t.TempDir(), "pasta.yaml")
+
+	err := os.WriteFile(pathToYaml, []byte(`
+deps:
+  - url: https://gitlab.corp.example/g/p
+    from: proto/
+    to: out/
+    options:
+      api_url: https://gitlab.corp.example/api/v4
+`), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conf, err := newPastaConf(pathToYaml)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { os.RemoveAll(conf.dependencies[0].Option.TempDir) })
+
+	if _, ok := conf.dependencies[0].Copier.(*gitlab.Copier); !ok {
+		t.Errorf("dependency is copied by %T, expected *gitlab.Copier", conf.dependencies[0].Copier)
+	}
+}
+
+func TestFilesConf(t *testing.T) {
+	var conf copierConf
+
+	err := yaml.Unmarshal([]byte(`
+files:
+  - README.md
+  - src: LICENSE
+    dst: third_party/foo/LICENSE.upstream
+flatten: true
+`), &conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []fileConf{{Src: "README.md"}, {Src: "LICENSE", Dst: "third_party/foo/LICENSE.upstream"}}
+	if !reflect.DeepEqual(conf.Files, want) {
+		t.Fatalf("files = %+v, expected %+v", conf.Files, want)
+	}
+
+	rewrite, err := conf.rewrite()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for p, want := range map[string]string{
+		"LICENSE":        "third_party/foo/LICENSE.upstream",
+		"docs/README.md": "README.md",
+	} {
+		if got := rewrite(p); got != want {
+			t.Errorf("rewrite(%v) = %v, expected %v", p, got, want)
+		}
+	}
+}
+
+func TestGlobsKeep(t *testing.T) {
+	conf := &copierConf{
+		IncludeGlob: []string{"**/*.{png,svg}"},
+		ExcludeGlob: []string{"testdata/", "!testdata/keep.png"},
+	}
+
+	options, err := conf.ToCopierOptions()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for p, want := range map[string]bool{
+		"a.png":              true,
+		"img/b.svg":          true,
+		"a.jpg":              false,
+		"testdata/x.png":     false,
+		"testdata/keep.png":  true,
+		"sub/testdata/y.svg": false,
+	} {
+		if got := options.Keep(p); got != want {
+			t.Errorf("Keep(%v) = %v, expected %v", p, got, want)
+		}
+	}
+}
+
+func TestIncludeExcludeLists(t *testing.T) {
+	var conf copierConf
+
+	err := yaml.Unmarshal([]byte(`
+include:
+  - a/.*
+  - b/.*\.proto
+exclude: a/internal/.*
+`), &conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(conf.Include) != 2 || !reflect.DeepEqual(conf.Exclude, patternsConf{"a/internal/.*"}) {
+		t.Fatalf("include = %q, exclude = %q", conf.Include, conf.Exclude)
+	}
+
+	options, err := conf.ToCopierOptions()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for p, want := range map[string]bool{
+		"a/x.txt":          true,
+		"b/y.proto":        true,
+		"b/y.txt":          false,
+		"a/internal/z.txt": false,
+	} {
+		if got := options.Keep(p); got != want {
+			t.Errorf("Keep(%v) = %v, expected %v", p, got, want)
+		}
+	}
+
+	// errors name the failing pattern
+	conf.Exclude = patternsConf{"c/.*", "d/(.*"}
+	if _, err := conf.ToCopierOptions(); err == nil || !strings.HasPrefix(err.Error(), "exclude 1:") {
+		t.Errorf("ToCopierOptions() = %v, expected error of exclude 1", err)
+	}
+}
+
+func TestUsePlugins(t *testing.T) {
+	root := t.TempDir()
+	bin := t.TempDir()
+
+	// answers matches requests for urls starting with scheme, and nothing else
+	writePlugin := func(dir, name, scheme string) {
+		script := "#!/bin/sh\nif grep -q '\"url\":\"" + scheme + "://'; then echo '{\"matches\": true}'; else echo '{}'; fi\n"
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(script), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	writePlugin(root, "declared", "corp")
+	writePlugin(bin, "pasta-copier-s3", "s3")
+	writePlugin(bin, "pasta-copier-corp", "other")
+	writePlugin(bin, "pasta-copier-mirror", "https")
+
+	conf := &pastaConf{Plugins: []pluginConf{{Name: "corp", Path: "declared"}}}
+	base := pasta.NewRegistry()
+
+	if err := conf.usePlugins(base, root, bin); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		url  string
+		want string
+	}{
+		{url: "corp://protocol", want: filepath.Join(root, "declared")},
+		{url: "s3://bucket/protocol", want: filepath.Join(bin, "pasta-copier-s3")},
+		// the plugin on the search path is shadowed by the declared one
+		{url: "other://protocol", want: ""},
+		// plugins on the search path don't take over urls of built-in copiers
+		{url: "https://github.com/o/r", want: "*github.Copier"},
+		{url: "https://corp.example/protocol", want: filepath.Join(bin, "pasta-copier-mirror")},
+	}
+
+	for _, tt := range tests {
+		c, err := conf.registry.Find(tt.url)
+
+		got := fmt.Sprintf("%T", c)
+		if p, ok := c.(*plugin.Copier); ok {
+			got = p.Path
+		}
+
+		if tt.want == "" && err == nil || tt.want != "" && got != tt.want {
+			t.Errorf("Find(%v) = %v, %v, expected %v", tt.url, got, err, tt.want)
+		}
+	}
+
+	if _, err := base.Find("s3://bucket/protocol"); err == nil {
+		t.Errorf("plugins were registered to the base registry")
+	}
+
+	conf.Plugins = []pluginConf{{Name: "missing"}}
+	if err := conf.usePlugins(base, root, bin); err == nil {
+		t.Errorf("usePlugins() succeeded with a missing plugin")
+	}
+}
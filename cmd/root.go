@@ -5,6 +5,7 @@ import (
 	_ "embed"
 	"errors"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 
@@ -17,8 +18,10 @@ const pastayaml = "pasta.yaml"
 var errCouldnFindPastaFile = errors.New("can't find '" + pastayaml + "'")
 
 var (
-	dryRunFlag  bool
-	versionFlag bool
+	dryRunFlag    bool
+	versionFlag   bool
+	changelogFlag string
+	offlineFlag   bool
 )
 
 var (
@@ -26,6 +29,10 @@ var (
 	version string
 )
 
+// Registry holds the copiers dependencies are copied with, besides plugins. Programs embedding
+// pasta can register their own copiers before calling Execute.
+var Registry = pasta.NewRegistry()
+
 var RootCmd = &cobra.Command{
 	Use:   "pasta",
 	Short: "pasta - copy files between repositories",
@@ -41,37 +48,91 @@ var RootCmd = &cobra.Command{
 			os.Exit(0)
 		}
 
-		pathToYaml, err := findPastaFile()
+		pathToYaml, cfg := loadPastaConf()
 
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error trying to find pasta file: %v\n", err)
-			os.Exit(-1)
-		}
+		runPasta(pathToYaml, cfg)
+	},
+}
 
-		cfg, err := newPastaConf(pathToYaml)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error while parsing config: %v\n", err)
-			os.Exit(-2)
-		}
+// loadPastaConf finds and parses the pasta file, exiting if that fails.
+func loadPastaConf() (string, *pastaConf) {
+	pathToYaml, err := findPastaFile()
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error trying to find pasta file: %v\n", err)
+		os.Exit(-1)
+	}
+
+	cfg, err := newPastaConf(pathToYaml)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error while parsing config: %v\n", err)
+		os.Exit(-2)
+	}
+
+	if err = cfg.usePlugins(Registry, filepath.Dir(pathToYaml), os.Getenv("PATH")); err != nil {
+		fmt.Fprintf(os.Stderr, "Error while loading plugins: %v\n", err)
+		os.Exit(-2)
+	}
+
+	cfg.useCache(openCache())
+
+	if offlineFlag {
+		cfg.goOffline()
+	}
 
-		if len(cfg.Deps) == 0 {
-			fmt.Printf("No dependencies found in '%s'\n", pathToYaml)
+	return pathToYaml, cfg
+}
+
+// runPasta copies all dependencies of cfg, exiting if that fails.
+func runPasta(pathToYaml string, cfg *pastaConf) {
+	if len(cfg.Deps) == 0 {
+		fmt.Printf("No dependencies found in '%s'\n", pathToYaml)
+
+		// still run if there is a result, files of removed dependencies are cleaned up
+		if _, err := os.Stat(filepath.Join(filepath.Dir(pathToYaml), pasta.ResultFile)); err != nil {
 			return
 		}
+	}
 
-		if dryRunFlag {
-			fmt.Println("--dry-run is set, here's what would happen:")
-			fmt.Println()
-		}
+	if dryRunFlag {
+		fmt.Println("--dry-run is set, here's what would happen:")
+		fmt.Println()
+	}
 
-		ctx := context.Background()
-		err = pasta.Run(ctx, cfg.dependencies, dryRunFlag, cfg.KeepDirs, pathToYaml)
+	var changelog io.Writer
 
+	switch changelogFlag {
+	case "":
+	case "-":
+		changelog = os.Stdout
+	default:
+		f, err := os.Create(changelogFlag)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error while running pasta: %v\n", err)
+			fmt.Fprintf(os.Stderr, "Error creating changelog: %v\n", err)
 			os.Exit(-4)
 		}
-	},
+
+		defer f.Close()
+		changelog = f
+	}
+
+	ctx := context.Background()
+	_, err := pasta.Run(ctx, pasta.Options{
+		Registry:  cfg.registry,
+		Deps:      cfg.dependencies,
+		PastaFile: pathToYaml,
+		DryRun:    dryRunFlag,
+		KeepDirs:  cfg.KeepDirs,
+		Output:    os.Stdout,
+		Changelog: changelog,
+	})
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error while running pasta: %v\n", err)
+		os.Exit(-4)
+	}
+
+	pruneCache(cfg.cache)
 }
 
 func Execute() {
@@ -123,7 +184,20 @@ func findPastaFile() (string, error) {
 	}
 }
 
+// addChangelogFlag adds the --changelog flag to cmd. Without a value, the changelog is printed.
+func addChangelogFlag(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&changelogFlag, "changelog", "", "write a markdown summary of upstream commits of dependencies copied at another reference to the given file, or print it")
+	cmd.Flags().Lookup("changelog").NoOptDefVal = "-"
+}
+
+// addOfflineFlag adds the --offline flag to cmd.
+func addOfflineFlag(cmd *cobra.Command) {
+	cmd.Flags().BoolVar(&offlineFlag, "offline", false, "don't access the network, copy the references recorded in "+pasta.ResultFile+" from the cache")
+}
+
 func init() {
 	RootCmd.Flags().BoolVar(&dryRunFlag, "dry-run", false, "don't do anything, just print what would be done")
+	addChangelogFlag(RootCmd)
+	addOfflineFlag(RootCmd)
 	RootCmd.Flags().BoolVar(&versionFlag, "version", false, "shows the version of pasta")
 }
@@ -0,0 +1,57 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+var updateCmd = &cobra.Command{
+	Use:   "update [dep...]",
+	Short: "update resolves the refs of dependencies again and updates the lock in pasta.result.yaml",
+	Long: `update resolves the refs of dependencies again and updates the lock in pasta.result.yaml.
+
+Dependencies can be selected by their url or their 'to' directory. If none are given, all
+dependencies are updated.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		pathToYaml, cfg := loadPastaConf()
+
+		for _, arg := range args {
+			if !selectForUpdate(cfg, arg) {
+				fmt.Fprintf(os.Stderr, "No dependency with url or 'to' directory '%s' found in '%s'\n", arg, pathToYaml)
+				os.Exit(-3)
+			}
+		}
+
+		if len(args) == 0 {
+			for i := range cfg.dependencies {
+				cfg.dependencies[i].Update = true
+			}
+		}
+
+		runPasta(pathToYaml, cfg)
+	},
+}
+
+// selectForUpdate marks all dependencies matching name for update. Returns false if
+// no dependency matches.
+func selectForUpdate(cfg *pastaConf, name string) bool {
+	found := false
+
+	for i, dep := range cfg.Deps {
+		if dep.URL == name || strings.TrimSuffix(dep.To, "/") == strings.TrimSuffix(name, "/") {
+			cfg.dependencies[i].Update = true
+			found = true
+		}
+	}
+
+	return found
+}
+
+func init() {
+	updateCmd.Flags().BoolVar(&dryRunFlag, "dry-run", false, "don't do anything, just print what would be done")
+	addChangelogFlag(updateCmd)
+	RootCmd.AddCommand(updateCmd)
+}
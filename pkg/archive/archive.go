@@ -0,0 +1,274 @@
+package archive
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/audiotool/pasta/pkg/copier"
+	"github.com/audiotool/pasta/pkg/utils"
+)
+
+// Copier downloads an archive from a http(s) url, and extracts files from it.
+type Copier struct{}
+
+// SourceInfo is written to pasta.result.yaml for archive dependencies.
+type SourceInfo struct {
+	// Reference is the sha256 digest of the archive, prefixed with "sha256:"
+	Reference    string `yaml:"reference"`
+	URL          string `yaml:"url"`
+	ETag         string `yaml:"etag,omitempty"`
+	LastModified string `yaml:"last_modified,omitempty"`
+}
+
+var archiveRegexp = regexp.MustCompile(`^https?://[^?#]*\.(tar\.gz|tgz|zip)([?#].*)?$`)
+
+func (*Copier) Matches(url string) bool {
+	return archiveRegexp.MatchString(url)
+}
+
+func (*Copier) Copy(ctx context.Context, config copier.CopyConfig) (any, error) {
+	archive, info, cached := restoreArchive(config)
+
+	if !cached && config.Offline {
+		if config.Locked == "" {
+			return nil, fmt.Errorf("%w: archive must be downloaded, but the dependency isn't locked", copier.ErrOffline)
+		}
+
+		return nil, fmt.Errorf("%w: archive with digest %v isn't cached", copier.ErrOffline, config.Locked)
+	}
+
+	if !cached {
+		f, err := os.CreateTemp("", "pasta-archive")
+		if err != nil {
+			return nil, fmt.Errorf("error creating temp file: %v", err)
+		}
+
+		f.Close()
+		defer os.Remove(f.Name())
+
+		header, err := utils.DownloadFile(ctx, config.URL, f.Name())
+		if err != nil {
+			return nil, err
+		}
+
+		archive = f.Name()
+		info = &SourceInfo{
+			URL:          config.URL,
+			ETag:         header.Get("ETag"),
+			LastModified: header.Get("Last-Modified"),
+		}
+	}
+
+	hash, err := utils.HashFile(archive)
+	if err != nil {
+		return nil, fmt.Errorf("error hashing archive: %v", err)
+	}
+
+	if want := config.Options["sha256"]; want != "" && !strings.EqualFold(want, hash) {
+		return nil, fmt.Errorf("sha256 of archive is %v, but option sha256 is %v", hash, want)
+	}
+
+	info.Reference = "sha256:" + hash
+
+	if config.Locked != "" && config.Locked != info.Reference {
+		return nil, fmt.Errorf("archive changed since it was locked: digest is %v, expected %v", info.Reference, config.Locked)
+	}
+
+	if !cached {
+		// the cache only saves downloads, failing to fill it is no error
+		if config.Cache.PutFile(cacheName, config.URL, "sha256-"+hash, archive) == nil {
+			_ = config.Cache.PutJSON(cacheName, config.URL, "info-sha256-"+hash, info)
+		}
+	}
+
+	root := utils.Root{Dir: config.TempDir}
+
+	extract := func(dest func(name, link string) string) error {
+		if isZip(config.URL) {
+			return utils.ExtractZip(archive, root, dest)
+		}
+
+		return extractTarGz(archive, root, dest)
+	}
+
+	// followed symlinks, by the path inside of the archive they point to
+	follow := make(map[string][]string)
+	var linkErrs []error
+
+	err = extract(func(name, link string) string {
+		// archives often contain paths like ./foo
+		name = path.Clean(name)
+
+		if !strings.HasPrefix(name, config.From) {
+			return ""
+		}
+
+		relp, err := filepath.Rel(config.From, name)
+		if err != nil || !filepath.IsLocal(relp) {
+			return ""
+		}
+
+		relp = filepath.ToSlash(relp)
+
+		// check if user wants to keep this file based on relp
+		if !config.Keep(relp) {
+			return ""
+		}
+
+		switch {
+		case link == "":
+		case config.Symlinks == copier.SymlinksSkip:
+			return ""
+		case config.Symlinks == copier.SymlinksFollow:
+			target, err := copier.ResolveSymlink(name, link)
+			if err != nil {
+				linkErrs = append(linkErrs, err)
+			} else {
+				follow[target] = append(follow[target], relp)
+			}
+
+			return ""
+		}
+
+		return relp
+	})
+
+	if err == nil {
+		err = errors.Join(linkErrs...)
+	}
+
+	if err == nil {
+		err = followSymlinks(extract, root, follow)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("error extracting archive: %v", err)
+	}
+
+	return info, nil
+}
+
+// followSymlinks extracts the files that symlinks point to, which follow maps to the paths
+// of the symlinks. Each symlink in a row takes another pass over the archive.
+func followSymlinks(extract func(dest func(name, link string) string) error, root utils.Root, follow map[string][]string) error {
+	for i := 0; len(follow) > 0; i++ {
+		if i == copier.MaxSymlinks {
+			return fmt.Errorf("too many levels of symlinks")
+		}
+
+		pending := follow
+		follow = make(map[string][]string)
+
+		var copies [][]string
+		var linkErrs []error
+
+		err := extract(func(name, link string) string {
+			name = path.Clean(name)
+
+			relps, ok := pending[name]
+			if !ok {
+				return ""
+			}
+
+			delete(pending, name)
+
+			if link == "" {
+				copies = append(copies, relps)
+				return relps[0]
+			}
+
+			target, err := copier.ResolveSymlink(name, link)
+			if err != nil {
+				linkErrs = append(linkErrs, err)
+			} else {
+				follow[target] = append(follow[target], relps...)
+			}
+
+			return ""
+		})
+
+		if err != nil {
+			return err
+		}
+
+		if err := errors.Join(linkErrs...); err != nil {
+			return err
+		}
+
+		if len(pending) > 0 {
+			missing := make([]string, 0, len(pending))
+			for p := range pending {
+				missing = append(missing, p)
+			}
+			sort.Strings(missing)
+
+			return fmt.Errorf("symlinks point to files missing in the archive: %v", strings.Join(missing, ", "))
+		}
+
+		for _, relps := range copies {
+			for _, relp := range relps[1:] {
+				if err := root.CopyFile(filepath.Join(root.Dir, filepath.FromSlash(relps[0])), relp); err != nil {
+					return fmt.Errorf("error copying %v: %w", relps[0], err)
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// cacheName is the name the copier stores its entries under in the cache.
+const cacheName = "archive"
+
+// restoreArchive returns the path of the cached archive and its info, if its digest is
+// known before downloading it: from the lock, or from the option sha256.
+func restoreArchive(config copier.CopyConfig) (string, *SourceInfo, bool) {
+	digest := strings.TrimPrefix(config.Locked, "sha256:")
+	if digest == "" {
+		digest = strings.ToLower(config.Options["sha256"])
+	}
+
+	if digest == "" {
+		return "", nil, false
+	}
+
+	var info SourceInfo
+	if !config.Cache.GetJSON(cacheName, config.URL, "info-sha256-"+digest, &info) {
+		return "", nil, false
+	}
+
+	archive, ok := config.Cache.Lookup(cacheName, config.URL, "sha256-"+digest)
+	if !ok {
+		return "", nil, false
+	}
+
+	// a damaged entry is downloaded again
+	if hash, err := utils.HashFile(archive); err != nil || hash != digest {
+		return "", nil, false
+	}
+
+	return archive, &info, true
+}
+
+// isZip returns true if url points to a zip archive, false for gzip compressed tar archives.
+func isZip(url string) bool {
+	return archiveRegexp.FindStringSubmatch(url)[1] == "zip"
+}
+
+func extractTarGz(src string, root utils.Root, dest func(name, link string) string) error {
+	f, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+
+	defer f.Close()
+
+	return utils.ExtractTarGz(f, root, dest)
+}
@@ -0,0 +1,427 @@
+package archive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/audiotool/pasta/pkg/cache"
+	"github.com/audiotool/pasta/pkg/copier"
+)
+
+var archiveFiles = map[string]string{
+	"bundle-1.0/README.md":          "readme",
+	"bundle-1.0/schemas/a.json":     "a",
+	"bundle-1.0/schemas/sub/b.json": "b",
+	"bundle-1.0/schemas/notes.txt":  "notes",
+	"../escape.json":                "evil",
+}
+
+func sortedNames(files map[string]string) []string {
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// mode returns the mode of the archive entry name with content: content starting with "-> "
+// makes it a symlink to the rest of it, names ending in .sh make it executable.
+func mode(name, content string) fs.FileMode {
+	switch {
+	case strings.HasPrefix(content, "-> "):
+		return fs.ModeSymlink | 0o777
+	case strings.HasSuffix(name, ".sh"):
+		return 0o755
+	default:
+		return 0o644
+	}
+}
+
+func tarGz(t *testing.T, files map[string]string) []byte {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+
+	for _, name := range sortedNames(files) {
+		m := mode(name, files[name])
+
+		if m&fs.ModeSymlink != 0 {
+			err := tw.WriteHeader(&tar.Header{Name: "./" + name, Mode: 0o777, Linkname: strings.TrimPrefix(files[name], "-> "), Typeflag: tar.TypeSymlink})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			continue
+		}
+
+		err := tw.WriteHeader(&tar.Header{Name: "./" + name, Mode: int64(m), Size: int64(len(files[name])), Typeflag: tar.TypeReg})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := tw.Write([]byte(files[name])); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.Bytes()
+}
+
+func zipArchive(t *testing.T, files map[string]string) []byte {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+
+	for _, name := range sortedNames(files) {
+		header := &zip.FileHeader{Name: name, Method: zip.Deflate}
+		header.SetMode(mode(name, files[name]))
+
+		w, err := zw.CreateHeader(header)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// the content of symlinks is their target
+		if _, err := w.Write([]byte(strings.TrimPrefix(files[name], "-> "))); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.Bytes()
+}
+
+func readTree(t *testing.T, dir string) map[string]string {
+	files := make(map[string]string)
+
+	err := filepath.WalkDir(dir, func(p string, d os.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+
+		content, err := os.ReadFile(p)
+		if err != nil {
+			return err
+		}
+
+		rel, _ := filepath.Rel(dir, p)
+		files[filepath.ToSlash(rel)] = string(content)
+		return nil
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return files
+}
+
+func digest(b []byte) string {
+	h := sha256.Sum256(b)
+	return hex.EncodeToString(h[:])
+}
+
+func TestMatches(t *testing.T) {
+	tests := []struct {
+		url     string
+		matches bool
+	}{
+		{"https://example.com/schemas.tar.gz", true},
+		{"http://example.com/fonts.tgz", true},
+		{"https://example.com/dl/fonts.zip?version=2", true},
+		{"https://github.com/foo/bar/releases/download/v1/bundle.tar.gz", true},
+		{"https://github.com/foo/bar", false},
+		{"https://example.com/schemas.tar", false},
+		{"https://example.com/download?file=schemas.zip", false},
+		{"file:///srv/schemas.zip", false},
+	}
+
+	for _, tt := range tests {
+		if got := (&Copier{}).Matches(tt.url); got != tt.matches {
+			t.Errorf("Matches(%v) = %v, expected %v", tt.url, got, tt.matches)
+		}
+	}
+}
+
+func TestCopy(t *testing.T) {
+	tgz := tarGz(t, archiveFiles)
+	zipped := zipArchive(t, archiveFiles)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"v1"`)
+		w.Header().Set("Last-Modified", "Mon, 02 Jan 2006 15:04:05 GMT")
+
+		switch r.URL.Path {
+		case "/bundle.tar.gz":
+			w.Write(tgz)
+		case "/bundle.zip":
+			w.Write(zipped)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	jsons := func(p string) bool { return strings.HasSuffix(p, ".json") }
+	want := map[string]string{"a.json": "a", "sub/b.json": "b"}
+
+	tests := []struct {
+		name    string
+		url     string
+		options map[string]string
+		locked  string
+		digest  string
+		wantErr bool
+	}{
+		{name: "tar.gz", url: srv.URL + "/bundle.tar.gz", digest: digest(tgz)},
+		{name: "zip", url: srv.URL + "/bundle.zip", digest: digest(zipped)},
+		{name: "matching sha256", url: srv.URL + "/bundle.zip", options: map[string]string{"sha256": strings.ToUpper(digest(zipped))}, digest: digest(zipped)},
+		{name: "locked", url: srv.URL + "/bundle.zip", locked: "sha256:" + digest(zipped), digest: digest(zipped)},
+		{name: "wrong sha256", url: srv.URL + "/bundle.zip", options: map[string]string{"sha256": digest(tgz)}, wantErr: true},
+		{name: "changed since locked", url: srv.URL + "/bundle.zip", locked: "sha256:" + digest(tgz), wantErr: true},
+		{name: "not found", url: srv.URL + "/missing.zip", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tmp := t.TempDir()
+
+			info, err := (&Copier{}).Copy(context.Background(), copier.CopyConfig{
+				URL:     tt.url,
+				From:    "bundle-1.0/schemas/",
+				Keep:    jsons,
+				Options: tt.options,
+				TempDir: tmp,
+				Locked:  tt.locked,
+			})
+
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Copy() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if tt.wantErr {
+				return
+			}
+
+			if files := readTree(t, tmp); !reflect.DeepEqual(files, want) {
+				t.Errorf("Copy() files = %v, expected %v", files, want)
+			}
+
+			expected := &SourceInfo{
+				Reference:    "sha256:" + tt.digest,
+				URL:          tt.url,
+				ETag:         `"v1"`,
+				LastModified: "Mon, 02 Jan 2006 15:04:05 GMT",
+			}
+
+			if !reflect.DeepEqual(info, expected) {
+				t.Errorf("Copy() source info = %+v, expected %+v", info, expected)
+			}
+		})
+	}
+}
+
+func TestCopyDoesNotEscape(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(tarGz(t, archiveFiles))
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	tmp := filepath.Join(dir, "tmp")
+
+	_, err := (&Copier{}).Copy(context.Background(), copier.CopyConfig{
+		URL:     srv.URL + "/bundle.tar.gz",
+		Keep:    func(string) bool { return true },
+		TempDir: tmp,
+	})
+	if err != nil {
+		t.Fatalf("Copy() error = %v", err)
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "escape.json")); !os.IsNotExist(err) {
+		t.Errorf("archive entry escaped the temp directory")
+	}
+}
+
+func TestCopySymlinks(t *testing.T) {
+	files := map[string]string{
+		"bundle/a.json":      "a",
+		"bundle/run.sh":      "#!/bin/sh",
+		"bundle/link.json":   "-> a.json",
+		"bundle/chain.json":  "-> link.json",
+		"bundle/up.json":     "-> ../c.json",
+		"bundle/escape.json": "-> ../../outside.json",
+		"c.json":             "c",
+	}
+
+	tgz := tarGz(t, files)
+	zipped := zipArchive(t, files)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/bundle.tar.gz":
+			w.Write(tgz)
+		default:
+			w.Write(zipped)
+		}
+	}))
+	defer srv.Close()
+
+	tests := []struct {
+		symlinks string
+		keep     []string
+		// want maps the copied files to their content, or the target of symlinks prefixed with "-> "
+		want    map[string]string
+		wantErr bool
+	}{
+		{symlinks: "", keep: []string{"a.json", "run.sh", "link.json", "chain.json"}, want: map[string]string{"a.json": "a", "run.sh": "#!/bin/sh", "link.json": "-> a.json", "chain.json": "-> link.json"}},
+		{symlinks: copier.SymlinksPreserve, keep: []string{"up.json"}, wantErr: true},
+		{symlinks: copier.SymlinksFollow, keep: []string{"link.json", "chain.json", "up.json"}, want: map[string]string{"link.json": "a", "chain.json": "a", "up.json": "c"}},
+		{symlinks: copier.SymlinksFollow, keep: []string{"escape.json"}, wantErr: true},
+		{symlinks: copier.SymlinksSkip, keep: []string{"a.json", "link.json", "escape.json"}, want: map[string]string{"a.json": "a"}},
+	}
+
+	for _, tt := range tests {
+		for _, url := range []string{srv.URL + "/bundle.tar.gz", srv.URL + "/bundle.zip"} {
+			tmp := t.TempDir()
+
+			_, err := (&Copier{}).Copy(context.Background(), copier.CopyConfig{
+				URL:  url,
+				From: "bundle/",
+				Keep: func(p string) bool {
+					for _, k := range tt.keep {
+						if k == p {
+							return true
+						}
+					}
+					return false
+				},
+				TempDir:  tmp,
+				Symlinks: tt.symlinks,
+			})
+
+			if (err != nil) != tt.wantErr {
+				t.Errorf("%v, symlinks %q, keep %v: Copy() error = %v, expected error = %v", url, tt.symlinks, tt.keep, err, tt.wantErr)
+				continue
+			}
+
+			if tt.wantErr {
+				continue
+			}
+
+			got := make(map[string]string)
+			entries, _ := os.ReadDir(tmp)
+			for _, e := range entries {
+				p := filepath.Join(tmp, e.Name())
+
+				if target, err := os.Readlink(p); err == nil {
+					got[e.Name()] = "-> " + target
+					continue
+				}
+
+				bs, _ := os.ReadFile(p)
+				got[e.Name()] = string(bs)
+
+				info, _ := os.Stat(p)
+				if strings.HasSuffix(p, ".sh") != (info.Mode()&0o100 != 0) {
+					t.Errorf("%v: %v has mode %v", url, e.Name(), info.Mode())
+				}
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("%v, symlinks %q: Copy() = %v, expected %v", url, tt.symlinks, got, tt.want)
+			}
+		}
+	}
+}
+
+func TestCopyCached(t *testing.T) {
+	tgz := tarGz(t, archiveFiles)
+	downloads := 0
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		downloads++
+		w.Header().Set("ETag", `"v1"`)
+		w.Write(tgz)
+	}))
+	defer srv.Close()
+
+	c := &cache.Cache{Dir: t.TempDir()}
+
+	copyBundle := func(locked string) (any, map[string]string) {
+		tmp := t.TempDir()
+
+		info, err := (&Copier{}).Copy(context.Background(), copier.CopyConfig{
+			URL:     srv.URL + "/bundle.tar.gz",
+			From:    "bundle-1.0/schemas/",
+			Keep:    func(string) bool { return true },
+			TempDir: tmp,
+			Locked:  locked,
+			Cache:   c,
+		})
+
+		if err != nil {
+			t.Fatalf("Copy() error = %v", err)
+		}
+
+		return info, readTree(t, tmp)
+	}
+
+	// the digest is only known after the first download
+	downloaded, files := copyBundle("")
+	copyBundle("")
+
+	if downloads != 2 {
+		t.Errorf("archive was downloaded %v times, expected 2", downloads)
+	}
+
+	cached, cachedFiles := copyBundle("sha256:" + digest(tgz))
+
+	if downloads != 2 {
+		t.Errorf("locked archive was downloaded again")
+	}
+
+	if !reflect.DeepEqual(cached, downloaded) || !reflect.DeepEqual(cachedFiles, files) {
+		t.Errorf("Copy() from cache = %+v %v, expected %+v %v", cached, cachedFiles, downloaded, files)
+	}
+
+	// offline, archives that aren't cached can't be copied
+	_, err := (&Copier{}).Copy(context.Background(), copier.CopyConfig{
+		URL:     srv.URL + "/other.tar.gz",
+		Keep:    func(string) bool { return true },
+		TempDir: t.TempDir(),
+		Locked:  "sha256:" + digest(tgz),
+		Cache:   c,
+		Offline: true,
+	})
+
+	if !errors.Is(err, copier.ErrOffline) || downloads != 2 {
+		t.Errorf("Copy() of uncached archive offline error = %v, expected %v without download", err, copier.ErrOffline)
+	}
+}
@@ -0,0 +1,331 @@
+// Package cache stores downloaded content across runs of pasta, so that content which
+// didn't change isn't downloaded again, even by other repositories on the same machine.
+//
+// Entries are identified by the copier that stored them, the repository they belong to
+// and a key, like the sha of a blob or the digest of an archive. Keys must identify the
+// content uniquely within the repository, entries are never updated.
+package cache
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Cache is a directory of cached entries. A nil *Cache caches nothing.
+type Cache struct {
+	// Dir contains one directory per copier, containing one directory per repository
+	Dir string
+	// MaxSize is the number of bytes the cache is pruned to after every run, 0 for no limit
+	MaxSize int64
+}
+
+// DefaultMaxSize is the size limit of the default cache, unless set by PASTA_CACHE_SIZE.
+const DefaultMaxSize = 1 << 30
+
+// Default returns the cache in $PASTA_CACHE_DIR, or in the directory "pasta" of the
+// user's cache directory, e.g. $XDG_CACHE_HOME/pasta. Its size is limited to
+// $PASTA_CACHE_SIZE, or DefaultMaxSize.
+func Default() (*Cache, error) {
+	c := &Cache{Dir: os.Getenv("PASTA_CACHE_DIR"), MaxSize: DefaultMaxSize}
+
+	if c.Dir == "" {
+		dir, err := os.UserCacheDir()
+		if err != nil {
+			return nil, fmt.Errorf("error finding cache directory: %w", err)
+		}
+
+		c.Dir = filepath.Join(dir, "pasta")
+	}
+
+	if s := os.Getenv("PASTA_CACHE_SIZE"); s != "" {
+		size, err := ParseSize(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid PASTA_CACHE_SIZE: %w", err)
+		}
+
+		c.MaxSize = size
+	}
+
+	return c, nil
+}
+
+// Get returns the content of an entry, and whether it was found.
+func (c *Cache) Get(copier, repo, key string) ([]byte, bool) {
+	p, ok := c.Lookup(copier, repo, key)
+	if !ok {
+		return nil, false
+	}
+
+	bs, err := os.ReadFile(p)
+	if err != nil {
+		return nil, false
+	}
+
+	return bs, true
+}
+
+// Lookup returns the path of an entry, and whether it was found. The file must not be modified.
+func (c *Cache) Lookup(copier, repo, key string) (string, bool) {
+	p, ok := c.path(copier, repo, key)
+	if !ok {
+		return "", false
+	}
+
+	if _, err := os.Stat(p); err != nil {
+		return "", false
+	}
+
+	// the modification time marks when an entry was used last, for pruning
+	now := time.Now()
+	_ = os.Chtimes(p, now, now)
+
+	return p, true
+}
+
+// Put stores data as an entry.
+func (c *Cache) Put(copier, repo, key string, data []byte) error {
+	return c.write(copier, repo, key, func(w io.Writer) error {
+		_, err := w.Write(data)
+		return err
+	})
+}
+
+// PutFile stores the content of the file src as an entry.
+func (c *Cache) PutFile(copier, repo, key, src string) error {
+	return c.write(copier, repo, key, func(w io.Writer) error {
+		f, err := os.Open(src)
+		if err != nil {
+			return err
+		}
+
+		defer f.Close()
+
+		_, err = io.Copy(w, f)
+		return err
+	})
+}
+
+// GetJSON decodes an entry stored with PutJSON into v, and returns whether that succeeded.
+func (c *Cache) GetJSON(copier, repo, key string, v any) bool {
+	bs, ok := c.Get(copier, repo, key)
+	return ok && json.Unmarshal(bs, v) == nil
+}
+
+// PutJSON stores v as an entry, encoded as json.
+func (c *Cache) PutJSON(copier, repo, key string, v any) error {
+	bs, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("error encoding cache entry: %w", err)
+	}
+
+	return c.Put(copier, repo, key, bs)
+}
+
+var errInvalidName = errors.New("invalid name of cache entry")
+
+// write creates an entry with the content written by fill. Entries are written to a
+// temporary file first, so that concurrent runs never see partial entries.
+func (c *Cache) write(copier, repo, key string, fill func(w io.Writer) error) error {
+	if c == nil {
+		return nil
+	}
+
+	p, ok := c.path(copier, repo, key)
+	if !ok {
+		return fmt.Errorf("%w: %v/%v/%v", errInvalidName, copier, repo, key)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(p), os.ModePerm); err != nil {
+		return fmt.Errorf("error creating cache directory: %w", err)
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(p), ".put-*")
+	if err != nil {
+		return fmt.Errorf("error creating cache entry: %w", err)
+	}
+
+	defer os.Remove(f.Name())
+
+	err = fill(f)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		return fmt.Errorf("error writing cache entry: %w", err)
+	}
+
+	if err := os.Rename(f.Name(), p); err != nil {
+		return fmt.Errorf("error writing cache entry: %w", err)
+	}
+
+	return nil
+}
+
+// path returns the path of an entry, and false if any of its names can't be used as file name.
+func (c *Cache) path(copier, repo, key string) (string, bool) {
+	if c == nil {
+		return "", false
+	}
+
+	p := c.Dir
+
+	for _, name := range []string{copier, repo, key} {
+		escaped := url.PathEscape(name)
+
+		// temporary files start with a dot
+		if escaped == "" || strings.HasPrefix(escaped, ".") {
+			return "", false
+		}
+
+		p = filepath.Join(p, escaped)
+	}
+
+	return p, true
+}
+
+// Entry describes an entry of the cache.
+type Entry struct {
+	Copier string
+	Repo   string
+	Key    string
+	Size   int64
+	// Used is when the entry was stored or read last
+	Used time.Time
+}
+
+// Entries returns all entries of the cache, least recently used first.
+func (c *Cache) Entries() ([]Entry, error) {
+	if c == nil {
+		return nil, nil
+	}
+
+	var entries []Entry
+
+	err := filepath.WalkDir(c.Dir, func(p string, d fs.DirEntry, err error) error {
+		if errors.Is(err, fs.ErrNotExist) && p == c.Dir {
+			return fs.SkipAll
+		}
+
+		if err != nil || d.IsDir() || strings.HasPrefix(d.Name(), ".") {
+			return err
+		}
+
+		rel, err := filepath.Rel(c.Dir, p)
+		if err != nil {
+			return err
+		}
+
+		names := strings.Split(filepath.ToSlash(rel), "/")
+		if len(names) != 3 {
+			return nil
+		}
+
+		for i, name := range names {
+			if names[i], err = url.PathUnescape(name); err != nil {
+				return nil
+			}
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			// removed in between, e.g. by a concurrent prune
+			return nil
+		}
+
+		entries = append(entries, Entry{Copier: names[0], Repo: names[1], Key: names[2], Size: info.Size(), Used: info.ModTime()})
+		return nil
+	})
+
+	if err != nil {
+		return nil, fmt.Errorf("error listing cache: %w", err)
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].Used.Before(entries[j].Used)
+	})
+
+	return entries, nil
+}
+
+// Prune removes the least recently used entries until the cache is at most maxSize bytes
+// large, and returns the removed entries.
+func (c *Cache) Prune(maxSize int64) ([]Entry, error) {
+	entries, err := c.Entries()
+	if err != nil {
+		return nil, err
+	}
+
+	var size int64
+	for _, e := range entries {
+		size += e.Size
+	}
+
+	var removed []Entry
+
+	for _, e := range entries {
+		if size <= maxSize {
+			break
+		}
+
+		p, _ := c.path(e.Copier, e.Repo, e.Key)
+		if err := os.Remove(p); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return removed, fmt.Errorf("error removing cache entry: %w", err)
+		}
+
+		// directories of repositories without entries are removed as well, this fails otherwise
+		_ = os.Remove(filepath.Dir(p))
+
+		size -= e.Size
+		removed = append(removed, e)
+	}
+
+	return removed, nil
+}
+
+// Clear removes the cache with all its entries.
+func (c *Cache) Clear() error {
+	if c == nil {
+		return nil
+	}
+
+	if err := os.RemoveAll(c.Dir); err != nil {
+		return fmt.Errorf("error clearing cache: %w", err)
+	}
+
+	return nil
+}
+
+var sizeUnits = map[string]int64{
+	"":  1,
+	"k": 1 << 10,
+	"m": 1 << 20,
+	"g": 1 << 30,
+	"t": 1 << 40,
+}
+
+// ParseSize parses a number of bytes like "500M", "2GiB" or "1048576". Units are binary.
+func ParseSize(s string) (int64, error) {
+	num := strings.TrimRight(strings.TrimSpace(s), "bBiI")
+	unit := strings.TrimLeft(num, "0123456789")
+	num = strings.TrimSpace(strings.TrimSuffix(num, unit))
+
+	factor, ok := sizeUnits[strings.ToLower(strings.TrimSpace(unit))]
+	n, err := strconv.ParseInt(num, 10, 64)
+
+	if !ok || err != nil || n < 0 || n > (1<<62)/factor {
+		return 0, fmt.Errorf("invalid size %q, must be a number of bytes, optionally followed by K, M, G or T", s)
+	}
+
+	return n * factor, nil
+}
@@ -0,0 +1,140 @@
+package cache
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestPutGet(t *testing.T) {
+	c := &Cache{Dir: t.TempDir()}
+
+	if _, ok := c.Get("github", "github.com/o/r", "blob-1"); ok {
+		t.Fatalf("empty cache has entry")
+	}
+
+	if err := c.Put("github", "github.com/o/r", "blob-1", []byte("content")); err != nil {
+		t.Fatal(err)
+	}
+
+	bs, ok := c.Get("github", "github.com/o/r", "blob-1")
+	if !ok || string(bs) != "content" {
+		t.Errorf("Get() = %q, %v, expected %q", bs, ok, "content")
+	}
+
+	// entries of other repositories with the same key are separate
+	if _, ok := c.Get("github", "github.com/o/other", "blob-1"); ok {
+		t.Errorf("entry found for other repository")
+	}
+
+	var v struct{ A int }
+	if err := c.PutJSON("github", "github.com/o/r", "manifest-1", struct{ A int }{A: 42}); err != nil {
+		t.Fatal(err)
+	}
+
+	if !c.GetJSON("github", "github.com/o/r", "manifest-1", &v) || v.A != 42 {
+		t.Errorf("GetJSON() = %+v, expected A = 42", v)
+	}
+}
+
+func TestNil(t *testing.T) {
+	var c *Cache
+
+	if err := c.Put("git", "repo", "key", []byte("x")); err != nil {
+		t.Errorf("Put() on nil cache = %v", err)
+	}
+
+	if _, ok := c.Get("git", "repo", "key"); ok {
+		t.Errorf("nil cache has entry")
+	}
+}
+
+func TestInvalidNames(t *testing.T) {
+	dir := t.TempDir()
+	c := &Cache{Dir: filepath.Join(dir, "cache")}
+
+	for _, key := range []string{"", ".", "..", ".put-123"} {
+		if err := c.Put("git", "repo", key, []byte("x")); err == nil {
+			t.Errorf("Put() with key %q succeeded", key)
+		}
+	}
+
+	// names containing slashes stay inside of their directory
+	if err := c.Put("git", "../..", "key", []byte("x")); err == nil {
+		t.Errorf("Put() with repo ../.. succeeded")
+	}
+
+	if err := c.Put("git", "a/../../b", "key", []byte("x")); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil || len(entries) != 1 {
+		t.Errorf("cache wrote outside of its directory: %v, %v", entries, err)
+	}
+}
+
+func TestPrune(t *testing.T) {
+	c := &Cache{Dir: t.TempDir()}
+
+	// entries of 10 bytes each, used in order old, middle, new
+	now := time.Now()
+	for i, key := range []string{"old", "middle", "new"} {
+		if err := c.Put("git", "repo", key, []byte("0123456789")); err != nil {
+			t.Fatal(err)
+		}
+
+		p, _ := c.path("git", "repo", key)
+		used := now.Add(time.Duration(i-3) * time.Hour)
+		os.Chtimes(p, used, used)
+	}
+
+	// reading marks an entry as used
+	c.Get("git", "repo", "old")
+
+	removed, err := c.Prune(15)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(removed) != 2 || removed[0].Key != "middle" || removed[1].Key != "new" {
+		t.Errorf("Prune() removed %+v, expected middle and new", removed)
+	}
+
+	entries, err := c.Entries()
+	if err != nil || len(entries) != 1 || entries[0] != (Entry{Copier: "git", Repo: "repo", Key: "old", Size: 10, Used: entries[0].Used}) {
+		t.Errorf("Entries() = %+v, %v, expected only old", entries, err)
+	}
+}
+
+func TestEntriesOfMissingDir(t *testing.T) {
+	c := &Cache{Dir: filepath.Join(t.TempDir(), "missing")}
+
+	entries, err := c.Entries()
+	if err != nil || len(entries) != 0 {
+		t.Errorf("Entries() = %v, %v, expected none", entries, err)
+	}
+}
+
+func TestParseSize(t *testing.T) {
+	for s, want := range map[string]int64{
+		"1048576": 1 << 20,
+		"500K":    500 << 10,
+		"2GiB":    2 << 30,
+		"100 MB":  100 << 20,
+		"1t":      1 << 40,
+		"0":       0,
+	} {
+		got, err := ParseSize(s)
+		if err != nil || got != want {
+			t.Errorf("ParseSize(%q) = %v, %v, expected %v", s, got, err, want)
+		}
+	}
+
+	for _, s := range []string{"", "G", "1.5G", "-1", "10X", "99999999999T"} {
+		if _, err := ParseSize(s); err == nil {
+			t.Errorf("ParseSize(%q) succeeded", s)
+		}
+	}
+}
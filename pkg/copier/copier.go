@@ -2,6 +2,9 @@ package copier
 
 import (
 	"context"
+	"errors"
+
+	"github.com/audiotool/pasta/pkg/cache"
 )
 
 type Copier interface {
@@ -11,7 +14,8 @@ type Copier interface {
 	// pasted to the "to" directory into the "TempDir" directory.
 	//
 	// Copy must retrun a serializable object that is later saved inside "pasta.result.yaml".
-	// Look at SourceInfo for an example.
+	// Look at SourceInfo for an example. Its "reference" field identifies the exact
+	// version that was copied, and is used to lock the dependency to that version.
 	Copy(ctx context.Context, config CopyConfig) (any, error)
 }
 
@@ -22,10 +26,45 @@ type CopyConfig struct {
 	From string
 	// returns true if file with path relative to Src should be copied
 	Keep func(path string) bool
+	// Rules are the rules Keep was built from, for copiers that can't call Keep
+	Rules Rules
 	// Custom copier options from the pasta.yaml
 	Options map[string]string
 	// TempDir contains the path to write all files.
 	TempDir string
+	// Root is the directory containing pasta.yaml. Relative paths in URL are relative to it.
+	Root string
 	// ClearTarget is true if the target directory should be cleared before copying
 	ClearTarget bool
+	// Locked is the reference recorded in "pasta.result.yaml" by a previous run.
+	// If set, the copier must copy exactly this reference instead of resolving
+	// the one specified in Options.
+	Locked string
+	// Cache stores downloaded content across runs. Copiers should look up content
+	// there before downloading it, by keys that identify it uniquely, like a sha.
+	// May be nil, which caches nothing.
+	Cache *cache.Cache
+	// Offline is true if the copier must not access the network. It must copy
+	// Locked from Cache, or fail with an error wrapping ErrOffline.
+	Offline bool
+	// Symlinks is one of SymlinksPreserve, SymlinksFollow and SymlinksSkip, and decides
+	// what happens to symlinks of the source. Empty means SymlinksPreserve.
+	Symlinks string
+}
+
+// Rules are the patterns of pasta.yaml deciding which files are copied, serializable for
+// copiers running in another process. Paths are slash separated and relative to From.
+type Rules struct {
+	// Files lists the files to copy, the other rules are empty if set
+	Files []string `json:"files,omitempty"`
+	// Include and Exclude are regexes matching whole paths, the default includes everything
+	Include []string `json:"include,omitempty"`
+	Exclude []string `json:"exclude,omitempty"`
+	// IncludeGlob and ExcludeGlob are gitignore-style alternatives to Include and Exclude
+	IncludeGlob []string `json:"include_glob,omitempty"`
+	ExcludeGlob []string `json:"exclude_glob,omitempty"`
 }
+
+// ErrOffline is returned by copiers that would need to access the network in offline
+// mode, e.g. because the files to copy aren't cached.
+var ErrOffline = errors.New("not available offline")
@@ -0,0 +1,22 @@
+package copier
+
+import (
+	"context"
+)
+
+// CommitLister is implemented by copiers that can list the history of a source, which
+// is used to summarize what changed when a dependency moves to another reference.
+type CommitLister interface {
+	// Commits returns the commits after from, up to and including to, that touch
+	// files below From, newest first.
+	Commits(ctx context.Context, config CopyConfig, from, to string) ([]Commit, error)
+}
+
+// Commit is a commit of a source.
+type Commit struct {
+	Reference string
+	Message   string
+	Author    Author
+	// URL is a link to the commit for humans, if the source has one
+	URL string
+}
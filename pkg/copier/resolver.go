@@ -0,0 +1,25 @@
+package copier
+
+import (
+	"context"
+)
+
+// Resolver is implemented by copiers that can look up what the ref of a dependency
+// resolves to without copying any files, e.g. to find outdated dependencies.
+type Resolver interface {
+	// Resolve returns what the ref in Options resolves to now. If Locked is set,
+	// the returned resolution also tells how far Locked is behind.
+	Resolve(ctx context.Context, config CopyConfig) (*Resolution, error)
+}
+
+// Resolution is the current state of a dependency's source.
+type Resolution struct {
+	// Reference is the commit sha the ref resolves to
+	Reference string
+	// Tag is the tag a version constraint in the ref resolved to
+	Tag string
+	// Latest is the tag with the highest semantic version, empty if there is none
+	Latest string
+	// Behind is the number of commits between Locked and Reference, -1 if unknown
+	Behind int
+}
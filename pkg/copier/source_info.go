@@ -5,8 +5,10 @@ import "time"
 // SourceInfo is serialized into the `pasta.result.yaml` in the end
 type SourceInfo struct {
 	Reference string `yaml:"reference,omitempty"`
-	Message   string `yaml:"message,omitempty"`
-	Author    Author `yaml:"author,omitempty"`
+	// Tag is the tag a version constraint in the option ref resolved to
+	Tag     string `yaml:"tag,omitempty"`
+	Message string `yaml:"message,omitempty"`
+	Author  Author `yaml:"author,omitempty"`
 }
 
 type Author struct {
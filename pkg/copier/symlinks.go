@@ -0,0 +1,114 @@
+package copier
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"path"
+
+	"github.com/audiotool/pasta/pkg/utils"
+)
+
+// Values of the Symlinks option, which decides what copiers do with symlinks of the source.
+const (
+	// SymlinksPreserve recreates symlinks, if they stay inside of the target. It's the default.
+	SymlinksPreserve = "preserve"
+	// SymlinksFollow copies the file a symlink points to in place of the symlink.
+	SymlinksFollow = "follow"
+	// SymlinksSkip ignores symlinks.
+	SymlinksSkip = "skip"
+)
+
+// Git file modes of tree entries, as used by git and the APIs of its hosts.
+const (
+	ModeExecutable = "100755"
+	ModeSymlink    = "120000"
+)
+
+// MaxSymlinks is the number of symlinks followed in a row before giving up.
+const MaxSymlinks = 40
+
+// Perm returns the permissions to save a file with the git mode mode with.
+func Perm(mode string) fs.FileMode {
+	if mode == ModeExecutable {
+		return 0o777
+	}
+
+	return 0o666
+}
+
+// BlobSHA returns the git object id of a blob with content, which identifies blobs in the
+// trees of git and the APIs of its hosts.
+func BlobSHA(content []byte) string {
+	h := sha1.New()
+	fmt.Fprintf(h, "blob %d\x00", len(content))
+	h.Write(content)
+
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// ResolveSymlink returns the slash separated path the symlink at p points to, relative to
+// the same directory as p. It returns an error if target is absolute or leads outside of it.
+func ResolveSymlink(p, target string) (string, error) {
+	resolved := path.Join(path.Dir(p), target)
+
+	if path.IsAbs(target) || resolved == ".." || len(resolved) > 2 && resolved[:3] == "../" {
+		return "", fmt.Errorf("symlink %v points to %v, outside of the source", p, target)
+	}
+
+	return resolved, nil
+}
+
+// FollowSymlink returns the path of the file the symlink at p points to, following up to
+// MaxSymlinks symlinks in a row. Modes maps the paths of all files of the tree to their git
+// mode, and readLink returns the target of the symlink at a path.
+func FollowSymlink(p string, modes map[string]string, readLink func(p string) (string, error)) (string, error) {
+	link := p
+
+	for i := 0; i < MaxSymlinks; i++ {
+		target, err := readLink(link)
+		if err != nil {
+			return "", err
+		}
+
+		resolved, err := ResolveSymlink(link, target)
+		if err != nil {
+			return "", err
+		}
+
+		mode, ok := modes[resolved]
+		if !ok {
+			return "", fmt.Errorf("symlink %v points to %v, which isn't a file of the source", link, target)
+		}
+
+		if mode != ModeSymlink {
+			return resolved, nil
+		}
+
+		link = resolved
+	}
+
+	return "", fmt.Errorf("too many levels of symlinks following %v", p)
+}
+
+// Symlink is a symlink of the source that is recreated in TempDir.
+type Symlink struct {
+	// Relp is the path of the symlink relative to From
+	Relp string
+	// Target is what the symlink points to, relative to the directory containing it
+	Target string
+}
+
+// CreateSymlinks creates links in dir, if they stay inside of it.
+func CreateSymlinks(links []Symlink, dir string) error {
+	root := utils.Root{Dir: dir}
+
+	for _, l := range links {
+		if err := root.Symlink(l.Target, l.Relp); err != nil {
+			return fmt.Errorf("error creating symlink %v: %w", l.Relp, err)
+		}
+	}
+
+	return nil
+}
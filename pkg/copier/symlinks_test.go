@@ -0,0 +1,10 @@
+package copier
+
+import "testing"
+
+func TestBlobSHA(t *testing.T) {
+	// as printed by `echo hello | git hash-object --stdin`
+	if got, want := BlobSHA([]byte("hello\n")), "ce013625030ba8dba906f756967f9e9ca394464a"; got != want {
+		t.Errorf("BlobSHA() = %v, expected %v", got, want)
+	}
+}
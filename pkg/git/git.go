@@ -0,0 +1,754 @@
+package git
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/audiotool/pasta/pkg/copier"
+	"github.com/audiotool/pasta/pkg/semver"
+	"github.com/audiotool/pasta/pkg/utils"
+)
+
+// Copier copies files from any git remote the git executable can fetch from,
+// e.g. file://, ssh:// or https:// remotes.
+type Copier struct{}
+
+var (
+	// explicitly marked as git remote, e.g. git+https://example.com/repo
+	gitPrefixRegexp = regexp.MustCompile(`^git\+[a-z]+://`)
+	// protocols that are always git remotes
+	gitSchemeRegexp = regexp.MustCompile(`^(ssh|git)://`)
+	// scp-like syntax, e.g. git@example.com:owner/repo
+	scpRegexp = regexp.MustCompile(`^[a-zA-Z0-9._\-]+@[a-zA-Z0-9.\-]+:[^/]`)
+	// any url pointing to a repository by its .git suffix, e.g. file:///srv/mirror.git
+	gitSuffixRegexp = regexp.MustCompile(`^[a-z]+://.*\.git/?$`)
+)
+
+func (*Copier) Matches(url string) bool {
+	return gitPrefixRegexp.MatchString(url) ||
+		gitSchemeRegexp.MatchString(url) ||
+		scpRegexp.MatchString(url) ||
+		gitSuffixRegexp.MatchString(url)
+}
+
+func (*Copier) Copy(ctx context.Context, config copier.CopyConfig) (any, error) {
+	remote := remoteURL(config.URL)
+
+	if config.Locked != "" {
+		if err := checkSha(config.Locked); err != nil {
+			return nil, fmt.Errorf("invalid locked reference: %w", err)
+		}
+	}
+
+	// offline, the locked commit can only be copied from the cache
+	if config.Offline {
+		if config.Locked == "" {
+			return nil, fmt.Errorf("%w: ref must be resolved, but the dependency isn't locked", copier.ErrOffline)
+		}
+
+		return restoreCommit(config, remote, config.Locked)
+	}
+
+	// fetch into a temporary bare repository
+	gitDir, err := os.MkdirTemp("", "pasta-git")
+	if err != nil {
+		return nil, fmt.Errorf("error creating git directory: %v", err)
+	}
+
+	defer os.RemoveAll(gitDir)
+
+	if _, err := run(ctx, gitDir, "init", "--bare", "-q"); err != nil {
+		return nil, err
+	}
+
+	// resolve the ref to a sha & what to fetch, unless the dependency is locked to a sha
+	sha, fetch, tag := config.Locked, config.Locked, ""
+	if sha == "" {
+		ref := config.Options["ref"]
+
+		if semver.IsConstraint(ref) {
+			tag, err = latestTag(ctx, gitDir, remote, ref, config.Options["prerelease"] == "true")
+			if err != nil {
+				return nil, fmt.Errorf("error resolving ref: %w", err)
+			}
+
+			ref = "tags/" + tag
+		}
+
+		sha, fetch, err = resolveRef(ctx, gitDir, remote, ref)
+		if err != nil {
+			return nil, fmt.Errorf("error resolving ref: %w", err)
+		}
+	}
+
+	// commits whose files are all in the cache aren't fetched again
+	if info, err := restoreCommit(config, remote, sha); err == nil {
+		info.Tag = tag
+		return info, nil
+	}
+
+	if _, err := run(ctx, gitDir, "fetch", "-q", "--no-tags", "--depth", "1", "--end-of-options", remote, fetch); err != nil {
+		return nil, fmt.Errorf("error fetching %v: %w", fetch, err)
+	}
+
+	// make sure we got what we resolved, the remote might have changed in between
+	fetched, err := run(ctx, gitDir, "rev-parse", "FETCH_HEAD^{commit}")
+	if err != nil {
+		return nil, err
+	}
+
+	if strings.TrimSpace(string(fetched)) != sha {
+		return nil, fmt.Errorf("fetched commit %v, expected %v", strings.TrimSpace(string(fetched)), sha)
+	}
+
+	entries, err := listTree(ctx, gitDir, sha)
+	if err != nil {
+		return nil, err
+	}
+
+	blobs, err := selectBlobs(entries, config)
+	if err != nil {
+		return nil, err
+	}
+
+	blobs, links, err := resolveLinks(entries, blobs, config, func(entry treeEntry) (string, error) {
+		out, err := run(ctx, gitDir, "cat-file", "blob", entry.sha)
+		if err != nil {
+			return "", fmt.Errorf("error reading symlink %v: %w", entry.path, err)
+		}
+
+		// restoring the commit needs the symlink, failing to fill the cache is no error
+		_ = config.Cache.Put(cacheName, remote, "blob-"+entry.sha, out)
+
+		return string(out), nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err := saveBlobs(ctx, gitDir, blobs, config.TempDir); err != nil {
+		return nil, err
+	}
+
+	if err := copier.CreateSymlinks(links, config.TempDir); err != nil {
+		return nil, err
+	}
+
+	info, err := commitInfo(ctx, gitDir, sha)
+	if err != nil {
+		return nil, err
+	}
+
+	storeCommit(config, remote, entries, blobs, info)
+
+	info.Tag = tag
+	return info, nil
+}
+
+// selectBlobs returns the blobs of entries to copy, with their path relative to From.
+func selectBlobs(entries []treeEntry, config copier.CopyConfig) ([]treeEntry, error) {
+	var blobs []treeEntry
+
+	for _, entry := range entries {
+		if entry.typ != "blob" || !strings.HasPrefix(entry.path, config.From) {
+			continue
+		}
+
+		relp, err := filepath.Rel(config.From, entry.path)
+		if err != nil {
+			return nil, fmt.Errorf("unable to create relp: %v", err)
+		}
+
+		// check if user wants to keep this file based on relp
+		if !config.Keep(relp) {
+			continue
+		}
+
+		entry.path = relp
+		blobs = append(blobs, entry)
+	}
+
+	return blobs, nil
+}
+
+// resolveLinks handles the symlinks among blobs as the option symlinks says. It returns the
+// blobs to save, which include the blobs followed symlinks point to, and the symlinks to
+// recreate. Entries are all entries of the tree, readLink reads the target of a symlink.
+func resolveLinks(entries, blobs []treeEntry, config copier.CopyConfig, readLink func(entry treeEntry) (string, error)) ([]treeEntry, []copier.Symlink, error) {
+	var kept []treeEntry
+	var links []copier.Symlink
+
+	byPath := make(map[string]treeEntry, len(entries))
+	modes := make(map[string]string, len(entries))
+	for _, entry := range entries {
+		if entry.typ == "blob" {
+			byPath[entry.path] = entry
+			modes[entry.path] = entry.mode
+		}
+	}
+
+	for _, blob := range blobs {
+		if blob.mode != copier.ModeSymlink {
+			kept = append(kept, blob)
+			continue
+		}
+
+		p := path.Join(config.From, blob.path)
+
+		switch config.Symlinks {
+		case copier.SymlinksSkip:
+		case copier.SymlinksFollow:
+			resolved, err := copier.FollowSymlink(p, modes, func(p string) (string, error) {
+				return readLink(byPath[p])
+			})
+
+			if err != nil {
+				return nil, nil, err
+			}
+
+			target := byPath[resolved]
+			target.path = blob.path
+			kept = append(kept, target)
+		default:
+			target, err := readLink(byPath[p])
+			if err != nil {
+				return nil, nil, err
+			}
+
+			links = append(links, copier.Symlink{Relp: blob.path, Target: target})
+		}
+	}
+
+	return kept, links, nil
+}
+
+// cacheName is the name the copier stores its entries under in the cache.
+const cacheName = "git"
+
+// manifestKey is the prefix of the cache keys of manifests. It changes with the format of
+// manifests, older ones lack the modes of files.
+const manifestKey = "manifest-v2-"
+
+// regularMode is the mode of files that are neither executable nor symlinks.
+const regularMode = "100644"
+
+// manifest lists the blobs of the tree of a commit, and the info about the commit written
+// to pasta.result.yaml. Both never change, so manifests are cached by the sha of the commit.
+type manifest struct {
+	// Blobs maps the path of every blob to its sha
+	Blobs map[string]string `json:"blobs"`
+	// Modes maps the path of every blob that isn't a regular file to its mode
+	Modes map[string]string `json:"modes,omitempty"`
+	Info  copier.SourceInfo `json:"info"`
+}
+
+// restoreCommit copies the files of commit sha from the cache to the temp dir. Fails if
+// its manifest or any of its files isn't cached, there's no use in restoring only some
+// files, since a fetch is all or nothing.
+func restoreCommit(config copier.CopyConfig, remote, sha string) (*copier.SourceInfo, error) {
+	var m manifest
+	if !config.Cache.GetJSON(cacheName, remote, manifestKey+sha, &m) {
+		return nil, fmt.Errorf("%w: file listing of commit %v isn't cached", copier.ErrOffline, sha)
+	}
+
+	entries := make([]treeEntry, 0, len(m.Blobs))
+	for p, blob := range m.Blobs {
+		mode, ok := m.Modes[p]
+		if !ok {
+			mode = regularMode
+		}
+
+		entries = append(entries, treeEntry{mode: mode, typ: "blob", sha: blob, path: p})
+	}
+
+	blobs, err := selectBlobs(entries, config)
+	if err != nil {
+		return nil, err
+	}
+
+	blobs, links, err := resolveLinks(entries, blobs, config, func(entry treeEntry) (string, error) {
+		bs, ok := getBlob(config, remote, entry.sha)
+		if !ok {
+			return "", fmt.Errorf("%w: symlink %v of commit %v isn't cached", copier.ErrOffline, entry.path, sha)
+		}
+
+		return string(bs), nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	var missing []string
+	root := utils.Root{Dir: config.TempDir}
+
+	for _, blob := range blobs {
+		bs, ok := getBlob(config, remote, blob.sha)
+		if !ok || root.SaveFile(bs, blob.path, copier.Perm(blob.mode)) != nil {
+			missing = append(missing, path.Join(config.From, blob.path))
+		}
+	}
+
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return nil, fmt.Errorf("%w: files of commit %v aren't cached: %v", copier.ErrOffline, sha, strings.Join(missing, ", "))
+	}
+
+	if err := copier.CreateSymlinks(links, config.TempDir); err != nil {
+		return nil, err
+	}
+
+	return &m.Info, nil
+}
+
+// getBlob returns the content of the blob sha from the cache. Corrupted entries, whose
+// content doesn't hash to sha, count as missing.
+func getBlob(config copier.CopyConfig, remote, sha string) ([]byte, bool) {
+	bs, ok := config.Cache.Get(cacheName, remote, "blob-"+sha)
+	if !ok || copier.BlobSHA(bs) != sha {
+		return nil, false
+	}
+
+	return bs, true
+}
+
+// storeCommit stores the manifest of a fetched commit and the copied blobs in the cache.
+func storeCommit(config copier.CopyConfig, remote string, entries, blobs []treeEntry, info *copier.SourceInfo) {
+	m := manifest{Blobs: make(map[string]string), Modes: make(map[string]string), Info: *info}
+	for _, entry := range entries {
+		if entry.typ != "blob" {
+			continue
+		}
+
+		m.Blobs[entry.path] = entry.sha
+		if entry.mode != regularMode {
+			m.Modes[entry.path] = entry.mode
+		}
+	}
+
+	// the cache only saves fetches, failing to fill it is no error
+	for _, blob := range blobs {
+		_ = config.Cache.PutFile(cacheName, remote, "blob-"+blob.sha, path.Join(config.TempDir, blob.path))
+	}
+
+	_ = config.Cache.PutJSON(cacheName, remote, manifestKey+info.Reference, &m)
+}
+
+// Resolve returns what the ref of the dependency resolves to, the newest tag of the
+// remote, and how many commits the locked sha is behind.
+func (*Copier) Resolve(ctx context.Context, config copier.CopyConfig) (*copier.Resolution, error) {
+	remote := remoteURL(config.URL)
+	prerelease := config.Options["prerelease"] == "true"
+
+	gitDir, err := os.MkdirTemp("", "pasta-git")
+	if err != nil {
+		return nil, fmt.Errorf("error creating git directory: %v", err)
+	}
+
+	defer os.RemoveAll(gitDir)
+
+	if _, err := run(ctx, gitDir, "init", "--bare", "-q"); err != nil {
+		return nil, err
+	}
+
+	res := &copier.Resolution{Behind: -1}
+
+	ref := config.Options["ref"]
+	if semver.IsConstraint(ref) {
+		res.Tag, err = latestTag(ctx, gitDir, remote, ref, prerelease)
+		if err != nil {
+			return nil, fmt.Errorf("error resolving ref: %w", err)
+		}
+
+		ref = "tags/" + res.Tag
+	}
+
+	sha, fetch, err := resolveRef(ctx, gitDir, remote, ref)
+	if err != nil {
+		return nil, fmt.Errorf("error resolving ref: %w", err)
+	}
+
+	res.Reference = sha
+
+	// remotes without version tags have no latest tag
+	res.Latest, _ = latestTag(ctx, gitDir, remote, "latest", prerelease)
+
+	switch {
+	case config.Locked == "":
+	case config.Locked == sha:
+		res.Behind = 0
+	case checkSha(config.Locked) == nil:
+		res.Behind = commitsBehind(ctx, gitDir, remote, fetch, config.Locked, sha)
+	}
+
+	return res, nil
+}
+
+// commitsBehind returns the number of commits between locked and sha, which is fetched
+// from remote by the name fetch. Returns -1 if locked is no ancestor of sha.
+func commitsBehind(ctx context.Context, gitDir, remote, fetch, locked, sha string) int {
+	// counting needs the history, but no trees or blobs
+	if _, err := run(ctx, gitDir, "fetch", "-q", "--no-tags", "--filter=tree:0", "--end-of-options", remote, fetch); err != nil {
+		return -1
+	}
+
+	if _, err := run(ctx, gitDir, "merge-base", "--is-ancestor", locked, sha); err != nil {
+		return -1
+	}
+
+	out, err := run(ctx, gitDir, "rev-list", "--count", locked+".."+sha)
+	if err != nil {
+		return -1
+	}
+
+	n, err := strconv.Atoi(strings.TrimSpace(string(out)))
+	if err != nil {
+		return -1
+	}
+
+	return n
+}
+
+// Commits returns the commits after from, up to and including to, that touch files below From.
+func (*Copier) Commits(ctx context.Context, config copier.CopyConfig, from, to string) ([]copier.Commit, error) {
+	remote := remoteURL(config.URL)
+
+	for _, ref := range []string{from, to} {
+		if err := checkSha(ref); err != nil {
+			return nil, err
+		}
+	}
+
+	gitDir, err := os.MkdirTemp("", "pasta-git")
+	if err != nil {
+		return nil, fmt.Errorf("error creating git directory: %v", err)
+	}
+
+	defer os.RemoveAll(gitDir)
+
+	if _, err := run(ctx, gitDir, "init", "--bare", "-q"); err != nil {
+		return nil, err
+	}
+
+	// filtering by path needs the history and trees, but no blobs
+	if _, err := run(ctx, gitDir, "fetch", "-q", "--no-tags", "--filter=blob:none", "--end-of-options", remote, to); err != nil {
+		return nil, fmt.Errorf("error fetching %v: %w", to, err)
+	}
+
+	args := []string{"log", "--format=%H%x00%aI%x00%an%x00%ae%x00%B%x1e", from + ".." + to}
+	if config.From != "" {
+		args = append(args, "--", config.From)
+	}
+
+	out, err := run(ctx, gitDir, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error listing commits: %w", err)
+	}
+
+	var commits []copier.Commit
+
+	for _, record := range strings.Split(string(out), "\x1e") {
+		fields := strings.SplitN(strings.TrimSpace(record), "\x00", 5)
+		if len(fields) != 5 {
+			continue
+		}
+
+		date, err := time.Parse(time.RFC3339, fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("error parsing date of commit %v: %w", fields[0], err)
+		}
+
+		commits = append(commits, copier.Commit{
+			Reference: fields[0],
+			Message:   strings.TrimSpace(fields[4]),
+			Author:    copier.Author{Date: date.UTC(), Name: fields[2], Email: fields[3]},
+		})
+	}
+
+	return commits, nil
+}
+
+// remoteURL returns the url git should fetch from.
+func remoteURL(url string) string {
+	if gitPrefixRegexp.MatchString(url) {
+		return strings.TrimPrefix(url, "git+")
+	}
+
+	return url
+}
+
+var (
+	errCouldNotListRefs   = errors.New("failed listing refs")
+	errCouldNotResolveRef = errors.New("unable to resolve ref; must be branch, tag, or sha")
+
+	shaRegexp = regexp.MustCompile(`^([0-9a-f]{40}|[0-9a-f]{64})$`)
+)
+
+// checkSha returns an error if ref isn't a full commit sha. Remotes only serve commits by their
+// full sha, and refs starting with "-" must never be passed to git, which would take them as options.
+func checkSha(ref string) error {
+	if !shaRegexp.MatchString(ref) {
+		return fmt.Errorf("%q must be a full commit sha of 40 or 64 hex characters, abbreviated shas can't be fetched", ref)
+	}
+
+	return nil
+}
+
+// resolveRef returns the commit sha ref points to, and the name git should fetch to get it.
+// If ref is the empty string, the remote's HEAD is used.
+func resolveRef(ctx context.Context, gitDir, remote, ref string) (sha string, fetch string, err error) {
+	if strings.HasPrefix(ref, "commit/") {
+		ref = ref[len("commit/"):]
+		if err := checkSha(ref); err != nil {
+			return "", "", err
+		}
+
+		return ref, ref, nil
+	}
+
+	out, err := run(ctx, gitDir, "ls-remote", "--end-of-options", remote)
+	if err != nil {
+		return "", "", fmt.Errorf("%w: %w", errCouldNotListRefs, err)
+	}
+
+	refs := parseRefs(out)
+
+	var candidates []string
+
+	switch {
+	case ref == "":
+		candidates = []string{"HEAD"}
+	case strings.HasPrefix(ref, "heads/"), strings.HasPrefix(ref, "tags/"):
+		candidates = []string{"refs/" + ref}
+	default:
+		// try in order: branch, tag, commit sha, full ref name
+		candidates = []string{"refs/heads/" + ref, "refs/tags/" + ref}
+
+		if shaRegexp.MatchString(ref) {
+			return ref, ref, nil
+		}
+
+		candidates = append(candidates, ref)
+	}
+
+	for _, name := range candidates {
+		// annotated tags are peeled to the commit they point to
+		if sha, ok := refs[name+"^{}"]; ok {
+			return sha, name, nil
+		}
+
+		if sha, ok := refs[name]; ok {
+			return sha, name, nil
+		}
+	}
+
+	return "", "", errCouldNotResolveRef
+}
+
+// latestTag returns the tag of the remote with the highest version matching constraint.
+func latestTag(ctx context.Context, gitDir, remote, constraint string, prerelease bool) (string, error) {
+	out, err := run(ctx, gitDir, "ls-remote", "--tags", "--end-of-options", remote)
+	if err != nil {
+		return "", fmt.Errorf("%w: %w", errCouldNotListRefs, err)
+	}
+
+	var tags []string
+	for name := range parseRefs(out) {
+		if tag, ok := strings.CutPrefix(name, "refs/tags/"); ok && !strings.HasSuffix(tag, "^{}") {
+			tags = append(tags, tag)
+		}
+	}
+
+	return semver.Latest(constraint, tags, prerelease)
+}
+
+// parseRefs parses the output of `git ls-remote` into a map from ref name to sha.
+func parseRefs(out []byte) map[string]string {
+	refs := make(map[string]string)
+
+	for _, line := range strings.Split(string(out), "\n") {
+		sha, name, ok := strings.Cut(line, "\t")
+		if ok {
+			refs[name] = sha
+		}
+	}
+
+	return refs
+}
+
+type treeEntry struct {
+	mode string
+	typ  string
+	sha  string
+	path string
+}
+
+// listTree returns all entries of the tree of commit sha, recursively.
+func listTree(ctx context.Context, gitDir, sha string) ([]treeEntry, error) {
+	out, err := run(ctx, gitDir, "ls-tree", "-r", "-z", "--full-tree", sha)
+	if err != nil {
+		return nil, fmt.Errorf("error listing tree: %w", err)
+	}
+
+	var entries []treeEntry
+
+	for _, line := range strings.Split(string(out), "\x00") {
+		if line == "" {
+			continue
+		}
+
+		// format: <mode> SP <type> SP <sha> TAB <path>
+		info, p, ok := strings.Cut(line, "\t")
+		fields := strings.Fields(info)
+
+		if !ok || len(fields) != 3 {
+			return nil, fmt.Errorf("unexpected tree entry %q", line)
+		}
+
+		entries = append(entries, treeEntry{mode: fields[0], typ: fields[1], sha: fields[2], path: p})
+	}
+
+	return entries, nil
+}
+
+// saveBlobs writes the content of all blobs to their path relative to dir, using a
+// single `git cat-file --batch` process.
+func saveBlobs(ctx context.Context, gitDir string, blobs []treeEntry, dir string) error {
+	if len(blobs) == 0 {
+		return nil
+	}
+
+	var stdin bytes.Buffer
+	for _, blob := range blobs {
+		stdin.WriteString(blob.sha + "\n")
+	}
+
+	cmd := command(ctx, gitDir, "cat-file", "--batch")
+	cmd.Stdin = &stdin
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return fmt.Errorf("error reading blobs: %v", err)
+	}
+
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("error reading blobs: %v", err)
+	}
+
+	r := bufio.NewReader(stdout)
+
+	root := utils.Root{Dir: dir}
+
+	var errs []error
+	for _, blob := range blobs {
+		content, err := readBatchEntry(r)
+		if err != nil {
+			// the stream is broken, we can't read any further blobs
+			errs = append(errs, fmt.Errorf("error reading blob of %v: %w", blob.path, err))
+			break
+		}
+
+		if err := root.SaveFile(content, blob.path, copier.Perm(blob.mode)); err != nil {
+			errs = append(errs, fmt.Errorf("error saving %v: %w", blob.path, err))
+		}
+	}
+
+	if err := cmd.Wait(); err != nil {
+		errs = append(errs, fmt.Errorf("error reading blobs: %v", err))
+	}
+
+	return errors.Join(errs...)
+}
+
+// readBatchEntry reads a single object from the output of `git cat-file --batch`.
+func readBatchEntry(r *bufio.Reader) ([]byte, error) {
+	header, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+
+	// format: <sha> SP <type> SP <size> LF <content> LF
+	fields := strings.Fields(header)
+	if len(fields) != 3 {
+		return nil, fmt.Errorf("unexpected object header %q", strings.TrimSpace(header))
+	}
+
+	size, err := strconv.Atoi(fields[2])
+	if err != nil {
+		return nil, fmt.Errorf("unexpected object size %q", fields[2])
+	}
+
+	content := make([]byte, size+1)
+	if _, err := io.ReadFull(r, content); err != nil {
+		return nil, err
+	}
+
+	return content[:size], nil
+}
+
+// commitInfo returns the source info of commit sha.
+func commitInfo(ctx context.Context, gitDir, sha string) (*copier.SourceInfo, error) {
+	out, err := run(ctx, gitDir, "log", "-1", "--format=%cI%x00%cn%x00%ce%x00%B", sha)
+	if err != nil {
+		return nil, fmt.Errorf("error getting commit info: %w", err)
+	}
+
+	fields := strings.SplitN(string(out), "\x00", 4)
+	if len(fields) != 4 {
+		return nil, fmt.Errorf("unexpected commit info %q", out)
+	}
+
+	date, err := time.Parse(time.RFC3339, fields[0])
+	if err != nil {
+		return nil, fmt.Errorf("error parsing commit date: %v", err)
+	}
+
+	return &copier.SourceInfo{
+		Reference: sha,
+		Message:   strings.TrimSpace(fields[3]),
+		Author: copier.Author{
+			Date:  date.UTC(),
+			Name:  fields[1],
+			Email: fields[2],
+		},
+	}, nil
+}
+
+func command(ctx context.Context, gitDir string, args ...string) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Env = append(os.Environ(),
+		"GIT_DIR="+gitDir,
+		// never block on interactive credential prompts
+		"GIT_TERMINAL_PROMPT=0",
+	)
+	return cmd
+}
+
+// run executes git inside gitDir and returns its stdout.
+func run(ctx context.Context, gitDir string, args ...string) ([]byte, error) {
+	var stderr bytes.Buffer
+
+	cmd := command(ctx, gitDir, args...)
+	cmd.Stderr = &stderr
+
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("git %v failed: %v: %v", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+
+	return out, nil
+}
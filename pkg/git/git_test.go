@@ -0,0 +1,713 @@
+package git
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/audiotool/pasta/pkg/cache"
+	"github.com/audiotool/pasta/pkg/copier"
+)
+
+// testRepo is a bare repository with a work tree to create commits in.
+type testRepo struct {
+	t    *testing.T
+	work string
+	bare string
+}
+
+func newTestRepo(t *testing.T) *testRepo {
+	dir := t.TempDir()
+	r := &testRepo{t: t, work: path.Join(dir, "work"), bare: path.Join(dir, "bare.git")}
+
+	r.git(dir, "init", "-q", "-b", "main", r.work)
+	r.git(dir, "init", "-q", "--bare", "-b", "main", r.bare)
+	r.git(r.work, "remote", "add", "origin", r.bare)
+
+	return r
+}
+
+func (r *testRepo) git(dir string, args ...string) string {
+	r.t.Helper()
+
+	cmd := exec.Command("git", append([]string{"-c", "user.name=Pasta Test", "-c", "user.email=test@example.com"}, args...)...)
+	cmd.Dir = dir
+
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		r.t.Fatalf("git %v: %v: %s", args, err, out)
+	}
+
+	return strings.TrimSpace(string(out))
+}
+
+// commit writes files into the work tree, commits and pushes them, and returns the commit sha.
+func (r *testRepo) commit(msg string, files map[string]string) string {
+	r.t.Helper()
+
+	for p, content := range files {
+		p = path.Join(r.work, p)
+
+		if err := os.MkdirAll(path.Dir(p), os.ModePerm); err != nil {
+			r.t.Fatal(err)
+		}
+
+		if err := os.WriteFile(p, []byte(content), 0644); err != nil {
+			r.t.Fatal(err)
+		}
+	}
+
+	r.git(r.work, "add", "-A")
+	r.git(r.work, "commit", "-q", "-m", msg)
+	r.git(r.work, "push", "-q", "origin", "HEAD")
+
+	return r.git(r.work, "rev-parse", "HEAD")
+}
+
+func (r *testRepo) url() string {
+	return "file://" + filepath.ToSlash(r.bare)
+}
+
+// readDir returns all files in dir with their content.
+func readDir(t *testing.T, dir string) map[string]string {
+	files := make(map[string]string)
+
+	err := filepath.WalkDir(dir, func(p string, d os.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+
+		content, err := os.ReadFile(p)
+		if err != nil {
+			return err
+		}
+
+		rel, _ := filepath.Rel(dir, p)
+		files[filepath.ToSlash(rel)] = string(content)
+		return nil
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return files
+}
+
+func TestMatches(t *testing.T) {
+	tests := []struct {
+		url     string
+		matches bool
+	}{
+		{"file:///srv/mirror.git", true},
+		{"file:///srv/mirror.git/", true},
+		{"git+file:///srv/checkout", true},
+		{"git+https://gitea.example.com/foo/bar", true},
+		{"https://gitea.example.com/foo/bar.git", true},
+		{"ssh://git@example.com/foo/bar", true},
+		{"git://example.com/foo/bar", true},
+		{"git@example.com:foo/bar", true},
+		{"https://gitea.example.com/foo/bar", false},
+		{"file:///srv/checkout", false},
+		{"../shared", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.url, func(t *testing.T) {
+			if got := (&Copier{}).Matches(tt.url); got != tt.matches {
+				t.Errorf("Matches(%v) = %v, expected %v", tt.url, got, tt.matches)
+			}
+		})
+	}
+}
+
+func TestCopy(t *testing.T) {
+	repo := newTestRepo(t)
+
+	first := repo.commit("first", map[string]string{
+		"README.md":          "readme",
+		"proto/a.proto":      "a1",
+		"proto/sub/b.proto":  "b1",
+		"proto/sub/notes.md": "notes",
+	})
+	repo.git(repo.work, "tag", "-a", "-m", "v1", "v1.0.0")
+	repo.git(repo.work, "push", "-q", "origin", "v1.0.0")
+	repo.git(repo.work, "branch", "stable")
+	repo.git(repo.work, "push", "-q", "origin", "stable")
+
+	second := repo.commit("second\n\nwith body", map[string]string{
+		"proto/a.proto": "a2",
+	})
+
+	protos := func(p string) bool { return strings.HasSuffix(p, ".proto") }
+
+	tests := []struct {
+		name   string
+		ref    string
+		locked string
+		keep   func(string) bool
+		sha    string
+		files  map[string]string
+	}{
+		{
+			name:  "default branch",
+			sha:   second,
+			files: map[string]string{"a.proto": "a2", "sub/b.proto": "b1", "sub/notes.md": "notes"},
+		},
+		{
+			name:  "implicit branch",
+			ref:   "stable",
+			keep:  protos,
+			sha:   first,
+			files: map[string]string{"a.proto": "a1", "sub/b.proto": "b1"},
+		},
+		{
+			name:  "explicit branch",
+			ref:   "heads/main",
+			keep:  protos,
+			sha:   second,
+			files: map[string]string{"a.proto": "a2", "sub/b.proto": "b1"},
+		},
+		{
+			name:  "annotated tag",
+			ref:   "tags/v1.0.0",
+			keep:  protos,
+			sha:   first,
+			files: map[string]string{"a.proto": "a1", "sub/b.proto": "b1"},
+		},
+		{
+			name:  "implicit commit sha",
+			ref:   first,
+			keep:  protos,
+			sha:   first,
+			files: map[string]string{"a.proto": "a1", "sub/b.proto": "b1"},
+		},
+		{
+			name:   "locked",
+			ref:    "heads/main",
+			locked: first,
+			keep:   protos,
+			sha:    first,
+			files:  map[string]string{"a.proto": "a1", "sub/b.proto": "b1"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			keep := tt.keep
+			if keep == nil {
+				keep = func(string) bool { return true }
+			}
+
+			tmp := t.TempDir()
+			info, err := (&Copier{}).Copy(context.Background(), copier.CopyConfig{
+				URL:     repo.url(),
+				From:    "proto/",
+				Keep:    keep,
+				Options: map[string]string{"ref": tt.ref},
+				TempDir: tmp,
+				Locked:  tt.locked,
+			})
+
+			if err != nil {
+				t.Fatalf("Copy() error = %v", err)
+			}
+
+			si := info.(*copier.SourceInfo)
+			if si.Reference != tt.sha {
+				t.Errorf("Copy() reference = %v, expected %v", si.Reference, tt.sha)
+			}
+
+			if si.Author.Name != "Pasta Test" || si.Author.Email != "test@example.com" {
+				t.Errorf("Copy() author = %+v", si.Author)
+			}
+
+			if files := readDir(t, tmp); !reflect.DeepEqual(files, tt.files) {
+				t.Errorf("Copy() files = %v, expected %v", files, tt.files)
+			}
+		})
+	}
+}
+
+func TestCopyConstraint(t *testing.T) {
+	repo := newTestRepo(t)
+
+	shas := make(map[string]string)
+	for _, tag := range []string{"v1.0.0", "v1.1.0", "v2.0.0-rc.1", "nightly"} {
+		shas[tag] = repo.commit(tag, map[string]string{"proto/a.proto": tag})
+		repo.git(repo.work, "tag", "-a", "-m", tag, tag)
+		repo.git(repo.work, "push", "-q", "origin", tag)
+	}
+
+	tests := []struct {
+		ref        string
+		prerelease bool
+		tag        string
+	}{
+		{ref: "^1.0.0", tag: "v1.1.0"},
+		{ref: "~1.0", tag: "v1.0.0"},
+		{ref: "latest", tag: "v1.1.0"},
+		{ref: "latest", prerelease: true, tag: "v2.0.0-rc.1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.ref, func(t *testing.T) {
+			options := map[string]string{"ref": tt.ref}
+			if tt.prerelease {
+				options["prerelease"] = "true"
+			}
+
+			tmp := t.TempDir()
+			info, err := (&Copier{}).Copy(context.Background(), copier.CopyConfig{
+				URL:     repo.url(),
+				From:    "proto/",
+				Keep:    func(string) bool { return true },
+				Options: options,
+				TempDir: tmp,
+			})
+
+			if err != nil {
+				t.Fatalf("Copy() error = %v", err)
+			}
+
+			si := info.(*copier.SourceInfo)
+			if si.Tag != tt.tag || si.Reference != shas[tt.tag] {
+				t.Errorf("Copy() tag = %v at %v, expected %v at %v", si.Tag, si.Reference, tt.tag, shas[tt.tag])
+			}
+
+			if files := readDir(t, tmp); files["a.proto"] != tt.tag {
+				t.Errorf("Copy() files = %v, expected content of %v", files, tt.tag)
+			}
+		})
+	}
+
+	_, err := (&Copier{}).Copy(context.Background(), copier.CopyConfig{
+		URL:     repo.url(),
+		Keep:    func(string) bool { return true },
+		Options: map[string]string{"ref": ">=3"},
+		TempDir: t.TempDir(),
+	})
+
+	if err == nil {
+		t.Errorf("Copy() with unsatisfiable constraint succeeded")
+	}
+}
+
+func TestCopyCached(t *testing.T) {
+	repo := newTestRepo(t)
+	sha := repo.commit("first", map[string]string{"docs/a.md": "a", "docs/b.txt": "b", "c.md": "c"})
+
+	c := &cache.Cache{Dir: t.TempDir()}
+
+	copyDocs := func(locked string) (*copier.SourceInfo, map[string]string, error) {
+		tmp := t.TempDir()
+		info, err := (&Copier{}).Copy(context.Background(), copier.CopyConfig{
+			URL:     repo.url(),
+			From:    "docs/",
+			Keep:    func(p string) bool { return strings.HasSuffix(p, ".md") },
+			TempDir: tmp,
+			Locked:  locked,
+			Cache:   c,
+		})
+
+		if err != nil {
+			return nil, nil, err
+		}
+
+		return info.(*copier.SourceInfo), readDir(t, tmp), nil
+	}
+
+	fetched, files, err := copyDocs("")
+	if err != nil {
+		t.Fatalf("Copy() error = %v", err)
+	}
+
+	// locked copies don't need the remote anymore
+	if err := os.RemoveAll(repo.bare); err != nil {
+		t.Fatal(err)
+	}
+
+	cached, cachedFiles, err := copyDocs(sha)
+	if err != nil {
+		t.Fatalf("Copy() from cache error = %v", err)
+	}
+
+	if *cached != *fetched || !reflect.DeepEqual(cachedFiles, files) || files["a.md"] != "a" {
+		t.Errorf("Copy() from cache = %+v %v, expected %+v %v", cached, cachedFiles, fetched, files)
+	}
+
+	// files that weren't copied before aren't in the cache
+	tmp := t.TempDir()
+	_, err = (&Copier{}).Copy(context.Background(), copier.CopyConfig{
+		URL:     repo.url(),
+		Keep:    func(string) bool { return true },
+		TempDir: tmp,
+		Locked:  sha,
+		Cache:   c,
+	})
+
+	if err == nil {
+		t.Errorf("Copy() of uncached files succeeded without remote")
+	}
+}
+
+func TestCopyCorruptedCache(t *testing.T) {
+	repo := newTestRepo(t)
+
+	if err := os.MkdirAll(path.Join(repo.work, "docs"), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.Symlink("a.md", path.Join(repo.work, "docs", "link.md")); err != nil {
+		t.Fatal(err)
+	}
+
+	sha := repo.commit("first", map[string]string{"docs/a.md": "a"})
+
+	c := &cache.Cache{Dir: t.TempDir()}
+
+	copyDocs := func(offline bool) (map[string]string, error) {
+		tmp := t.TempDir()
+		_, err := (&Copier{}).Copy(context.Background(), copier.CopyConfig{
+			URL:     repo.url(),
+			From:    "docs/",
+			Keep:    func(string) bool { return true },
+			TempDir: tmp,
+			Locked:  sha,
+			Cache:   c,
+			Offline: offline,
+		})
+
+		return readDir(t, tmp), err
+	}
+
+	if _, err := copyDocs(false); err != nil {
+		t.Fatalf("Copy() error = %v", err)
+	}
+
+	// corrupt the cached content of a.md and the target of link.md
+	for content, corrupted := range map[string]string{"a": "", "a.md": "../a.md"} {
+		if err := c.Put(cacheName, remoteURL(repo.url()), "blob-"+copier.BlobSHA([]byte(content)), []byte(corrupted)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := copyDocs(true); !errors.Is(err, copier.ErrOffline) {
+		t.Errorf("Copy() offline with corrupted cache error = %v, expected %v", err, copier.ErrOffline)
+	}
+
+	files, err := copyDocs(false)
+	if want := map[string]string{"a.md": "a", "link.md": "a"}; err != nil || !reflect.DeepEqual(files, want) {
+		t.Errorf("Copy() with corrupted cache = %v, %v, expected %v fetched again", files, err, want)
+	}
+}
+
+func TestCopyOffline(t *testing.T) {
+	repo := newTestRepo(t)
+	sha := repo.commit("first", map[string]string{"docs/a.md": "a", "docs/b.txt": "b"})
+
+	c := &cache.Cache{Dir: t.TempDir()}
+
+	copyDocs := func(locked string, offline bool, keep func(string) bool) (map[string]string, error) {
+		tmp := t.TempDir()
+		_, err := (&Copier{}).Copy(context.Background(), copier.CopyConfig{
+			URL:     repo.url(),
+			From:    "docs/",
+			Keep:    keep,
+			TempDir: tmp,
+			Locked:  locked,
+			Cache:   c,
+			Offline: offline,
+		})
+
+		return readDir(t, tmp), err
+	}
+
+	md := func(p string) bool { return strings.HasSuffix(p, ".md") }
+	all := func(string) bool { return true }
+
+	if _, err := copyDocs("", true, md); !errors.Is(err, copier.ErrOffline) {
+		t.Errorf("Copy() of unlocked dependency offline error = %v, expected %v", err, copier.ErrOffline)
+	}
+
+	if _, err := copyDocs(sha, true, md); !errors.Is(err, copier.ErrOffline) {
+		t.Errorf("Copy() of uncached commit offline error = %v, expected %v", err, copier.ErrOffline)
+	}
+
+	if _, err := copyDocs(sha, false, md); err != nil {
+		t.Fatalf("Copy() error = %v", err)
+	}
+
+	files, err := copyDocs(sha, true, md)
+	if err != nil || !reflect.DeepEqual(files, map[string]string{"a.md": "a"}) {
+		t.Errorf("Copy() offline = %v, %v, expected a.md", files, err)
+	}
+
+	// files that weren't copied before are listed
+	_, err = copyDocs(sha, true, all)
+	if !errors.Is(err, copier.ErrOffline) || !strings.Contains(err.Error(), "docs/b.txt") || strings.Contains(err.Error(), "docs/a.md") {
+		t.Errorf("Copy() of uncached files offline error = %v, expected docs/b.txt to be listed", err)
+	}
+}
+
+func TestResolve(t *testing.T) {
+	repo := newTestRepo(t)
+
+	first := repo.commit("first", map[string]string{"a": "1"})
+	repo.git(repo.work, "tag", "v1.0.0")
+	repo.git(repo.work, "push", "-q", "origin", "v1.0.0")
+	repo.commit("second", map[string]string{"a": "2"})
+	third := repo.commit("third", map[string]string{"a": "3"})
+
+	res, err := (&Copier{}).Resolve(context.Background(), copier.CopyConfig{
+		URL:     repo.url(),
+		Options: map[string]string{"ref": "main"},
+		Locked:  first,
+	})
+
+	if err != nil {
+		t.Fatalf("Resolve() error = %v", err)
+	}
+
+	want := copier.Resolution{Reference: third, Latest: "v1.0.0", Behind: 2}
+	if *res != want {
+		t.Errorf("Resolve() = %+v, expected %+v", *res, want)
+	}
+
+	// a locked sha the remote doesn't know can't be compared
+	res, err = (&Copier{}).Resolve(context.Background(), copier.CopyConfig{
+		URL:     repo.url(),
+		Options: map[string]string{"ref": "main"},
+		Locked:  strings.Repeat("0", 40),
+	})
+
+	if err != nil || res.Behind != -1 {
+		t.Errorf("Resolve() = %+v, %v, expected unknown distance", res, err)
+	}
+}
+
+func TestCommits(t *testing.T) {
+	repo := newTestRepo(t)
+
+	first := repo.commit("first", map[string]string{"docs/a": "1"})
+	second := repo.commit("second\n\nwith body", map[string]string{"docs/a": "2"})
+	repo.commit("third", map[string]string{"other/b": "3"})
+	fourth := repo.commit("fourth", map[string]string{"docs/c": "4"})
+
+	commits, err := (&Copier{}).Commits(context.Background(), copier.CopyConfig{URL: repo.url(), From: "docs/"}, first, fourth)
+	if err != nil {
+		t.Fatalf("Commits() error = %v", err)
+	}
+
+	var got []string
+	for _, com := range commits {
+		got = append(got, com.Reference+" "+com.Message)
+
+		if com.Author.Name != "Pasta Test" || com.Author.Email != "test@example.com" {
+			t.Errorf("commit %v has author %+v", com.Reference, com.Author)
+		}
+	}
+
+	want := []string{fourth + " fourth", second + " second\n\nwith body"}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("Commits() = %q, expected %q", got, want)
+	}
+}
+
+func TestResolveRefUnknown(t *testing.T) {
+	repo := newTestRepo(t)
+	repo.commit("first", map[string]string{"a": "a"})
+
+	ctx := context.Background()
+	gitDir := t.TempDir()
+
+	for _, ref := range []string{"inexistant-marshmellow", "heads/inexistant-marshmellow", "tags/inexistant-marshmellow"} {
+		if _, _, err := resolveRef(ctx, gitDir, repo.url(), ref); !errors.Is(err, errCouldNotResolveRef) {
+			t.Errorf("resolveRef(%v) error = %v, expected %v", ref, err, errCouldNotResolveRef)
+		}
+	}
+
+	if _, _, err := resolveRef(ctx, gitDir, "file:///inexistant/repo.git", ""); !errors.Is(err, errCouldNotListRefs) {
+		t.Errorf("resolveRef() on missing remote error = %v, expected %v", err, errCouldNotListRefs)
+	}
+
+	// commits must be given by their full sha, which also keeps them from being taken as options
+	for _, ref := range []string{"commit/abc1234", "commit/--upload-pack=touch /tmp/pwned"} {
+		if _, _, err := resolveRef(ctx, gitDir, repo.url(), ref); err == nil || !strings.Contains(err.Error(), "full commit sha") {
+			t.Errorf("resolveRef(%v) error = %v, expected error about full shas", ref, err)
+		}
+	}
+
+	config := copier.CopyConfig{URL: repo.url(), Keep: func(string) bool { return true }, TempDir: t.TempDir(), Locked: "--upload-pack=touch"}
+	if _, err := (&Copier{}).Copy(ctx, config); err == nil || !strings.Contains(err.Error(), "full commit sha") {
+		t.Errorf("Copy() locked to an option error = %v, expected error about full shas", err)
+	}
+}
+
+func TestParseRefs(t *testing.T) {
+	refs := parseRefs([]byte("aaa\tHEAD\nbbb\trefs/heads/main\nccc\trefs/tags/v1\nddd\trefs/tags/v1^{}\n"))
+
+	names := make([]string, 0, len(refs))
+	for name := range refs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	want := []string{"HEAD", "refs/heads/main", "refs/tags/v1", "refs/tags/v1^{}"}
+	if !reflect.DeepEqual(names, want) || refs["refs/tags/v1^{}"] != "ddd" {
+		t.Errorf("parseRefs() = %v", refs)
+	}
+}
+
+func TestCopySymlinks(t *testing.T) {
+	repo := newTestRepo(t)
+
+	// symlinks and executables can't be written by commit
+	for p, target := range map[string]string{"docs/link.md": "a.md", "docs/up.md": "../c.md", "docs/chain.md": "link.md", "docs/escape.md": "../../outside"} {
+		if err := os.MkdirAll(path.Dir(path.Join(repo.work, p)), os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := os.Symlink(target, path.Join(repo.work, p)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := os.WriteFile(path.Join(repo.work, "docs", "run.sh"), []byte("#!/bin/sh"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	sha := repo.commit("first", map[string]string{"docs/a.md": "a", "c.md": "c"})
+
+	tests := []struct {
+		name     string
+		symlinks string
+		files    []string
+		// want maps the copied files to their content, or the target of symlinks prefixed with "-> "
+		want    map[string]string
+		wantErr bool
+	}{
+		{
+			name:  "preserve by default",
+			files: []string{"a.md", "link.md", "chain.md", "run.sh"},
+			want:  map[string]string{"a.md": "a", "link.md": "-> a.md", "chain.md": "-> link.md", "run.sh": "#!/bin/sh"},
+		},
+		{
+			name:     "follow",
+			symlinks: copier.SymlinksFollow,
+			files:    []string{"link.md", "chain.md", "up.md"},
+			want:     map[string]string{"link.md": "a", "chain.md": "a", "up.md": "c"},
+		},
+		{
+			name:     "skip",
+			symlinks: copier.SymlinksSkip,
+			files:    []string{"a.md", "link.md", "up.md", "escape.md"},
+			want:     map[string]string{"a.md": "a"},
+		},
+		{
+			name:    "preserve outside of from",
+			files:   []string{"up.md"},
+			wantErr: true,
+		},
+		{
+			name:     "follow outside of the repository",
+			symlinks: copier.SymlinksFollow,
+			files:    []string{"escape.md"},
+			wantErr:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &cache.Cache{Dir: t.TempDir()}
+
+			copyDocs := func(locked string) (map[string]string, error) {
+				tmp := t.TempDir()
+				_, err := (&Copier{}).Copy(context.Background(), copier.CopyConfig{
+					URL:  repo.url(),
+					From: "docs/",
+					Keep: func(p string) bool {
+						for _, f := range tt.files {
+							if f == p {
+								return true
+							}
+						}
+						return false
+					},
+					TempDir:  tmp,
+					Locked:   locked,
+					Cache:    c,
+					Offline:  locked != "",
+					Symlinks: tt.symlinks,
+				})
+
+				return readLinks(t, tmp), err
+			}
+
+			files, err := copyDocs("")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Copy() error = %v, expected error = %v", err, tt.wantErr)
+			}
+
+			if tt.wantErr {
+				return
+			}
+
+			if !reflect.DeepEqual(files, tt.want) {
+				t.Errorf("Copy() = %v, expected %v", files, tt.want)
+			}
+
+			// the cache restores the same files
+			cached, err := copyDocs(sha)
+			if err != nil || !reflect.DeepEqual(cached, tt.want) {
+				t.Errorf("Copy() from cache = %v, %v, expected %v", cached, err, tt.want)
+			}
+		})
+	}
+}
+
+// readLinks returns all files in dir like readDir, but with the targets of symlinks prefixed
+// with "-> " instead of their content. Executables must stay executable.
+func readLinks(t *testing.T, dir string) map[string]string {
+	files := make(map[string]string)
+
+	err := filepath.WalkDir(dir, func(p string, d os.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+
+		rel, _ := filepath.Rel(dir, p)
+
+		if d.Type()&os.ModeSymlink != 0 {
+			target, err := os.Readlink(p)
+			files[filepath.ToSlash(rel)] = "-> " + target
+			return err
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		if strings.HasSuffix(p, ".sh") != (info.Mode()&0o100 != 0) {
+			t.Errorf("%v has mode %v", rel, info.Mode())
+		}
+
+		content, err := os.ReadFile(p)
+		files[filepath.ToSlash(rel)] = string(content)
+		return err
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return files
+}
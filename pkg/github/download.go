@@ -0,0 +1,75 @@
+package github
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/audiotool/pasta/pkg/copier"
+	"github.com/audiotool/pasta/pkg/hosted"
+	gh "github.com/google/go-github/v53/github"
+)
+
+// api serves a repository to hosted.Copy through the API of GitHub.
+type api struct {
+	client *gh.Client
+	owner  string
+	repo   string
+}
+
+func (a *api) Tree(ctx context.Context, sha string) ([]hosted.Blob, error) {
+	tree, _, err := a.client.Git.GetTree(ctx, a.owner, a.repo, sha, true)
+	if err != nil {
+		return nil, err
+	}
+
+	var blobs []hosted.Blob
+	for _, entry := range tree.Entries {
+		if entry.GetType() == "blob" {
+			blobs = append(blobs, hosted.Blob{Path: entry.GetPath(), SHA: entry.GetSHA(), Mode: entry.GetMode()})
+		}
+	}
+
+	return blobs, nil
+}
+
+func (a *api) Commit(ctx context.Context, sha string) (*copier.SourceInfo, error) {
+	com, _, err := a.client.Git.GetCommit(ctx, a.owner, a.repo, sha)
+	if err != nil {
+		return nil, err
+	}
+
+	return toCommitInfo(com), nil
+}
+
+func (a *api) Blob(ctx context.Context, sha string) ([]byte, error) {
+	bs, _, err := a.client.Git.GetBlobRaw(ctx, a.owner, a.repo, sha)
+	return bs, err
+}
+
+// Archive downloads the tarball of the repository, whose files are inside a top level
+// directory named <owner>-<repo>-<sha>.
+func (a *api) Archive(ctx context.Context, sha string) (io.ReadCloser, error) {
+	link, _, err := a.client.Repositories.GetArchiveLink(ctx, a.owner, a.repo, gh.Tarball, &gh.RepositoryContentGetOptions{Ref: sha}, false)
+	if err != nil {
+		return nil, fmt.Errorf("error getting archive link: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, link.String(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("error creating archive request: %w", err)
+	}
+
+	resp, err := a.client.Client().Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("unexpected status %v", resp.Status)
+	}
+
+	return resp.Body, nil
+}
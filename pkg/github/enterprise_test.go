@@ -0,0 +1,599 @@
+package github
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/audiotool/pasta/pkg/cache"
+	"github.com/audiotool/pasta/pkg/copier"
+	"github.com/audiotool/pasta/pkg/utils"
+)
+
+const fakeSha = "0123456789abcdef0123456789abcdef01234567"
+
+// fakeEnterprise serves the parts of the GitHub API the copier uses, for repository o/r
+// with the given files, under /api/v3/ like a GitHub Enterprise Server instance does.
+// Files whose content starts with "-> " are symlinks to the rest of it, files ending in
+// .sh are executable.
+func fakeEnterprise(t *testing.T, files map[string]string) *httptest.Server {
+	t.Helper()
+
+	writeJSON := func(w http.ResponseWriter, v any) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(v)
+	}
+
+	mode := func(p string) string {
+		switch {
+		case strings.HasPrefix(files[p], "-> "):
+			return copier.ModeSymlink
+		case strings.HasSuffix(p, ".sh"):
+			return copier.ModeExecutable
+		default:
+			return "100644"
+		}
+	}
+
+	var entries []map[string]string
+	for p := range files {
+		entries = append(entries, map[string]string{"path": p, "mode": mode(p), "type": "blob", "sha": copier.BlobSHA([]byte(strings.TrimPrefix(files[p], "-> ")))})
+	}
+
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/api/v3/repos/o/r", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, map[string]any{"name": "r", "default_branch": "main"})
+	})
+
+	mux.HandleFunc("/api/v3/repos/o/r/git/ref/heads/main", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, map[string]any{"ref": "refs/heads/main", "object": map[string]string{"sha": fakeSha, "type": "commit"}})
+	})
+
+	mux.HandleFunc("/api/v3/repos/o/r/tags", func(w http.ResponseWriter, r *http.Request) {
+		// paginated like the real API, one tag per page
+		switch r.URL.Query().Get("page") {
+		case "", "1":
+			w.Header().Set("Link", `<http://`+r.Host+`/api/v3/repos/o/r/tags?page=2>; rel="next"`)
+			writeJSON(w, []map[string]any{{"name": "v0.9.0", "commit": map[string]string{"sha": "0000000000000000000000000000000000000000"}}})
+		default:
+			writeJSON(w, []map[string]any{{"name": "v1.2.0", "commit": map[string]string{"sha": fakeSha}}})
+		}
+	})
+
+	// the three commits after the zero sha, the last of them is fakeSha
+	history := []map[string]any{
+		{"sha": "1111111111111111111111111111111111111111", "html_url": "https://ghe.example/o/r/commit/1", "commit": map[string]any{"message": "first", "author": map[string]string{"name": "Jane"}, "committer": map[string]string{"date": "2023-01-01T00:00:00Z"}}},
+		{"sha": "2222222222222222222222222222222222222222", "commit": map[string]any{"message": "second", "author": map[string]string{"name": "John"}, "committer": map[string]string{"date": "2023-01-02T00:00:00Z"}}},
+		{"sha": fakeSha, "commit": map[string]any{"message": "third\n\nbody", "author": map[string]string{"name": "Jane"}, "committer": map[string]string{"date": "2023-01-03T00:00:00Z"}}},
+	}
+
+	mux.HandleFunc("/api/v3/repos/o/r/compare/", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, map[string]any{"ahead_by": 3, "behind_by": 0, "commits": history})
+	})
+
+	// lists the commits touching docs, newest first, including one before the compared range
+	mux.HandleFunc("/api/v3/repos/o/r/commits", func(w http.ResponseWriter, r *http.Request) {
+		if q := r.URL.Query(); q.Get("path") != "docs" || q.Get("sha") != fakeSha {
+			t.Errorf("commits requested with path %q and sha %q", q.Get("path"), q.Get("sha"))
+		}
+
+		writeJSON(w, []map[string]any{history[2], history[0], {"sha": "0000000000000000000000000000000000000000"}})
+	})
+
+	mux.HandleFunc("/api/v3/repos/o/r/git/trees/"+fakeSha, func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, map[string]any{"sha": fakeSha, "tree": entries})
+	})
+
+	mux.HandleFunc("/api/v3/repos/o/r/git/blobs/", func(w http.ResponseWriter, r *http.Request) {
+		sha := strings.TrimPrefix(r.URL.Path, "/api/v3/repos/o/r/git/blobs/")
+		for _, entry := range entries {
+			if entry["sha"] == sha {
+				w.Write([]byte(strings.TrimPrefix(files[entry["path"]], "-> ")))
+				return
+			}
+		}
+		http.NotFound(w, r)
+	})
+
+	mux.HandleFunc("/api/v3/repos/o/r/git/commits/"+fakeSha, func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, map[string]any{
+			"sha":       fakeSha,
+			"message":   "initial commit",
+			"committer": map[string]string{"name": "Jane", "email": "jane@corp.example", "date": "2023-01-02T03:04:05Z"},
+		})
+	})
+
+	mux.HandleFunc("/api/v3/repos/o/r/tarball/"+fakeSha, func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "http://"+r.Host+"/codeload/o/r/tar.gz/"+fakeSha, http.StatusFound)
+	})
+
+	mux.HandleFunc("/codeload/o/r/tar.gz/"+fakeSha, func(w http.ResponseWriter, r *http.Request) {
+		var buf bytes.Buffer
+		gz := gzip.NewWriter(&buf)
+		tw := tar.NewWriter(gz)
+
+		for p, content := range files {
+			switch mode(p) {
+			case copier.ModeSymlink:
+				tw.WriteHeader(&tar.Header{Name: "o-r-0123456/" + p, Mode: 0o777, Linkname: strings.TrimPrefix(content, "-> "), Typeflag: tar.TypeSymlink})
+			case copier.ModeExecutable:
+				tw.WriteHeader(&tar.Header{Name: "o-r-0123456/" + p, Mode: 0o755, Size: int64(len(content)), Typeflag: tar.TypeReg})
+				tw.Write([]byte(content))
+			default:
+				tw.WriteHeader(&tar.Header{Name: "o-r-0123456/" + p, Mode: 0o644, Size: int64(len(content)), Typeflag: tar.TypeReg})
+				tw.Write([]byte(content))
+			}
+		}
+
+		tw.Close()
+		gz.Close()
+		w.Write(buf.Bytes())
+	})
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.URL.Path, "/codeload/") && r.Header.Get("Authorization") != "Bearer secret" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		mux.ServeHTTP(w, r)
+	}))
+
+	t.Cleanup(srv.Close)
+
+	return srv
+}
+
+func TestCopyEnterprise(t *testing.T) {
+	t.Setenv("GH_ENTERPRISE_TOKEN", "secret")
+	t.Setenv("GITHUB_TOKEN", "")
+
+	files := map[string]string{
+		"docs/a.md":   "a",
+		"docs/b/c.md": "c",
+		"other.txt":   "other",
+	}
+
+	srv := fakeEnterprise(t, files)
+
+	for _, download := range []string{"blobs", "archive"} {
+		t.Run(download, func(t *testing.T) {
+			c := &Copier{APIURL: srv.URL}
+
+			url := srv.URL + "/o/r"
+			if !c.Matches(url) {
+				t.Fatalf("copier doesn't match %v", url)
+			}
+
+			tmp := t.TempDir()
+
+			res, err := c.Copy(context.Background(), copier.CopyConfig{
+				URL:     url,
+				From:    "docs/",
+				Keep:    func(string) bool { return true },
+				Options: map[string]string{"ref": "main", "download": download},
+				TempDir: tmp,
+			})
+
+			if err != nil {
+				t.Fatalf("copy failed: %v", err)
+			}
+
+			if ref := res.(*copier.SourceInfo).Reference; ref != fakeSha {
+				t.Errorf("reference is %v, expected %v", ref, fakeSha)
+			}
+
+			for p, want := range map[string]string{"a.md": "a", "b/c.md": "c"} {
+				got, err := os.ReadFile(filepath.Join(tmp, p))
+				if err != nil {
+					t.Fatalf("reading %v: %v", p, err)
+				}
+
+				if string(got) != want {
+					t.Errorf("%v contains %q, expected %q", p, got, want)
+				}
+			}
+
+			if _, err := os.Stat(filepath.Join(tmp, "other.txt")); err == nil {
+				t.Errorf("other.txt is outside of from, but was copied")
+			}
+		})
+	}
+}
+
+func TestCopyHostileTree(t *testing.T) {
+	t.Setenv("GH_ENTERPRISE_TOKEN", "secret")
+
+	srv := fakeEnterprise(t, map[string]string{"a.md": "a", "../escape.txt": "evil"})
+	c := &Copier{APIURL: srv.URL}
+
+	for _, download := range []string{"blobs", "archive"} {
+		t.Run(download, func(t *testing.T) {
+			dir := t.TempDir()
+
+			_, err := c.Copy(context.Background(), copier.CopyConfig{
+				URL:     srv.URL + "/o/r",
+				Keep:    func(string) bool { return true },
+				Options: map[string]string{"download": download},
+				TempDir: filepath.Join(dir, "tmp"),
+				Locked:  fakeSha,
+			})
+
+			if !errors.Is(err, utils.ErrUnsafePath) {
+				t.Errorf("Copy() = %v, expected %v", err, utils.ErrUnsafePath)
+			}
+
+			if _, err := os.Stat(filepath.Join(dir, "escape.txt")); !errors.Is(err, os.ErrNotExist) {
+				t.Errorf("tree entry escaped the temp directory")
+			}
+		})
+	}
+}
+
+func TestCopySymlinks(t *testing.T) {
+	t.Setenv("GH_ENTERPRISE_TOKEN", "secret")
+
+	srv := fakeEnterprise(t, map[string]string{
+		"docs/a.md":      "a",
+		"docs/run.sh":    "#!/bin/sh",
+		"docs/link.md":   "-> a.md",
+		"docs/up.md":     "-> ../c.md",
+		"docs/chain.md":  "-> link.md",
+		"docs/escape.md": "-> ../../outside.md",
+		"c.md":           "c",
+	})
+
+	c := &Copier{APIURL: srv.URL}
+
+	tests := []struct {
+		name     string
+		symlinks string
+		files    []string
+		// want maps the copied files to their content, or the target of symlinks prefixed with "-> "
+		want    map[string]string
+		wantErr bool
+	}{
+		{
+			name:  "preserve by default",
+			files: []string{"a.md", "run.sh", "link.md", "chain.md"},
+			want:  map[string]string{"a.md": "a", "run.sh": "#!/bin/sh", "link.md": "-> a.md", "chain.md": "-> link.md"},
+		},
+		{
+			name:     "follow",
+			symlinks: copier.SymlinksFollow,
+			files:    []string{"a.md", "link.md", "chain.md", "up.md"},
+			want:     map[string]string{"a.md": "a", "link.md": "a", "chain.md": "a", "up.md": "c"},
+		},
+		{
+			name:     "skip",
+			symlinks: copier.SymlinksSkip,
+			files:    []string{"a.md", "link.md", "escape.md"},
+			want:     map[string]string{"a.md": "a"},
+		},
+		{
+			name:    "preserve outside of from",
+			files:   []string{"up.md"},
+			wantErr: true,
+		},
+		{
+			name:     "follow outside of the repository",
+			symlinks: copier.SymlinksFollow,
+			files:    []string{"escape.md"},
+			wantErr:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		for _, download := range []string{"blobs", "archive"} {
+			t.Run(tt.name+" "+download, func(t *testing.T) {
+				tmp := t.TempDir()
+
+				_, err := c.Copy(context.Background(), copier.CopyConfig{
+					URL:  srv.URL + "/o/r",
+					From: "docs/",
+					Keep: func(p string) bool {
+						for _, f := range tt.files {
+							if f == p {
+								return true
+							}
+						}
+						return false
+					},
+					Options:  map[string]string{"download": download},
+					TempDir:  tmp,
+					Locked:   fakeSha,
+					Symlinks: tt.symlinks,
+				})
+
+				if (err != nil) != tt.wantErr {
+					t.Fatalf("Copy() error = %v, expected error = %v", err, tt.wantErr)
+				}
+
+				if tt.wantErr {
+					return
+				}
+
+				got := make(map[string]string)
+				entries, _ := os.ReadDir(tmp)
+				for _, e := range entries {
+					p := filepath.Join(tmp, e.Name())
+
+					if target, err := os.Readlink(p); err == nil {
+						got[e.Name()] = "-> " + target
+						continue
+					}
+
+					bs, _ := os.ReadFile(p)
+					got[e.Name()] = string(bs)
+
+					info, _ := os.Stat(p)
+					if strings.HasSuffix(p, ".sh") != (info.Mode()&0o100 != 0) {
+						t.Errorf("%v has mode %v", e.Name(), info.Mode())
+					}
+				}
+
+				if !reflect.DeepEqual(got, tt.want) {
+					t.Errorf("Copy() = %v, expected %v", got, tt.want)
+				}
+			})
+		}
+	}
+}
+
+func TestCopyCached(t *testing.T) {
+	t.Setenv("GH_ENTERPRISE_TOKEN", "secret")
+
+	files := map[string]string{"docs/a.md": "a", "docs/b.md": "b"}
+	srv := fakeEnterprise(t, files)
+
+	c := &Copier{APIURL: srv.URL}
+	cached := &cache.Cache{Dir: t.TempDir()}
+
+	copyDocs := func(download string) map[string]string {
+		tmp := t.TempDir()
+
+		_, err := c.Copy(context.Background(), copier.CopyConfig{
+			URL:     srv.URL + "/o/r",
+			From:    "docs/",
+			Keep:    func(p string) bool { return p == "a.md" },
+			Options: map[string]string{"download": download},
+			TempDir: tmp,
+			Locked:  fakeSha,
+			Cache:   cached,
+		})
+
+		if err != nil {
+			t.Fatalf("copy failed: %v", err)
+		}
+
+		got := make(map[string]string)
+		for _, p := range []string{"a.md", "b.md"} {
+			if bs, err := os.ReadFile(filepath.Join(tmp, p)); err == nil {
+				got[p] = string(bs)
+			}
+		}
+
+		return got
+	}
+
+	copyDocs("blobs")
+
+	// blobs are identified by their sha, cached content is used even if the server changed
+	files["docs/a.md"] = "changed"
+
+	for _, download := range []string{"blobs", "archive"} {
+		if got := copyDocs(download); len(got) != 1 || got["a.md"] != "a" {
+			t.Errorf("copy with %v from cache = %v, expected cached a.md only", download, got)
+		}
+	}
+}
+
+func TestCopyOffline(t *testing.T) {
+	t.Setenv("GH_ENTERPRISE_TOKEN", "secret")
+
+	srv := fakeEnterprise(t, map[string]string{"docs/a.md": "a", "docs/b.md": "b"})
+	url := srv.URL + "/o/r"
+
+	c := &Copier{APIURL: srv.URL}
+	cached := &cache.Cache{Dir: t.TempDir()}
+
+	copyDocs := func(keep func(string) bool, offline bool) error {
+		_, err := c.Copy(context.Background(), copier.CopyConfig{
+			URL:     url,
+			From:    "docs/",
+			Keep:    keep,
+			TempDir: t.TempDir(),
+			Locked:  fakeSha,
+			Cache:   cached,
+			Offline: offline,
+		})
+
+		return err
+	}
+
+	if err := copyDocs(func(p string) bool { return p == "a.md" }, false); err != nil {
+		t.Fatalf("copy failed: %v", err)
+	}
+
+	// offline copies must not access the server
+	srv.Close()
+
+	if err := copyDocs(func(p string) bool { return p == "a.md" }, true); err != nil {
+		t.Errorf("offline copy failed: %v", err)
+	}
+
+	err := copyDocs(func(string) bool { return true }, true)
+	if !errors.Is(err, copier.ErrOffline) || !strings.Contains(err.Error(), "docs/b.md") {
+		t.Errorf("offline copy of uncached files error = %v, expected docs/b.md to be listed", err)
+	}
+}
+
+func TestCopyInvalidLocked(t *testing.T) {
+	t.Setenv("GH_ENTERPRISE_TOKEN", "secret")
+
+	srv := fakeEnterprise(t, map[string]string{"docs/a.md": "a"})
+	c := &Copier{APIURL: srv.URL}
+
+	for _, locked := range []string{fakeSha[:7], "main", strings.ToUpper(fakeSha), "-" + fakeSha[1:]} {
+		_, err := c.Copy(context.Background(), copier.CopyConfig{
+			URL:     srv.URL + "/o/r",
+			Keep:    func(string) bool { return true },
+			TempDir: t.TempDir(),
+			Locked:  locked,
+		})
+
+		if err == nil || !strings.Contains(err.Error(), "full commit sha") {
+			t.Errorf("Copy() locked to %q = %v, expected error about the full commit sha", locked, err)
+		}
+	}
+}
+
+func TestCopyConstraint(t *testing.T) {
+	t.Setenv("GH_ENTERPRISE_TOKEN", "secret")
+
+	srv := fakeEnterprise(t, map[string]string{"docs/a.md": "a"})
+	c := &Copier{APIURL: srv.URL}
+
+	res, err := c.Copy(context.Background(), copier.CopyConfig{
+		URL:     srv.URL + "/o/r",
+		From:    "docs/",
+		Keep:    func(string) bool { return true },
+		Options: map[string]string{"ref": "^1.0"},
+		TempDir: t.TempDir(),
+	})
+
+	if err != nil {
+		t.Fatalf("copy failed: %v", err)
+	}
+
+	if info := res.(*copier.SourceInfo); info.Tag != "v1.2.0" || info.Reference != fakeSha {
+		t.Errorf("resolved to %v at %v, expected v1.2.0 at %v", info.Tag, info.Reference, fakeSha)
+	}
+}
+
+func TestResolve(t *testing.T) {
+	t.Setenv("GH_ENTERPRISE_TOKEN", "secret")
+
+	srv := fakeEnterprise(t, map[string]string{"docs/a.md": "a"})
+	c := &Copier{APIURL: srv.URL}
+
+	res, err := c.Resolve(context.Background(), copier.CopyConfig{
+		URL:     srv.URL + "/o/r",
+		Options: map[string]string{"ref": "main"},
+		Locked:  "0000000000000000000000000000000000000000",
+	})
+
+	if err != nil {
+		t.Fatalf("resolve failed: %v", err)
+	}
+
+	want := copier.Resolution{Reference: fakeSha, Latest: "v1.2.0", Behind: 3}
+	if *res != want {
+		t.Errorf("Resolve() = %+v, expected %+v", *res, want)
+	}
+}
+
+func TestCommits(t *testing.T) {
+	t.Setenv("GH_ENTERPRISE_TOKEN", "secret")
+
+	srv := fakeEnterprise(t, map[string]string{"docs/a.md": "a"})
+	c := &Copier{APIURL: srv.URL}
+
+	for from, want := range map[string][]string{
+		"":      {fakeSha, "2222222222222222222222222222222222222222", "1111111111111111111111111111111111111111"},
+		"docs/": {fakeSha, "1111111111111111111111111111111111111111"},
+	} {
+		commits, err := c.Commits(context.Background(), copier.CopyConfig{URL: srv.URL + "/o/r", From: from}, "0000000000000000000000000000000000000000", fakeSha)
+		if err != nil {
+			t.Fatalf("Commits() error = %v", err)
+		}
+
+		var got []string
+		for _, com := range commits {
+			got = append(got, com.Reference)
+		}
+
+		if strings.Join(got, ",") != strings.Join(want, ",") {
+			t.Errorf("Commits() from %q = %v, expected %v", from, got, want)
+		}
+
+		if commits[len(commits)-1].URL == "" || commits[len(commits)-1].Author.Name != "Jane" {
+			t.Errorf("Commits() from %q = %+v, expected url and author", from, commits[len(commits)-1])
+		}
+	}
+}
+
+func TestEndpoints(t *testing.T) {
+	tests := []struct {
+		name    string
+		copier  Copier
+		url     string
+		options map[string]string
+		api     string
+		upload  string
+	}{
+		{
+			name: "github.com",
+			url:  "https://github.com/o/r",
+		},
+		{
+			name:   "enterprise host",
+			copier: Copier{Hosts: []string{"git.corp.example"}},
+			url:    "https://git.corp.example/o/r",
+			api:    "https://git.corp.example/api/v3/",
+			upload: "https://git.corp.example/api/v3/",
+		},
+		{
+			name:   "configured endpoints",
+			copier: Copier{APIURL: "https://api.corp.example/", UploadURL: "https://uploads.corp.example/", Hosts: []string{"git.corp.example"}},
+			url:    "git.corp.example/o/r",
+			api:    "https://api.corp.example/",
+			upload: "https://uploads.corp.example/",
+		},
+		{
+			name:    "per dependency options",
+			copier:  Copier{APIURL: "https://api.corp.example/"},
+			url:     "https://git.other.example/o/r",
+			options: map[string]string{"api_url": "https://git.other.example/api/v3/"},
+			api:     "https://git.other.example/api/v3/",
+			upload:  "https://git.other.example/api/v3/",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api, upload := tt.copier.endpoints(copier.CopyConfig{URL: tt.url, Options: tt.options})
+			if api != tt.api || upload != tt.upload {
+				t.Errorf("endpoints are %q, %q, expected %q, %q", api, upload, tt.api, tt.upload)
+			}
+		})
+	}
+}
+
+func TestMatchesEnterprise(t *testing.T) {
+	c := &Copier{Hosts: []string{"git.corp.example"}}
+
+	for url, want := range map[string]bool{
+		"https://github.com/o/r":        true,
+		"https://git.corp.example/o/r":  true,
+		"git.corp.example/o/r":          true,
+		"https://gitlab.com/o/r":        false,
+		"https://corp.example/o/r":      false,
+		"https://git.corp.example.org/": false,
+	} {
+		if got := c.Matches(url); got != want {
+			t.Errorf("Matches(%v) is %v, expected %v", url, got, want)
+		}
+	}
+}
@@ -5,108 +5,170 @@ import (
 	"errors"
 	"fmt"
 	"os"
-	"path"
-	"path/filepath"
 	"regexp"
 	"strings"
 
 	"github.com/audiotool/pasta/pkg/copier"
-	"github.com/audiotool/pasta/pkg/utils"
+	"github.com/audiotool/pasta/pkg/hosted"
+	"github.com/audiotool/pasta/pkg/semver"
 	gh "github.com/google/go-github/v53/github"
-	"github.com/sourcegraph/conc/pool"
 	"golang.org/x/oauth2"
 )
 
-type Copier struct{}
+// Copier copies files from repositories on github.com, or on GitHub Enterprise Server instances.
+type Copier struct {
+	// APIURL is the API endpoint of the GitHub Enterprise Server instance serving Hosts,
+	// e.g. "https://git.corp.example/api/v3/". Defaults to "https://<host>/api/v3/".
+	// Repositories on github.com always use the API of github.com.
+	APIURL string
+	// UploadURL is the upload endpoint of the GitHub Enterprise Server instance. Defaults to APIURL.
+	UploadURL string
+	// Hosts lists the hosts of GitHub Enterprise Server instances, e.g. "git.corp.example".
+	// The host of APIURL is always included.
+	Hosts []string
+}
 
-func (*Copier) Matches(url string) bool {
+func (c *Copier) Matches(url string) bool {
 	match, err := regexp.MatchString(".*github\\.com.*", url)
-	return err == nil && match
+	if err == nil && match {
+		return true
+	}
+
+	return c.isEnterpriseHost(hosted.Host(url))
 }
 
-func (*Copier) Copy(ctx context.Context, config copier.CopyConfig) (any, error) {
-	client := createClient(ctx)
+func (c *Copier) Copy(ctx context.Context, config copier.CopyConfig) (any, error) {
+	if config.Locked != "" {
+		if err := checkSha(config.Locked); err != nil {
+			return nil, fmt.Errorf("invalid locked reference: %w", err)
+		}
+	}
 
-	// get repo owner and name from url
-	owner, repo, err := parse(config.URL)
+	client, owner, repo, err := c.connect(ctx, config)
 	if err != nil {
-		return nil, fmt.Errorf("couldn't parse url %v, error: %v", config.URL, err)
+		return nil, err
 	}
 
 	// check if we have access to repo
-	if _, _, err := client.Repositories.Get(ctx, owner, repo); err != nil {
-		if strings.Contains(err.Error(), "404 Not Found") {
-			return nil, fmt.Errorf("can't access repo %v, do you have correct access rights & API key setup?", config.URL)
+	if !config.Offline {
+		if _, _, err := client.Repositories.Get(ctx, owner, repo); err != nil {
+			if strings.Contains(err.Error(), "404 Not Found") {
+				return nil, fmt.Errorf("can't access repo %v, do you have correct access rights & API key setup?", config.URL)
+			}
+
+			return nil, fmt.Errorf("error accessing repo: %v", err)
 		}
+	}
 
-		return nil, fmt.Errorf("error accessing repo: %v", err)
+	// get sha from ref, unless the dependency is locked to a sha
+	sha, tag := config.Locked, ""
+	if sha == "" && config.Offline {
+		return nil, fmt.Errorf("%w: ref must be resolved, but the dependency isn't locked", copier.ErrOffline)
 	}
 
-	// get sha from ref
-	ref := config.Options["ref"]
-	sha, err := getCommitSha(ctx, client, owner, repo, ref)
-	if err != nil {
-		return nil, fmt.Errorf("error retreiving sha: %v", err)
+	if sha == "" {
+		sha, tag, err = resolve(ctx, client, owner, repo, config.Options)
+		if err != nil {
+			return nil, fmt.Errorf("error retreiving sha: %v", err)
+		}
 	}
 
-	// fetch the tree
-	tree, _, err := client.Git.GetTree(ctx, owner, repo, sha, true)
+	info, err := hosted.Copy(ctx, &api{client: client, owner: owner, repo: repo}, config, sha, hosted.Cache{
+		Name: cacheName,
+		Repo: hosted.Host(config.URL) + "/" + owner + "/" + repo,
+	})
 	if err != nil {
-		return nil, fmt.Errorf("error getting tree: %v", err)
+		return nil, err
 	}
 
-	// download files, save to temp directory
-	wg := pool.New().WithMaxGoroutines(20)
-	for _, entry := range tree.Entries {
-		if entry.GetType() != "blob" {
-			continue
-		}
+	info.Tag = tag
+	return info, nil
+}
 
-		entry := entry
+// cacheName is the name the copier stores its entries under in the cache.
+const cacheName = "github"
 
-		// check if file path is in dest directory
-		p := entry.GetPath()
+var shaRegexp = regexp.MustCompile(`^[0-9a-f]{40}$`)
 
-		if !strings.HasPrefix(p, config.From) {
-			continue
-		}
+// checkSha returns an error if ref isn't a full commit sha. The API would answer abbreviated
+// shas or anything else with a 404, which doesn't tell what is wrong.
+func checkSha(ref string) error {
+	if !shaRegexp.MatchString(ref) {
+		return fmt.Errorf("%q must be a full commit sha of 40 hex characters", ref)
+	}
+
+	return nil
+}
+
+// connect creates a client for the instance the dependency is hosted on, and returns
+// it together with the owner and name of the repository.
+func (c *Copier) connect(ctx context.Context, config copier.CopyConfig) (*gh.Client, string, string, error) {
+	apiURL, uploadURL := c.endpoints(config)
 
-		relp, err := filepath.Rel(config.From, p)
+	if apiURL == "" {
+		// get repo owner and name from url
+		owner, repo, err := parse(config.URL)
 		if err != nil {
-			return nil, fmt.Errorf("unable to create relp: %v", err)
+			return nil, "", "", fmt.Errorf("couldn't parse url %v, error: %v", config.URL, err)
 		}
 
-		// check if user wants to keep this file based on relp
-		if !config.Keep(relp) {
-			continue
+		return createClient(ctx), owner, repo, nil
+	}
+
+	owner, repo, err := parseHost(config.URL, hosted.Host(config.URL))
+	if err != nil {
+		return nil, "", "", fmt.Errorf("couldn't parse url %v, error: %v", config.URL, err)
+	}
+
+	client, err := createEnterpriseClient(ctx, apiURL, uploadURL)
+	if err != nil {
+		return nil, "", "", fmt.Errorf("couldn't create client for %v: %v", apiURL, err)
+	}
+
+	return client, owner, repo, nil
+}
+
+// endpoints returns the API and upload endpoints to use for the dependency. The options
+// "api_url" and "upload_url" take precedence over the copier's configuration. Returns
+// empty strings for repositories on github.com.
+func (c *Copier) endpoints(config copier.CopyConfig) (apiURL string, uploadURL string) {
+	apiURL, uploadURL = config.Options["api_url"], config.Options["upload_url"]
+
+	if host := hosted.Host(config.URL); apiURL == "" && c.isEnterpriseHost(host) {
+		apiURL = c.APIURL
+		if apiURL == "" {
+			apiURL = "https://" + host + "/api/v3/"
 		}
 
-		// download concurrently
-		wg.Go(func() {
-			bs, _, err := client.Git.GetBlobRaw(ctx, owner, repo, entry.GetSHA())
-			if err != nil {
-				fmt.Println("Error fetching file", entry.GetPath(), err)
-				return
-			}
+		if uploadURL == "" {
+			uploadURL = c.UploadURL
+		}
+	}
+
+	if apiURL != "" && uploadURL == "" {
+		uploadURL = apiURL
+	}
 
-			err = utils.SaveFile(bs, path.Join(config.TempDir, relp))
+	return apiURL, uploadURL
+}
 
-			if err != nil {
-				fmt.Println("Error downloading file", entry.GetPath(), err)
-				return
-			}
-		})
+// isEnterpriseHost returns true if host is served by a GitHub Enterprise Server instance.
+func (c *Copier) isEnterpriseHost(host string) bool {
+	if host == "" {
+		return false
 	}
 
-	wg.Wait()
+	if c.APIURL != "" && hosted.Host(c.APIURL) == host {
+		return true
+	}
 
-	// create info message for pasta.result.yaml: Fetch commit
-	com, _, err := client.Git.GetCommit(ctx, owner, repo, sha)
-	if err != nil {
-		return nil, fmt.Errorf("error getting commit info: %v", err)
+	for _, h := range c.Hosts {
+		if strings.EqualFold(h, host) {
+			return true
+		}
 	}
 
-	return toCommitInfo(com), nil
+	return false
 }
 
 // create a github client. Uses env var GITHUB_TOKEN as api token
@@ -124,13 +186,37 @@ func createClient(ctx context.Context) *gh.Client {
 	return gh.NewClient(tc)
 }
 
+// create a client for a GitHub Enterprise Server instance. Uses env var GH_ENTERPRISE_TOKEN
+// as api token if set, otherwise GITHUB_TOKEN, otherwise initializes client without a token.
+func createEnterpriseClient(ctx context.Context, apiURL, uploadURL string) (*gh.Client, error) {
+	token := os.Getenv("GH_ENTERPRISE_TOKEN")
+	if token == "" {
+		token = os.Getenv("GITHUB_TOKEN")
+	}
+
+	if token == "" {
+		return gh.NewEnterpriseClient(apiURL, uploadURL, nil)
+	}
+
+	ts := oauth2.StaticTokenSource(
+		&oauth2.Token{AccessToken: token},
+	)
+	tc := oauth2.NewClient(ctx, ts)
+	return gh.NewEnterpriseClient(apiURL, uploadURL, tc)
+}
+
 var errMalformedURL = errors.New("url is malformed, must be of shape github.com/<owner>/<repo>/")
 
 // given a github url like github.com/<owner>/<repo-name>, returns owner & repo-name
 func parse(url string) (owner string, repo string, err error) {
+	return parseHost(url, "github.com")
+}
+
+// given a repository url like <host>/<owner>/<repo-name>, returns owner & repo-name
+func parseHost(url string, host string) (owner string, repo string, err error) {
 	// extract owner & repo using regex, assumptions:
-	// repo name & owner name are only alphanumerical characters + `-`, todo: confirm
-	r, err := regexp.Compile(`github\.com/(?P<owner>[a-zA-Z\-]+)/(?P<repo>[a-zA-z\-]+)/?(?P<rest>.*)$`)
+	// repo name & owner name are only alphanumerical characters + `-`, `_` and `.`
+	r, err := regexp.Compile(regexp.QuoteMeta(host) + `/(?P<owner>[a-zA-Z0-9_.\-]+)/(?P<repo>[a-zA-Z0-9_.\-]+)/?(?P<rest>.*)$`)
 	if err != nil {
 		return "", "", err
 	}
@@ -148,6 +234,98 @@ var (
 	errCouldNotResolveRef = errors.New("unable to resolve ref; must be branch, tag, or sha")
 )
 
+// Resolve returns what the ref of the dependency resolves to, the newest tag of the
+// repository, and how many commits the locked sha is behind.
+func (c *Copier) Resolve(ctx context.Context, config copier.CopyConfig) (*copier.Resolution, error) {
+	client, owner, repo, err := c.connect(ctx, config)
+	if err != nil {
+		return nil, err
+	}
+
+	res := &copier.Resolution{Behind: -1}
+
+	res.Reference, res.Tag, err = resolve(ctx, client, owner, repo, config.Options)
+	if err != nil {
+		return nil, fmt.Errorf("error retreiving sha: %v", err)
+	}
+
+	tags, err := listTags(ctx, client, owner, repo)
+	if err != nil {
+		return nil, err
+	}
+
+	// repositories without version tags have no latest tag
+	res.Latest, _ = semver.Latest("latest", tagNames(tags), config.Options["prerelease"] == "true")
+
+	switch config.Locked {
+	case "":
+	case res.Reference:
+		res.Behind = 0
+	default:
+		// the locked sha might not be an ancestor anymore, e.g. after a force push
+		cmp, _, err := client.Repositories.CompareCommits(ctx, owner, repo, config.Locked, res.Reference, nil)
+		if err == nil && cmp.GetBehindBy() == 0 {
+			res.Behind = cmp.GetAheadBy()
+		}
+	}
+
+	return res, nil
+}
+
+// resolve returns the sha the option ref resolves to, and the tag if it is a version constraint.
+func resolve(ctx context.Context, client *gh.Client, owner, repo string, options map[string]string) (string, string, error) {
+	ref := options["ref"]
+
+	if !semver.IsConstraint(ref) {
+		sha, err := getCommitSha(ctx, client, owner, repo, ref)
+		return sha, "", err
+	}
+
+	tags, err := listTags(ctx, client, owner, repo)
+	if err != nil {
+		return "", "", err
+	}
+
+	tag, err := semver.Latest(ref, tagNames(tags), options["prerelease"] == "true")
+	if err != nil {
+		return "", "", err
+	}
+
+	return tags[tag], tag, nil
+}
+
+// listTags returns the names of all tags of the repository, mapped to the sha they point to.
+func listTags(ctx context.Context, client *gh.Client, owner, repo string) (map[string]string, error) {
+	shas := make(map[string]string)
+	opts := &gh.ListOptions{PerPage: 100}
+
+	for {
+		tags, resp, err := client.Repositories.ListTags(ctx, owner, repo, opts)
+		if err != nil {
+			return nil, fmt.Errorf("error listing tags: %w", err)
+		}
+
+		for _, t := range tags {
+			shas[t.GetName()] = t.GetCommit().GetSHA()
+		}
+
+		if resp.NextPage == 0 {
+			return shas, nil
+		}
+
+		opts.Page = resp.NextPage
+	}
+}
+
+func tagNames(tags map[string]string) []string {
+	names := make([]string, 0, len(tags))
+	for name := range tags {
+		names = append(names, name)
+	}
+
+	return names
+}
+
 // returns the sha based on ref. If ref is the empty string, returns the sha of the default branch head.
 func getCommitSha(
 	ctx context.Context,
@@ -0,0 +1,102 @@
+package github
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/audiotool/pasta/pkg/copier"
+	gh "github.com/google/go-github/v53/github"
+)
+
+// Commits returns the commits after from, up to and including to, that touch files below From.
+func (c *Copier) Commits(ctx context.Context, config copier.CopyConfig, from, to string) ([]copier.Commit, error) {
+	client, owner, repo, err := c.connect(ctx, config)
+	if err != nil {
+		return nil, err
+	}
+
+	// all commits between from and to, oldest first
+	var between []*gh.RepositoryCommit
+	opts := &gh.ListOptions{PerPage: 100}
+
+	for {
+		cmp, resp, err := client.Repositories.CompareCommits(ctx, owner, repo, from, to, opts)
+		if err != nil {
+			return nil, fmt.Errorf("error comparing %v with %v: %w", from, to, err)
+		}
+
+		between = append(between, cmp.Commits...)
+
+		if resp.NextPage == 0 {
+			break
+		}
+
+		opts.Page = resp.NextPage
+	}
+
+	if config.From == "" || len(between) == 0 {
+		commits := make([]copier.Commit, 0, len(between))
+		for i := len(between) - 1; i >= 0; i-- {
+			commits = append(commits, toCommit(between[i]))
+		}
+
+		return commits, nil
+	}
+
+	// the compare API can't filter by path, the commits API can't filter by range.
+	// List the commits touching From since the oldest commit in range instead, and
+	// keep the ones in range.
+	inRange := make(map[string]bool, len(between))
+	since := between[0].GetCommit().GetCommitter().GetDate().Time
+
+	for _, rc := range between {
+		inRange[rc.GetSHA()] = true
+
+		if date := rc.GetCommit().GetCommitter().GetDate().Time; date.Before(since) {
+			since = date
+		}
+	}
+
+	var commits []copier.Commit
+	listOpts := &gh.CommitsListOptions{
+		SHA:         to,
+		Path:        strings.TrimSuffix(config.From, "/"),
+		Since:       since,
+		ListOptions: gh.ListOptions{PerPage: 100},
+	}
+
+	for {
+		rcs, resp, err := client.Repositories.ListCommits(ctx, owner, repo, listOpts)
+		if err != nil {
+			return nil, fmt.Errorf("error listing commits: %w", err)
+		}
+
+		for _, rc := range rcs {
+			if inRange[rc.GetSHA()] {
+				commits = append(commits, toCommit(rc))
+			}
+		}
+
+		if resp.NextPage == 0 {
+			return commits, nil
+		}
+
+		listOpts.Page = resp.NextPage
+	}
+}
+
+func toCommit(rc *gh.RepositoryCommit) copier.Commit {
+	author := rc.GetCommit().GetAuthor()
+
+	return copier.Commit{
+		Reference: rc.GetSHA(),
+		Message:   rc.GetCommit().GetMessage(),
+		Author: copier.Author{
+			Date:  author.GetDate().Time,
+			Name:  author.GetName(),
+			Email: author.GetEmail(),
+		},
+		URL: rc.GetHTMLURL(),
+	}
+}
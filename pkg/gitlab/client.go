@@ -0,0 +1,228 @@
+package gitlab
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// client is a minimal client of the GitLab v4 API, for a single project.
+type client struct {
+	// api is the API endpoint, without trailing slash
+	api string
+	// id is the url encoded path of the project
+	id string
+	// token is sent as private token, if set
+	token string
+	http  *http.Client
+}
+
+// pageSize is the number of entries requested per page, the maximum GitLab allows
+const pageSize = 100
+
+// create a client for project. Uses env var GITLAB_TOKEN as private token
+// if set, otherwise initializes client without a token.
+func newClient(apiURL, project string) *client {
+	return &client{
+		api:   strings.TrimSuffix(apiURL, "/"),
+		id:    url.PathEscape(project),
+		token: os.Getenv("GITLAB_TOKEN"),
+		http:  http.DefaultClient,
+	}
+}
+
+// get requests p, relative to the project, and returns the response if its status is 200.
+func (c *client) get(ctx context.Context, p string, query url.Values) (*http.Response, error) {
+	u := c.api + "/projects/" + c.id + p
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if c.token != "" {
+		req.Header.Set("PRIVATE-TOKEN", c.token)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+
+		if resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusUnauthorized {
+			return nil, fmt.Errorf("%v: %v, do you have correct access rights & GITLAB_TOKEN setup?", u, resp.Status)
+		}
+
+		return nil, fmt.Errorf("%v: unexpected status %v", u, resp.Status)
+	}
+
+	return resp, nil
+}
+
+// getJSON requests p, relative to the project, and decodes the response into v.
+func (c *client) getJSON(ctx context.Context, p string, query url.Values, v any) (*http.Response, error) {
+	resp, err := c.get(ctx, p, query)
+	if err != nil {
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return nil, fmt.Errorf("error decoding response: %w", err)
+	}
+
+	return resp, nil
+}
+
+type project struct {
+	DefaultBranch string `json:"default_branch"`
+}
+
+func (c *client) project(ctx context.Context) (*project, error) {
+	var p project
+	_, err := c.getJSON(ctx, "", nil, &p)
+	return &p, err
+}
+
+// namedCommit is a branch or tag as returned by the GitLab API.
+type namedCommit struct {
+	Commit commit `json:"commit"`
+}
+
+// branch returns the sha of the head of branch name.
+func (c *client) branch(ctx context.Context, name string) (string, error) {
+	var b namedCommit
+	if _, err := c.getJSON(ctx, "/repository/branches/"+url.PathEscape(name), nil, &b); err != nil {
+		return "", err
+	}
+
+	return b.Commit.ID, nil
+}
+
+// tag returns the sha of the commit tag name points to.
+func (c *client) tag(ctx context.Context, name string) (string, error) {
+	var t namedCommit
+	if _, err := c.getJSON(ctx, "/repository/tags/"+url.PathEscape(name), nil, &t); err != nil {
+		return "", err
+	}
+
+	return t.Commit.ID, nil
+}
+
+func (c *client) commit(ctx context.Context, sha string) (*commit, error) {
+	var com commit
+	_, err := c.getJSON(ctx, "/repository/commits/"+url.PathEscape(sha), nil, &com)
+	return &com, err
+}
+
+// tags returns the names of all tags of the repository, mapped to the sha they point to.
+func (c *client) tags(ctx context.Context) (map[string]string, error) {
+	tags := make(map[string]string)
+
+	for page := "1"; page != ""; {
+		query := url.Values{
+			"per_page": {fmt.Sprint(pageSize)},
+			"page":     {page},
+		}
+
+		var batch []struct {
+			Name   string `json:"name"`
+			Commit commit `json:"commit"`
+		}
+
+		resp, err := c.getJSON(ctx, "/repository/tags", query, &batch)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, t := range batch {
+			tags[t.Name] = t.Commit.ID
+		}
+
+		page = resp.Header.Get("X-Next-Page")
+	}
+
+	return tags, nil
+}
+
+// compare returns the number of commits in to, that are not in from.
+func (c *client) compare(ctx context.Context, from, to string) (int, error) {
+	var cmp struct {
+		Commits []commit `json:"commits"`
+	}
+
+	if _, err := c.getJSON(ctx, "/repository/compare", url.Values{"from": {from}, "to": {to}}, &cmp); err != nil {
+		return 0, err
+	}
+
+	return len(cmp.Commits), nil
+}
+
+// treeEntry is an entry of a repository tree as returned by the GitLab API.
+type treeEntry struct {
+	// ID is the sha of the blob or tree
+	ID   string `json:"id"`
+	Type string `json:"type"`
+	Path string `json:"path"`
+	// Mode is the git file mode, e.g. "100755" for executables
+	Mode string `json:"mode"`
+}
+
+// tree returns all entries of the repository tree at sha, following pagination.
+func (c *client) tree(ctx context.Context, sha string) ([]treeEntry, error) {
+	var entries []treeEntry
+
+	for page := "1"; page != ""; {
+		query := url.Values{
+			"ref":       {sha},
+			"recursive": {"true"},
+			"per_page":  {fmt.Sprint(pageSize)},
+			"page":      {page},
+		}
+
+		var batch []treeEntry
+		resp, err := c.getJSON(ctx, "/repository/tree", query, &batch)
+		if err != nil {
+			return nil, err
+		}
+
+		entries = append(entries, batch...)
+		page = resp.Header.Get("X-Next-Page")
+	}
+
+	return entries, nil
+}
+
+// blob returns the content of the blob with sha.
+func (c *client) blob(ctx context.Context, sha string) ([]byte, error) {
+	resp, err := c.get(ctx, "/repository/blobs/"+url.PathEscape(sha)+"/raw", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+
+	return io.ReadAll(resp.Body)
+}
+
+// archive returns the gzip compressed tarball of the repository at sha.
+func (c *client) archive(ctx context.Context, sha string) (io.ReadCloser, error) {
+	resp, err := c.get(ctx, "/repository/archive.tar.gz", url.Values{"sha": {sha}})
+	if err != nil {
+		return nil, err
+	}
+
+	return resp.Body, nil
+}
@@ -0,0 +1,49 @@
+package gitlab
+
+import (
+	"context"
+	"io"
+
+	"github.com/audiotool/pasta/pkg/copier"
+	"github.com/audiotool/pasta/pkg/hosted"
+)
+
+// api serves a project to hosted.Copy through the GitLab API.
+type api struct {
+	client *client
+}
+
+func (a *api) Tree(ctx context.Context, sha string) ([]hosted.Blob, error) {
+	tree, err := a.client.tree(ctx, sha)
+	if err != nil {
+		return nil, err
+	}
+
+	var blobs []hosted.Blob
+	for _, entry := range tree {
+		if entry.Type == "blob" {
+			blobs = append(blobs, hosted.Blob{Path: entry.Path, SHA: entry.ID, Mode: entry.Mode})
+		}
+	}
+
+	return blobs, nil
+}
+
+func (a *api) Commit(ctx context.Context, sha string) (*copier.SourceInfo, error) {
+	com, err := a.client.commit(ctx, sha)
+	if err != nil {
+		return nil, err
+	}
+
+	return toCommitInfo(com), nil
+}
+
+func (a *api) Blob(ctx context.Context, sha string) ([]byte, error) {
+	return a.client.blob(ctx, sha)
+}
+
+// Archive downloads the tarball of the project, whose files are inside a top level
+// directory named <project>-<sha>-<sha>.
+func (a *api) Archive(ctx context.Context, sha string) (io.ReadCloser, error) {
+	return a.client.archive(ctx, sha)
+}
@@ -0,0 +1,291 @@
+package gitlab
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/audiotool/pasta/pkg/copier"
+	"github.com/audiotool/pasta/pkg/hosted"
+	"github.com/audiotool/pasta/pkg/semver"
+)
+
+// Copier copies files from projects on gitlab.com, or on self-hosted GitLab instances.
+type Copier struct {
+	// APIURL is the v4 API endpoint of the self-hosted instance serving Hosts,
+	// e.g. "https://gitlab.corp.example/api/v4". Defaults to "https://<host>/api/v4".
+	// Projects on gitlab.com always use the API of gitlab.com.
+	APIURL string
+	// Hosts lists the hosts of self-hosted GitLab instances, e.g. "gitlab.corp.example".
+	// The host of APIURL is always included.
+	Hosts []string
+}
+
+const gitlabHost = "gitlab.com"
+
+func (c *Copier) Matches(url string) bool {
+	host := hosted.Host(url)
+	return host == gitlabHost || c.isSelfHosted(host)
+}
+
+func (c *Copier) Copy(ctx context.Context, config copier.CopyConfig) (any, error) {
+	project, err := parse(config.URL)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't parse url %v, error: %v", config.URL, err)
+	}
+
+	client := newClient(c.apiURL(config), project)
+
+	// get sha from ref, unless the dependency is locked to a sha
+	sha, tag := config.Locked, ""
+	if sha == "" && config.Offline {
+		return nil, fmt.Errorf("%w: ref must be resolved, but the dependency isn't locked", copier.ErrOffline)
+	}
+
+	if sha == "" {
+		sha, tag, err = resolve(ctx, client, config.Options)
+		if err != nil {
+			return nil, fmt.Errorf("error retreiving sha: %v", err)
+		}
+	}
+
+	info, err := hosted.Copy(ctx, &api{client: client}, config, sha, hosted.Cache{
+		Name: cacheName,
+		Repo: hosted.Host(config.URL) + "/" + project,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	info.Tag = tag
+	return info, nil
+}
+
+// cacheName is the name the copier stores its entries under in the cache.
+const cacheName = "gitlab"
+
+// apiURL returns the API endpoint to use for the dependency. The option "api_url"
+// takes precedence over the copier's configuration.
+func (c *Copier) apiURL(config copier.CopyConfig) string {
+	if api := config.Options["api_url"]; api != "" {
+		return api
+	}
+
+	host := hosted.Host(config.URL)
+
+	if host != gitlabHost && c.APIURL != "" {
+		return c.APIURL
+	}
+
+	return "https://" + host + "/api/v4"
+}
+
+// isSelfHosted returns true if host is served by a self-hosted GitLab instance.
+func (c *Copier) isSelfHosted(host string) bool {
+	if host == "" {
+		return false
+	}
+
+	if c.APIURL != "" && hosted.Host(c.APIURL) == host {
+		return true
+	}
+
+	for _, h := range c.Hosts {
+		if strings.EqualFold(h, host) {
+			return true
+		}
+	}
+
+	return false
+}
+
+var urlRegexp = regexp.MustCompile(`^(?:[a-zA-Z][a-zA-Z0-9+.\-]*://)?([^/?#]+)(/[^?#]*)?`)
+
+var errMalformedURL = errors.New("url is malformed, must be of shape <host>/<group>/[<subgroup>/...]<project>")
+
+var segmentRegexp = regexp.MustCompile(`^[a-zA-Z0-9_.\-]+$`)
+
+// given a gitlab url like gitlab.com/<group>/<subgroup>/<project>, returns the path of the project.
+// Web urls pointing into the project, like <project>/-/tree/main, are accepted as well.
+func parse(url string) (string, error) {
+	ms := urlRegexp.FindStringSubmatch(url)
+	if ms == nil {
+		return "", errMalformedURL
+	}
+
+	p, _, _ := strings.Cut(ms[2], "/-/")
+	p = strings.TrimSuffix(strings.Trim(p, "/"), ".git")
+
+	segments := strings.Split(p, "/")
+
+	// a project always belongs to a group or user
+	if len(segments) < 2 {
+		return "", errMalformedURL
+	}
+
+	for _, s := range segments {
+		if !segmentRegexp.MatchString(s) {
+			return "", errMalformedURL
+		}
+	}
+
+	return p, nil
+}
+
+var (
+	errCouldNotFetchProject = errors.New("failed fetching project")
+	errCouldNotGetRef       = errors.New("failed getting ref")
+	errCouldNotResolveRef   = errors.New("unable to resolve ref; must be branch, tag, or sha")
+)
+
+// returns the sha based on ref. If ref is the empty string, returns the sha of the default branch head.
+func resolveRef(ctx context.Context, client *client, ref string) (string, error) {
+	// if ref is empty string, set ref to "heads/<default-branch>"
+	if ref == "" {
+		p, err := client.project(ctx)
+		if err != nil {
+			return "", fmt.Errorf("%w: %w", errCouldNotFetchProject, err)
+		}
+
+		ref = "heads/" + p.DefaultBranch
+	}
+
+	// if type of ref is explicitly set, only try that
+	if name, ok := strings.CutPrefix(ref, "heads/"); ok {
+		sha, err := client.branch(ctx, name)
+		if err != nil {
+			return "", fmt.Errorf("%w: %w", errCouldNotGetRef, err)
+		}
+
+		return sha, nil
+	}
+
+	if name, ok := strings.CutPrefix(ref, "tags/"); ok {
+		sha, err := client.tag(ctx, name)
+		if err != nil {
+			return "", fmt.Errorf("%w: %w", errCouldNotGetRef, err)
+		}
+
+		return sha, nil
+	}
+
+	if sha, ok := strings.CutPrefix(ref, "commit/"); ok {
+		return sha, nil
+	}
+
+	// else, try to resolve ref as one of heads, tags or commit-sha
+
+	if sha, err := client.branch(ctx, ref); err == nil {
+		return sha, nil
+	}
+
+	if sha, err := client.tag(ctx, ref); err == nil {
+		return sha, nil
+	}
+
+	if com, err := client.commit(ctx, ref); err == nil {
+		return com.ID, nil
+	}
+
+	return "", errCouldNotResolveRef
+}
+
+// Resolve returns what the ref of the dependency resolves to, the newest tag of the
+// project, and how many commits the locked sha is behind.
+func (c *Copier) Resolve(ctx context.Context, config copier.CopyConfig) (*copier.Resolution, error) {
+	project, err := parse(config.URL)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't parse url %v, error: %v", config.URL, err)
+	}
+
+	client := newClient(c.apiURL(config), project)
+
+	res := &copier.Resolution{Behind: -1}
+
+	res.Reference, res.Tag, err = resolve(ctx, client, config.Options)
+	if err != nil {
+		return nil, fmt.Errorf("error retreiving sha: %v", err)
+	}
+
+	tags, err := client.tags(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error listing tags: %w", err)
+	}
+
+	// projects without version tags have no latest tag
+	res.Latest, _ = semver.Latest("latest", tagNames(tags), config.Options["prerelease"] == "true")
+
+	switch config.Locked {
+	case "":
+	case res.Reference:
+		res.Behind = 0
+	default:
+		// the locked sha might not be an ancestor anymore, e.g. after a force push, then
+		// the reference lacks some of its commits
+		ahead, err := client.compare(ctx, res.Reference, config.Locked)
+		if err != nil || ahead > 0 {
+			break
+		}
+
+		if behind, err := client.compare(ctx, config.Locked, res.Reference); err == nil {
+			res.Behind = behind
+		}
+	}
+
+	return res, nil
+}
+
+// resolve returns the sha the option ref resolves to, and the tag if it is a version constraint.
+func resolve(ctx context.Context, client *client, options map[string]string) (string, string, error) {
+	ref := options["ref"]
+
+	if !semver.IsConstraint(ref) {
+		sha, err := resolveRef(ctx, client, ref)
+		return sha, "", err
+	}
+
+	tags, err := client.tags(ctx)
+	if err != nil {
+		return "", "", fmt.Errorf("error listing tags: %w", err)
+	}
+
+	tag, err := semver.Latest(ref, tagNames(tags), options["prerelease"] == "true")
+	if err != nil {
+		return "", "", err
+	}
+
+	return tags[tag], tag, nil
+}
+
+func tagNames(tags map[string]string) []string {
+	names := make([]string, 0, len(tags))
+	for name := range tags {
+		names = append(names, name)
+	}
+
+	return names
+}
+
+func toCommitInfo(com *commit) *copier.SourceInfo {
+	return &copier.SourceInfo{
+		Reference: com.ID,
+		Message:   com.Message,
+		Author: copier.Author{
+			Date:  com.CommittedDate,
+			Name:  com.CommitterName,
+			Email: com.CommitterEmail,
+		},
+	}
+}
+
+// commit is a commit as returned by the GitLab API.
+type commit struct {
+	ID             string    `json:"id"`
+	Message        string    `json:"message"`
+	CommitterName  string    `json:"committer_name"`
+	CommitterEmail string    `json:"committer_email"`
+	CommittedDate  time.Time `json:"committed_date"`
+}
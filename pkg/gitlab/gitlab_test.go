@@ -0,0 +1,470 @@
+package gitlab
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/audiotool/pasta/pkg/copier"
+)
+
+const (
+	mainSha = "1111111111111111111111111111111111111111"
+	tagSha  = "2222222222222222222222222222222222222222"
+	oldSha  = "0000000000000000000000000000000000000000"
+)
+
+// fakeGitlab serves the parts of the GitLab v4 API the copier uses, for the project
+// group/sub/project, whose tree consists of files at every commit. Tree pages contain
+// two entries, to exercise pagination. Files whose content starts with "-> " are symlinks to
+// the rest of it, files ending in .sh are executable.
+func fakeGitlab(t *testing.T, files map[string]string) *httptest.Server {
+	t.Helper()
+
+	prefix := "/api/v4/projects/group%2Fsub%2Fproject"
+
+	var paths []string
+	for p := range files {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+
+	mode := func(p string) string {
+		switch {
+		case strings.HasPrefix(files[p], "-> "):
+			return copier.ModeSymlink
+		case strings.HasSuffix(p, ".sh"):
+			return copier.ModeExecutable
+		default:
+			return "100644"
+		}
+	}
+
+	// blobs are identified by the sha of their content
+	var tree []map[string]string
+	for _, p := range paths {
+		id := copier.BlobSHA([]byte(strings.TrimPrefix(files[p], "-> ")))
+		tree = append(tree, map[string]string{"id": id, "type": "blob", "path": p, "mode": mode(p)})
+	}
+	tree = append(tree, map[string]string{"id": "tree0", "type": "tree", "path": "docs"})
+
+	commits := map[string]map[string]any{
+		mainSha: {"id": mainSha, "message": "on main", "committer_name": "Jane", "committer_email": "jane@corp.example", "committed_date": "2023-01-02T03:04:05Z"},
+		tagSha:  {"id": tagSha, "message": "tagged", "committer_name": "John", "committer_email": "john@corp.example", "committed_date": "2022-01-02T03:04:05Z"},
+	}
+
+	// the history is linear, shas not in it are on a diverged branch
+	history := []string{oldSha, tagSha, mainSha}
+
+	index := func(sha string) int {
+		for i, h := range history {
+			if h == sha {
+				return i
+			}
+		}
+		return -1
+	}
+
+	writeJSON := func(w http.ResponseWriter, v any) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(v)
+	}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("PRIVATE-TOKEN") != "secret" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		p, ok := strings.CutPrefix(r.URL.EscapedPath(), prefix)
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+
+		switch {
+		case p == "":
+			writeJSON(w, map[string]any{"default_branch": "main"})
+		case p == "/repository/branches/main":
+			writeJSON(w, map[string]any{"name": "main", "commit": commits[mainSha]})
+		case p == "/repository/tags":
+			writeJSON(w, []map[string]any{
+				{"name": "v0.9.0", "commit": map[string]string{"id": oldSha}},
+				{"name": "v1.0.0", "commit": commits[tagSha]},
+				{"name": "v2.0.0-rc.1", "commit": commits[mainSha]},
+			})
+		case p == "/repository/compare":
+			// the commits reachable from to, but not from from
+			from, to := index(r.URL.Query().Get("from")), index(r.URL.Query().Get("to"))
+			cmp := []map[string]any{}
+
+			switch {
+			case to < 0:
+				cmp = append(cmp, map[string]any{"id": r.URL.Query().Get("to")})
+			case from < to:
+				for _, sha := range history[from+1 : to+1] {
+					cmp = append(cmp, commits[sha])
+				}
+			}
+
+			writeJSON(w, map[string]any{"commits": cmp})
+		case p == "/repository/commits":
+			if q := r.URL.Query(); q.Get("ref_name") != tagSha+".."+mainSha || q.Get("path") != "docs" {
+				t.Errorf("commits requested with ref_name %q and path %q", q.Get("ref_name"), q.Get("path"))
+			}
+			writeJSON(w, []map[string]any{
+				{"id": mainSha, "message": "on main", "author_name": "Jane", "web_url": "https://gitlab.corp.example/c/" + mainSha},
+			})
+		case p == "/repository/tags/v1.0.0":
+			writeJSON(w, map[string]any{"name": "v1.0.0", "commit": commits[tagSha]})
+		case strings.HasPrefix(p, "/repository/commits/"):
+			com, ok := commits[strings.TrimPrefix(p, "/repository/commits/")]
+			if !ok {
+				http.NotFound(w, r)
+				return
+			}
+			writeJSON(w, com)
+		case p == "/repository/tree":
+			if r.URL.Query().Get("recursive") != "true" {
+				t.Errorf("tree requested without recursive=true")
+			}
+
+			page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+			start, end := (page-1)*2, page*2
+			if end >= len(tree) {
+				end = len(tree)
+			} else {
+				w.Header().Set("X-Next-Page", strconv.Itoa(page+1))
+			}
+			writeJSON(w, tree[start:end])
+		case strings.HasPrefix(p, "/repository/blobs/"):
+			id := strings.TrimSuffix(strings.TrimPrefix(p, "/repository/blobs/"), "/raw")
+			for _, entry := range tree {
+				if entry["id"] == id {
+					w.Write([]byte(strings.TrimPrefix(files[entry["path"]], "-> ")))
+					return
+				}
+			}
+			http.NotFound(w, r)
+		case p == "/repository/archive.tar.gz":
+			var buf bytes.Buffer
+			gz := gzip.NewWriter(&buf)
+			tw := tar.NewWriter(gz)
+
+			for _, p := range paths {
+				name := "project-" + r.URL.Query().Get("sha") + "/" + p
+
+				switch mode(p) {
+				case copier.ModeSymlink:
+					tw.WriteHeader(&tar.Header{Name: name, Mode: 0o777, Linkname: strings.TrimPrefix(files[p], "-> "), Typeflag: tar.TypeSymlink})
+				case copier.ModeExecutable:
+					tw.WriteHeader(&tar.Header{Name: name, Mode: 0o755, Size: int64(len(files[p])), Typeflag: tar.TypeReg})
+					tw.Write([]byte(files[p]))
+				default:
+					tw.WriteHeader(&tar.Header{Name: name, Mode: 0o644, Size: int64(len(files[p])), Typeflag: tar.TypeReg})
+					tw.Write([]byte(files[p]))
+				}
+			}
+
+			tw.Close()
+			gz.Close()
+			w.Write(buf.Bytes())
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+
+	t.Cleanup(srv.Close)
+
+	return srv
+}
+
+func TestCopy(t *testing.T) {
+	t.Setenv("GITLAB_TOKEN", "secret")
+
+	files := map[string]string{
+		"docs/a.md":     "a",
+		"docs/b/c.md":   "c",
+		"docs/b/d.md":   "d",
+		"docs/e.txt":    "e",
+		"other/f.md":    "f",
+		"docs/b/g/h.md": "h",
+	}
+
+	srv := fakeGitlab(t, files)
+
+	tests := []struct {
+		name       string
+		ref        string
+		prerelease bool
+		download   string
+		sha        string
+		tag        string
+	}{
+		{name: "default branch", download: "blobs", sha: mainSha},
+		{name: "branch", ref: "heads/main", download: "blobs", sha: mainSha},
+		{name: "tag", ref: "tags/v1.0.0", download: "blobs", sha: tagSha},
+		{name: "implicit tag", ref: "v1.0.0", download: "archive", sha: tagSha},
+		{name: "sha", ref: tagSha, download: "archive", sha: tagSha},
+		{name: "constraint", ref: "^1", download: "blobs", sha: tagSha, tag: "v1.0.0"},
+		{name: "constraint with prerelease", ref: ">=1", prerelease: true, download: "blobs", sha: mainSha, tag: "v2.0.0-rc.1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &Copier{APIURL: srv.URL + "/api/v4"}
+
+			url := srv.URL + "/group/sub/project"
+			if !c.Matches(url) {
+				t.Fatalf("copier doesn't match %v", url)
+			}
+
+			tmp := t.TempDir()
+
+			res, err := c.Copy(context.Background(), copier.CopyConfig{
+				URL:     url,
+				From:    "docs/",
+				Keep:    func(p string) bool { return strings.HasSuffix(p, ".md") },
+				Options: map[string]string{"ref": tt.ref, "download": tt.download, "prerelease": fmt.Sprint(tt.prerelease)},
+				TempDir: tmp,
+			})
+
+			if err != nil {
+				t.Fatalf("copy failed: %v", err)
+			}
+
+			if ref := res.(*copier.SourceInfo).Reference; ref != tt.sha {
+				t.Errorf("reference is %v, expected %v", ref, tt.sha)
+			}
+
+			if tag := res.(*copier.SourceInfo).Tag; tag != tt.tag {
+				t.Errorf("tag is %v, expected %v", tag, tt.tag)
+			}
+
+			var got []string
+			filepath.WalkDir(tmp, func(p string, d os.DirEntry, err error) error {
+				if err == nil && !d.IsDir() {
+					rel, _ := filepath.Rel(tmp, p)
+					got = append(got, filepath.ToSlash(rel))
+				}
+				return err
+			})
+
+			want := []string{"a.md", "b/c.md", "b/d.md", "b/g/h.md"}
+			if strings.Join(got, ",") != strings.Join(want, ",") {
+				t.Errorf("copied %v, expected %v", got, want)
+			}
+
+			bs, err := os.ReadFile(filepath.Join(tmp, "b/g/h.md"))
+			if err != nil || string(bs) != "h" {
+				t.Errorf("b/g/h.md contains %q (%v), expected %q", bs, err, "h")
+			}
+		})
+	}
+}
+
+func TestCopySymlinks(t *testing.T) {
+	t.Setenv("GITLAB_TOKEN", "secret")
+
+	srv := fakeGitlab(t, map[string]string{
+		"docs/a.md":     "a",
+		"docs/run.sh":   "#!/bin/sh",
+		"docs/link.md":  "-> a.md",
+		"docs/chain.md": "-> link.md",
+		"docs/up.md":    "-> ../c.md",
+		"c.md":          "c",
+	})
+
+	c := &Copier{APIURL: srv.URL + "/api/v4"}
+
+	tests := []struct {
+		symlinks string
+		// want maps the copied files to their content, or the target of symlinks prefixed with "-> "
+		want map[string]string
+	}{
+		{symlinks: copier.SymlinksPreserve, want: map[string]string{"a.md": "a", "run.sh": "#!/bin/sh", "link.md": "-> a.md", "chain.md": "-> link.md"}},
+		{symlinks: copier.SymlinksFollow, want: map[string]string{"a.md": "a", "run.sh": "#!/bin/sh", "link.md": "a", "chain.md": "a", "up.md": "c"}},
+		{symlinks: copier.SymlinksSkip, want: map[string]string{"a.md": "a", "run.sh": "#!/bin/sh"}},
+	}
+
+	for _, tt := range tests {
+		for _, download := range []string{"blobs", "archive"} {
+			t.Run(tt.symlinks+" "+download, func(t *testing.T) {
+				tmp := t.TempDir()
+
+				_, err := c.Copy(context.Background(), copier.CopyConfig{
+					URL:  srv.URL + "/group/sub/project",
+					From: "docs/",
+					// up.md leads outside of from, it can only be followed
+					Keep:     func(p string) bool { return p != "up.md" || tt.symlinks == copier.SymlinksFollow },
+					Options:  map[string]string{"ref": mainSha, "download": download},
+					TempDir:  tmp,
+					Symlinks: tt.symlinks,
+				})
+
+				if err != nil {
+					t.Fatalf("copy failed: %v", err)
+				}
+
+				got := make(map[string]string)
+				entries, _ := os.ReadDir(tmp)
+				for _, e := range entries {
+					p := filepath.Join(tmp, e.Name())
+
+					if target, err := os.Readlink(p); err == nil {
+						got[e.Name()] = "-> " + target
+						continue
+					}
+
+					bs, _ := os.ReadFile(p)
+					got[e.Name()] = string(bs)
+
+					info, _ := os.Stat(p)
+					if strings.HasSuffix(p, ".sh") != (info.Mode()&0o100 != 0) {
+						t.Errorf("%v has mode %v", e.Name(), info.Mode())
+					}
+				}
+
+				if !reflect.DeepEqual(got, tt.want) {
+					t.Errorf("Copy() = %v, expected %v", got, tt.want)
+				}
+			})
+		}
+	}
+}
+
+func TestResolve(t *testing.T) {
+	t.Setenv("GITLAB_TOKEN", "secret")
+
+	srv := fakeGitlab(t, map[string]string{"a.md": "a"})
+	c := &Copier{APIURL: srv.URL + "/api/v4"}
+
+	tests := []struct {
+		name   string
+		locked string
+		behind int
+	}{
+		{name: "ancestor", locked: oldSha, behind: 1},
+		{name: "current", locked: tagSha, behind: 0},
+		// e.g. after a force push
+		{name: "diverged", locked: "3333333333333333333333333333333333333333", behind: -1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res, err := c.Resolve(context.Background(), copier.CopyConfig{
+				URL:     srv.URL + "/group/sub/project",
+				Options: map[string]string{"ref": "tags/v1.0.0"},
+				Locked:  tt.locked,
+			})
+
+			if err != nil {
+				t.Fatalf("resolve failed: %v", err)
+			}
+
+			want := copier.Resolution{Reference: tagSha, Latest: "v1.0.0", Behind: tt.behind}
+			if *res != want {
+				t.Errorf("Resolve() = %+v, expected %+v", *res, want)
+			}
+		})
+	}
+}
+
+func TestCommits(t *testing.T) {
+	t.Setenv("GITLAB_TOKEN", "secret")
+
+	srv := fakeGitlab(t, map[string]string{"docs/a.md": "a"})
+	c := &Copier{APIURL: srv.URL + "/api/v4"}
+
+	commits, err := c.Commits(context.Background(), copier.CopyConfig{
+		URL:  srv.URL + "/group/sub/project",
+		From: "docs/",
+	}, tagSha, mainSha)
+
+	if err != nil {
+		t.Fatalf("Commits() error = %v", err)
+	}
+
+	if len(commits) != 1 || commits[0].Reference != mainSha || commits[0].Author.Name != "Jane" || commits[0].URL == "" {
+		t.Errorf("Commits() = %+v, expected the commit on main", commits)
+	}
+}
+
+func TestCopyUnresolvableRef(t *testing.T) {
+	t.Setenv("GITLAB_TOKEN", "secret")
+
+	srv := fakeGitlab(t, map[string]string{"a.md": "a"})
+	c := &Copier{APIURL: srv.URL + "/api/v4"}
+
+	_, err := c.Copy(context.Background(), copier.CopyConfig{
+		URL:     srv.URL + "/group/sub/project",
+		Keep:    func(string) bool { return true },
+		Options: map[string]string{"ref": "nope"},
+		TempDir: t.TempDir(),
+	})
+
+	if err == nil || !strings.Contains(err.Error(), errCouldNotResolveRef.Error()) {
+		t.Errorf("expected %v, got %v", errCouldNotResolveRef, err)
+	}
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		url     string
+		project string
+		err     error
+	}{
+		{url: "gitlab.com/group/project", project: "group/project"},
+		{url: "https://gitlab.com/group/project/", project: "group/project"},
+		{url: "https://gitlab.com/group/sub/project.git", project: "group/sub/project"},
+		{url: "https://gitlab.corp.example/a/b/c/project/-/tree/main/docs", project: "a/b/c/project"},
+		{url: "https://gitlab.com/project", err: errMalformedURL},
+		{url: "https://gitlab.com/group/pro ject", err: errMalformedURL},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.url, func(t *testing.T) {
+			project, err := parse(tt.url)
+			if err != tt.err {
+				t.Fatalf("parse() error = %v, expected %v", err, tt.err)
+			}
+
+			if project != tt.project {
+				t.Errorf("parse() = %v, expected %v", project, tt.project)
+			}
+		})
+	}
+}
+
+func TestMatches(t *testing.T) {
+	c := &Copier{Hosts: []string{"gitlab.corp.example"}}
+
+	for url, want := range map[string]bool{
+		"https://gitlab.com/group/project":          true,
+		"https://www.gitlab.com/group/project":      true,
+		"https://gitlab.corp.example/group/project": true,
+		"gitlab.corp.example/group/project":         true,
+		"https://github.com/owner/repo":             false,
+		"https://gitlab.com.evil.example/group/p":   false,
+	} {
+		if got := c.Matches(url); got != want {
+			t.Errorf("Matches(%v) is %v, expected %v", url, got, want)
+		}
+	}
+
+	if (&Copier{}).Matches("https://gitlab.corp.example/group/project") {
+		t.Errorf("copier without hosts matches self-hosted url")
+	}
+}
@@ -0,0 +1,66 @@
+package gitlab
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/audiotool/pasta/pkg/copier"
+)
+
+// Commits returns the commits after from, up to and including to, that touch files below From.
+func (c *Copier) Commits(ctx context.Context, config copier.CopyConfig, from, to string) ([]copier.Commit, error) {
+	project, err := parse(config.URL)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't parse url %v, error: %v", config.URL, err)
+	}
+
+	client := newClient(c.apiURL(config), project)
+
+	var commits []copier.Commit
+
+	for page := "1"; page != ""; {
+		query := url.Values{
+			"ref_name": {from + ".." + to},
+			"per_page": {fmt.Sprint(pageSize)},
+			"page":     {page},
+		}
+
+		if config.From != "" {
+			query.Set("path", strings.TrimSuffix(config.From, "/"))
+		}
+
+		var batch []struct {
+			ID           string    `json:"id"`
+			Message      string    `json:"message"`
+			AuthorName   string    `json:"author_name"`
+			AuthorEmail  string    `json:"author_email"`
+			AuthoredDate time.Time `json:"authored_date"`
+			WebURL       string    `json:"web_url"`
+		}
+
+		resp, err := client.getJSON(ctx, "/repository/commits", query, &batch)
+		if err != nil {
+			return nil, fmt.Errorf("error listing commits: %w", err)
+		}
+
+		for _, com := range batch {
+			commits = append(commits, copier.Commit{
+				Reference: com.ID,
+				Message:   com.Message,
+				Author: copier.Author{
+					Date:  com.AuthoredDate,
+					Name:  com.AuthorName,
+					Email: com.AuthorEmail,
+				},
+				URL: com.WebURL,
+			})
+		}
+
+		page = resp.Header.Get("X-Next-Page")
+	}
+
+	return commits, nil
+}
@@ -0,0 +1,247 @@
+package hosted
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/audiotool/pasta/pkg/cache"
+	"github.com/audiotool/pasta/pkg/copier"
+	"github.com/audiotool/pasta/pkg/utils"
+	"github.com/sourcegraph/conc/pool"
+)
+
+// file is a blob that should be copied, with its path relative to From.
+type file struct {
+	blob Blob
+	relp string
+}
+
+// strategies for downloading files, chosen with the option "download"
+const (
+	// pick archiveStrategy or blobsStrategy depending on the number of files
+	autoStrategy = "auto"
+	// download the archive of the whole repository, and extract the files from it
+	archiveStrategy = "archive"
+	// download every file with a separate API call
+	blobsStrategy = "blobs"
+)
+
+// archiveThreshold is the number of files from which on the archive is downloaded in auto mode,
+// instead of downloading each file with a separate API call.
+const archiveThreshold = 20
+
+// chooseStrategy returns the download strategy to use for the given option and number of files.
+func chooseStrategy(option string, files int) (string, error) {
+	switch option {
+	case "", autoStrategy:
+		if files >= archiveThreshold {
+			return archiveStrategy, nil
+		}
+
+		return blobsStrategy, nil
+	case archiveStrategy, blobsStrategy:
+		return option, nil
+	default:
+		return "", fmt.Errorf("invalid option download: %q, must be one of %v, %v or %v", option, autoStrategy, archiveStrategy, blobsStrategy)
+	}
+}
+
+// restoreBlobs copies the files found in the cache to dir, and returns the ones that aren't.
+func restoreBlobs(c *cache.Cache, names Cache, files []file, dir string) []file {
+	var missing []file
+	root := utils.Root{Dir: dir}
+
+	for _, f := range files {
+		bs, ok := getBlob(c, names, f.blob.SHA)
+		if !ok || root.SaveFile(bs, f.relp, copier.Perm(f.blob.Mode)) != nil {
+			missing = append(missing, f)
+		}
+	}
+
+	return missing
+}
+
+// getBlob returns the content of the blob sha from the cache. Corrupted entries, whose
+// content doesn't hash to sha, count as missing.
+func getBlob(c *cache.Cache, names Cache, sha string) ([]byte, bool) {
+	bs, ok := c.Get(names.Name, names.Repo, "blob-"+sha)
+	if !ok || copier.BlobSHA(bs) != sha {
+		return nil, false
+	}
+
+	return bs, true
+}
+
+// paths returns the paths of files in the repository.
+func paths(files []file) []string {
+	ps := make([]string, len(files))
+	for i, f := range files {
+		ps[i] = f.blob.Path
+	}
+
+	return ps
+}
+
+// storeBlobs stores the downloaded files in dir in the cache.
+func storeBlobs(c *cache.Cache, names Cache, files []file, dir string) {
+	for _, f := range files {
+		// the cache only saves requests, failing to fill it is no error
+		_ = c.PutFile(names.Name, names.Repo, "blob-"+f.blob.SHA, path.Join(dir, f.relp))
+	}
+}
+
+// downloadBlobs downloads every file with a separate API call, and saves it to dir.
+func downloadBlobs(ctx context.Context, repo Repo, files []file, dir string) error {
+	// download concurrently, errors of all files are collected
+	wg := pool.New().WithErrors().WithMaxGoroutines(20)
+	root := utils.Root{Dir: dir}
+
+	for _, f := range files {
+		f := f
+
+		wg.Go(func() error {
+			bs, err := repo.Blob(ctx, f.blob.SHA)
+			if err != nil {
+				return fmt.Errorf("error fetching file %v: %w", f.blob.Path, err)
+			}
+
+			err = root.SaveFile(bs, f.relp, copier.Perm(f.blob.Mode))
+
+			if err != nil {
+				return fmt.Errorf("error saving file %v: %w", f.blob.Path, err)
+			}
+
+			return nil
+		})
+	}
+
+	return wg.Wait()
+}
+
+// downloadArchive downloads the tarball of the repository at sha, and extracts the files to dir.
+func downloadArchive(ctx context.Context, repo Repo, sha string, files []file, dir string) error {
+	// files followed from symlinks can share their path with others
+	wanted := make(map[string][]string, len(files))
+	for _, f := range files {
+		wanted[f.blob.Path] = append(wanted[f.blob.Path], f.relp)
+	}
+
+	root := utils.Root{Dir: dir}
+	var copies [][]string
+
+	archive, err := repo.Archive(ctx, sha)
+	if err != nil {
+		return fmt.Errorf("error downloading archive: %w", err)
+	}
+
+	defer archive.Close()
+
+	err = utils.ExtractTarGz(archive, root, func(name, link string) string {
+		// all files are inside a single top level directory
+		_, p, _ := strings.Cut(name, "/")
+
+		relps, ok := wanted[p]
+		if !ok || link != "" {
+			return ""
+		}
+
+		delete(wanted, p)
+		copies = append(copies, relps)
+		return relps[0]
+	})
+
+	if err != nil {
+		return fmt.Errorf("error extracting archive: %w", err)
+	}
+
+	if len(wanted) > 0 {
+		missing := make([]string, 0, len(wanted))
+		for p := range wanted {
+			missing = append(missing, p)
+		}
+		sort.Strings(missing)
+
+		return fmt.Errorf("archive is missing files: %v", strings.Join(missing, ", "))
+	}
+
+	for _, relps := range copies {
+		for _, relp := range relps[1:] {
+			if err := root.CopyFile(path.Join(dir, relps[0]), relp); err != nil {
+				return fmt.Errorf("error copying %v: %w", relps[0], err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// resolveLinks handles the symlinks among files as the option symlinks says. It returns the
+// files to download, which include the files followed symlinks point to, and the symlinks
+// to recreate.
+func resolveLinks(files []file, blobs []Blob, option string, readLink func(blob Blob) (string, error)) ([]file, []copier.Symlink, error) {
+	var kept []file
+	var links []copier.Symlink
+
+	byPath := make(map[string]Blob, len(blobs))
+	modes := make(map[string]string, len(blobs))
+	for _, blob := range blobs {
+		byPath[blob.Path] = blob
+		modes[blob.Path] = blob.Mode
+	}
+
+	for _, f := range files {
+		if f.blob.Mode != copier.ModeSymlink {
+			kept = append(kept, f)
+			continue
+		}
+
+		switch option {
+		case copier.SymlinksSkip:
+		case copier.SymlinksFollow:
+			p, err := copier.FollowSymlink(f.blob.Path, modes, func(p string) (string, error) {
+				return readLink(byPath[p])
+			})
+
+			if err != nil {
+				return nil, nil, err
+			}
+
+			kept = append(kept, file{blob: byPath[p], relp: f.relp})
+		default:
+			target, err := readLink(f.blob)
+			if err != nil {
+				return nil, nil, err
+			}
+
+			links = append(links, copier.Symlink{Relp: f.relp, Target: target})
+		}
+	}
+
+	return kept, links, nil
+}
+
+// readLink returns a function reading the target of a symlink, from the cache if possible.
+func readLink(ctx context.Context, repo Repo, config copier.CopyConfig, names Cache) func(blob Blob) (string, error) {
+	return func(blob Blob) (string, error) {
+		if bs, ok := getBlob(config.Cache, names, blob.SHA); ok {
+			return string(bs), nil
+		}
+
+		if config.Offline {
+			return "", fmt.Errorf("%w: symlink %v isn't cached", copier.ErrOffline, blob.Path)
+		}
+
+		bs, err := repo.Blob(ctx, blob.SHA)
+		if err != nil {
+			return "", fmt.Errorf("error fetching symlink %v: %w", blob.Path, err)
+		}
+
+		// the cache only saves requests, failing to fill it is no error
+		_ = config.Cache.Put(names.Name, names.Repo, "blob-"+blob.SHA, bs)
+
+		return string(bs), nil
+	}
+}
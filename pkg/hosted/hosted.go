@@ -0,0 +1,167 @@
+// Package hosted copies files from repositories on hosting services like GitHub or GitLab,
+// whose APIs serve the trees, blobs and archives of commits. The copiers of the services
+// implement Repo, and share how files are selected, downloaded and cached.
+package hosted
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/audiotool/pasta/pkg/copier"
+)
+
+// Blob is a file in the tree of a commit.
+type Blob struct {
+	Path string `json:"path"`
+	// SHA is the git object id of the content
+	SHA string `json:"sha"`
+	// Mode is the git file mode, e.g. "100755" for executables
+	Mode string `json:"mode"`
+}
+
+// Repo is a repository served by the API of a hosting service.
+type Repo interface {
+	// Tree returns the blobs of the tree of commit sha, including those in subtrees.
+	Tree(ctx context.Context, sha string) ([]Blob, error)
+	// Commit returns the info about commit sha written to pasta.result.yaml.
+	Commit(ctx context.Context, sha string) (*copier.SourceInfo, error)
+	// Blob returns the content of the blob sha.
+	Blob(ctx context.Context, sha string) ([]byte, error)
+	// Archive returns the tarball of the repository at commit sha, with all files inside a
+	// single top level directory.
+	Archive(ctx context.Context, sha string) (io.ReadCloser, error)
+}
+
+// Cache names the entries of a repository in copier.CopyConfig.Cache.
+type Cache struct {
+	// Name is the name of the copier the entries belong to, e.g. "github"
+	Name string
+	// Repo identifies the repository, e.g. "github.com/owner/repo"
+	Repo string
+}
+
+// Copy copies the files of the dependency at commit sha of repo to config.TempDir, and returns
+// the info about the commit. Files and listings found in the cache aren't downloaded again.
+func Copy(ctx context.Context, repo Repo, config copier.CopyConfig, sha string, c Cache) (*copier.SourceInfo, error) {
+	m, err := getManifest(ctx, repo, sha, config, c)
+	if err != nil {
+		return nil, err
+	}
+
+	// collect files to copy, by their path relative to From
+	var files []file
+	for _, blob := range m.Blobs {
+		if !strings.HasPrefix(blob.Path, config.From) {
+			continue
+		}
+
+		relp, err := filepath.Rel(config.From, blob.Path)
+		if err != nil {
+			return nil, fmt.Errorf("unable to create relp: %v", err)
+		}
+
+		// check if user wants to keep this file based on relp
+		if !config.Keep(relp) {
+			continue
+		}
+
+		files = append(files, file{blob: blob, relp: relp})
+	}
+
+	files, links, err := resolveLinks(files, m.Blobs, config.Symlinks, readLink(ctx, repo, config, c))
+	if err != nil {
+		return nil, err
+	}
+
+	// files found in the cache aren't downloaded again
+	missing := restoreBlobs(config.Cache, c, files, config.TempDir)
+
+	if config.Offline && len(missing) > 0 {
+		return nil, fmt.Errorf("%w: files of commit %v aren't cached: %v", copier.ErrOffline, sha, strings.Join(paths(missing), ", "))
+	}
+
+	strategy, err := chooseStrategy(config.Options["download"], len(missing))
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case len(missing) == 0:
+	case strategy == archiveStrategy:
+		err = downloadArchive(ctx, repo, sha, missing, config.TempDir)
+	default:
+		err = downloadBlobs(ctx, repo, missing, config.TempDir)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("error downloading files: %w", err)
+	}
+
+	storeBlobs(config.Cache, c, missing, config.TempDir)
+
+	if err := copier.CreateSymlinks(links, config.TempDir); err != nil {
+		return nil, err
+	}
+
+	info := m.Info
+	return &info, nil
+}
+
+// manifestKey is the prefix of the cache keys of manifests. It changes with the format of
+// manifests, older ones lack the modes of files, or store blobs in the formats of the APIs.
+const manifestKey = "manifest-v3-"
+
+// manifest lists the blobs of the tree of a commit, and the info about the commit written
+// to pasta.result.yaml. Both never change, so manifests are cached by the sha of the commit.
+type manifest struct {
+	Blobs []Blob            `json:"blobs"`
+	Info  copier.SourceInfo `json:"info"`
+}
+
+// getManifest returns the manifest of commit sha, from the cache if possible.
+func getManifest(ctx context.Context, repo Repo, sha string, config copier.CopyConfig, c Cache) (*manifest, error) {
+	var m manifest
+	if config.Cache.GetJSON(c.Name, c.Repo, manifestKey+sha, &m) {
+		return &m, nil
+	}
+
+	if config.Offline {
+		return nil, fmt.Errorf("%w: file listing of commit %v isn't cached", copier.ErrOffline, sha)
+	}
+
+	blobs, err := repo.Tree(ctx, sha)
+	if err != nil {
+		return nil, fmt.Errorf("error getting tree: %v", err)
+	}
+
+	m.Blobs = blobs
+
+	// create info message for pasta.result.yaml: Fetch commit
+	info, err := repo.Commit(ctx, sha)
+	if err != nil {
+		return nil, fmt.Errorf("error getting commit info: %v", err)
+	}
+
+	m.Info = *info
+
+	// the cache only saves requests, failing to fill it is no error
+	_ = config.Cache.PutJSON(c.Name, c.Repo, manifestKey+m.Info.Reference, &m)
+
+	return &m, nil
+}
+
+var hostRegexp = regexp.MustCompile(`^(?:[a-zA-Z][a-zA-Z0-9+.\-]*://)?([^/?#]+)`)
+
+// Host returns the host of url, which may be given without scheme.
+func Host(url string) string {
+	ms := hostRegexp.FindStringSubmatch(url)
+	if ms == nil {
+		return ""
+	}
+
+	return strings.ToLower(strings.TrimPrefix(ms[1], "www."))
+}
@@ -0,0 +1,70 @@
+package hosted
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/audiotool/pasta/pkg/cache"
+	"github.com/audiotool/pasta/pkg/copier"
+)
+
+func TestChooseStrategy(t *testing.T) {
+	tests := []struct {
+		option   string
+		files    int
+		strategy string
+		wantErr  bool
+	}{
+		{option: "", files: 1, strategy: blobsStrategy},
+		{option: "", files: archiveThreshold, strategy: archiveStrategy},
+		{option: "auto", files: archiveThreshold - 1, strategy: blobsStrategy},
+		{option: "archive", files: 1, strategy: archiveStrategy},
+		{option: "blobs", files: 1000, strategy: blobsStrategy},
+		{option: "zipball", files: 1, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		strategy, err := chooseStrategy(tt.option, tt.files)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("chooseStrategy(%q, %v) error = %v, wantErr %v", tt.option, tt.files, err, tt.wantErr)
+			continue
+		}
+
+		if strategy != tt.strategy {
+			t.Errorf("chooseStrategy(%q, %v) = %v, expected %v", tt.option, tt.files, strategy, tt.strategy)
+		}
+	}
+}
+
+func TestRestoreBlobs(t *testing.T) {
+	c := &cache.Cache{Dir: t.TempDir()}
+	names := Cache{Name: "github", Repo: "github.com/o/r"}
+	dir := t.TempDir()
+
+	intact := Blob{Path: "docs/a.md", SHA: copier.BlobSHA([]byte("a")), Mode: "100644"}
+	corrupted := Blob{Path: "docs/b.md", SHA: copier.BlobSHA([]byte("b")), Mode: "100644"}
+	uncached := Blob{Path: "docs/c.md", SHA: copier.BlobSHA([]byte("c")), Mode: "100644"}
+
+	for sha, content := range map[string]string{intact.SHA: "a", corrupted.SHA: "truncat"} {
+		if err := c.Put(names.Name, names.Repo, "blob-"+sha, []byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	files := []file{{blob: intact, relp: "a.md"}, {blob: corrupted, relp: "b.md"}, {blob: uncached, relp: "c.md"}}
+	missing := restoreBlobs(c, names, files, dir)
+
+	if got, want := paths(missing), []string{"docs/b.md", "docs/c.md"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("restoreBlobs() missing = %v, expected %v", got, want)
+	}
+
+	if bs, err := os.ReadFile(filepath.Join(dir, "a.md")); err != nil || string(bs) != "a" {
+		t.Errorf("a.md = %q, %v, expected it restored", bs, err)
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "b.md")); !os.IsNotExist(err) {
+		t.Errorf("corrupted b.md was restored: %v", err)
+	}
+}
@@ -0,0 +1,251 @@
+package local
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/audiotool/pasta/pkg/copier"
+	"github.com/audiotool/pasta/pkg/utils"
+)
+
+// Copier copies files from a directory on the local file system, e.g. a sibling checkout.
+type Copier struct{}
+
+// SourceInfo is written to pasta.result.yaml for local dependencies.
+//
+// It has no reference on purpose: a local directory can't be reproduced at an earlier
+// state, so local dependencies are never locked and always copied as they are.
+type SourceInfo struct {
+	// Path is the path of the directory as given in the url, relative to the directory
+	// containing pasta.yaml unless it is absolute, so it doesn't depend on the checkout
+	Path string `yaml:"path"`
+	// Commit is the sha of HEAD, if the directory is inside a git work tree
+	Commit string `yaml:"commit,omitempty"`
+	// Dirty is true if the copied directory has uncommitted changes
+	Dirty bool `yaml:"dirty,omitempty"`
+	// Digest is the hash of all copied files, if the directory is not inside a git work tree
+	Digest string `yaml:"digest,omitempty"`
+}
+
+var localRegexp = regexp.MustCompile(`^(file://|/|\./|\.\./|\.$|\.\.$)`)
+
+func (*Copier) Matches(url string) bool {
+	return localRegexp.MatchString(url)
+}
+
+func (*Copier) Copy(ctx context.Context, config copier.CopyConfig) (any, error) {
+	dir := localPath(config.URL, config.Root)
+
+	info, err := os.Stat(dir)
+	if err != nil {
+		return nil, fmt.Errorf("can't access %v: %v", dir, err)
+	}
+
+	if !info.IsDir() {
+		return nil, fmt.Errorf("%v is not a directory", dir)
+	}
+
+	src := filepath.Join(dir, filepath.FromSlash(config.From))
+
+	files, err := copyDir(dir, src, config)
+	if err != nil {
+		return nil, err
+	}
+
+	res := &SourceInfo{Path: recordedPath(config.URL, config.Root, dir)}
+
+	if commit, dirty, ok := gitState(ctx, src); ok {
+		res.Commit = commit
+		res.Dirty = dirty
+		return res, nil
+	}
+
+	res.Digest, err = digest(config.TempDir, files)
+	if err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+// recordedPath returns the path of dir, which url points to, as written to pasta.result.yaml:
+// absolute if url is, and relative to root otherwise.
+func recordedPath(url, root, dir string) string {
+	if filepath.IsAbs(filepath.FromSlash(strings.TrimPrefix(url, "file://"))) {
+		return filepath.ToSlash(dir)
+	}
+
+	rel, err := filepath.Rel(root, dir)
+	if err != nil {
+		return filepath.ToSlash(dir)
+	}
+
+	return filepath.ToSlash(rel)
+}
+
+// localPath returns the absolute path url points to. Relative paths are relative to root.
+func localPath(url, root string) string {
+	p := filepath.FromSlash(strings.TrimPrefix(url, "file://"))
+
+	if !filepath.IsAbs(p) {
+		p = filepath.Join(root, p)
+	}
+
+	return filepath.Clean(p)
+}
+
+// copyDir copies all files in src that should be kept to the temp directory, and returns
+// their paths relative to src. Git directories are skipped. Symlinks are handled as the
+// option symlinks says, followed ones must point to files inside of dir.
+func copyDir(dir, src string, config copier.CopyConfig) ([]string, error) {
+	var files []string
+	root := utils.Root{Dir: config.TempDir}
+
+	err := filepath.WalkDir(src, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if d.IsDir() {
+			if d.Name() == ".git" {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		link := d.Type()&fs.ModeSymlink != 0
+		if !d.Type().IsRegular() && !link || link && config.Symlinks == copier.SymlinksSkip {
+			return nil
+		}
+
+		rel, err := filepath.Rel(src, p)
+		if err != nil {
+			return fmt.Errorf("unable to create relp: %v", err)
+		}
+
+		relp := filepath.ToSlash(rel)
+
+		// check if user wants to keep this file based on relp
+		if !config.Keep(relp) {
+			return nil
+		}
+
+		switch {
+		case !link:
+			err = root.CopyFile(p, relp)
+		case config.Symlinks == copier.SymlinksFollow:
+			err = followSymlink(dir, p, relp, root)
+		default:
+			var target string
+			if target, err = os.Readlink(p); err == nil {
+				err = root.Symlink(target, relp)
+			}
+		}
+
+		if err != nil {
+			return fmt.Errorf("error copying %v: %w", relp, err)
+		}
+
+		files = append(files, relp)
+		return nil
+	})
+
+	if err != nil {
+		return nil, fmt.Errorf("error copying directory %v: %v", src, err)
+	}
+
+	return files, nil
+}
+
+// followSymlink copies the file the symlink p points to to relp inside of root. The file must be
+// inside of dir.
+func followSymlink(dir, p, relp string, root utils.Root) error {
+	resolved, err := filepath.EvalSymlinks(p)
+	if err != nil {
+		return fmt.Errorf("error following symlink: %w", err)
+	}
+
+	realDir, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return fmt.Errorf("error resolving %v: %w", dir, err)
+	}
+
+	if rel, err := filepath.Rel(realDir, resolved); err != nil || !filepath.IsLocal(rel) {
+		return fmt.Errorf("symlink points to %v, outside of %v", resolved, dir)
+	}
+
+	info, err := os.Stat(resolved)
+	if err != nil {
+		return err
+	}
+
+	if !info.Mode().IsRegular() {
+		return fmt.Errorf("symlink points to %v, which isn't a file", resolved)
+	}
+
+	return root.CopyFile(resolved, relp)
+}
+
+// gitState returns the sha of HEAD and whether there are uncommitted changes in dir,
+// if dir is inside a git work tree.
+func gitState(ctx context.Context, dir string) (commit string, dirty bool, ok bool) {
+	inside, err := git(ctx, dir, "rev-parse", "--is-inside-work-tree")
+	if err != nil || inside != "true" {
+		return "", false, false
+	}
+
+	commit, err = git(ctx, dir, "rev-parse", "HEAD")
+	if err != nil {
+		return "", false, false
+	}
+
+	// only changes in the copied directory matter
+	status, err := git(ctx, dir, "status", "--porcelain", "--", ".")
+	if err != nil {
+		return "", false, false
+	}
+
+	return commit, status != "", true
+}
+
+func git(ctx context.Context, dir string, args ...string) (string, error) {
+	cmd := exec.CommandContext(ctx, "git", append([]string{"-C", dir}, args...)...)
+
+	var stdout bytes.Buffer
+	cmd.Stdout = &stdout
+
+	if err := cmd.Run(); err != nil {
+		return "", err
+	}
+
+	return strings.TrimSpace(stdout.String()), nil
+}
+
+// digest returns a hash over the paths and contents of files in dir.
+func digest(dir string, files []string) (string, error) {
+	sort.Strings(files)
+
+	h := sha256.New()
+
+	for _, f := range files {
+		hash, err := utils.HashFile(path.Join(dir, f))
+		if err != nil {
+			return "", fmt.Errorf("error hashing %v: %v", f, err)
+		}
+
+		fmt.Fprintf(h, "%v %v\n", hash, f)
+	}
+
+	return "sha256:" + hex.EncodeToString(h.Sum(nil)), nil
+}
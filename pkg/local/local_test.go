@@ -0,0 +1,273 @@
+package local
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/audiotool/pasta/pkg/copier"
+)
+
+func writeTree(t *testing.T, root string, files map[string]string) {
+	for p, content := range files {
+		p = path.Join(root, p)
+
+		if err := os.MkdirAll(path.Dir(p), os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := os.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func readTree(t *testing.T, dir string) map[string]string {
+	files := make(map[string]string)
+
+	err := filepath.WalkDir(dir, func(p string, d os.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+
+		content, err := os.ReadFile(p)
+		if err != nil {
+			return err
+		}
+
+		rel, _ := filepath.Rel(dir, p)
+		files[filepath.ToSlash(rel)] = string(content)
+		return nil
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return files
+}
+
+func runGit(t *testing.T, dir string, args ...string) string {
+	cmd := exec.Command("git", append([]string{"-c", "user.name=Pasta Test", "-c", "user.email=test@example.com"}, args...)...)
+	cmd.Dir = dir
+
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("git %v: %v: %s", args, err, out)
+	}
+
+	return strings.TrimSpace(string(out))
+}
+
+func TestMatches(t *testing.T) {
+	tests := []struct {
+		url     string
+		matches bool
+	}{
+		{"file:///srv/shared", true},
+		{"/srv/shared", true},
+		{"./shared", true},
+		{"../shared-protos", true},
+		{"..", true},
+		{"https://github.com/foo/bar", false},
+		{"git@example.com:foo/bar", false},
+		{"shared", false},
+	}
+
+	for _, tt := range tests {
+		if got := (&Copier{}).Matches(tt.url); got != tt.matches {
+			t.Errorf("Matches(%v) = %v, expected %v", tt.url, got, tt.matches)
+		}
+	}
+}
+
+func copyConfig(t *testing.T, url, root string) copier.CopyConfig {
+	return copier.CopyConfig{
+		URL:     url,
+		From:    "proto/",
+		Keep:    func(p string) bool { return strings.HasSuffix(p, ".proto") },
+		TempDir: t.TempDir(),
+		Root:    root,
+	}
+}
+
+func TestCopyDirectory(t *testing.T) {
+	dir := t.TempDir()
+	writeTree(t, dir, map[string]string{
+		"shared/README.md":         "readme",
+		"shared/proto/a.proto":     "a",
+		"shared/proto/sub/b.proto": "b",
+		"shared/proto/notes.md":    "notes",
+	})
+
+	root := path.Join(dir, "repo")
+	config := copyConfig(t, "../shared", root)
+
+	info, err := (&Copier{}).Copy(context.Background(), config)
+	if err != nil {
+		t.Fatalf("Copy() error = %v", err)
+	}
+
+	want := map[string]string{"a.proto": "a", "sub/b.proto": "b"}
+	if files := readTree(t, config.TempDir); !reflect.DeepEqual(files, want) {
+		t.Errorf("Copy() files = %v, expected %v", files, want)
+	}
+
+	si := info.(*SourceInfo)
+	if si.Path != "../shared" || si.Commit != "" || !strings.HasPrefix(si.Digest, "sha256:") {
+		t.Errorf("Copy() source info = %+v", si)
+	}
+
+	// the digest only changes with the copied content
+	writeTree(t, dir, map[string]string{"shared/proto/notes.md": "changed"})
+
+	info, err = (&Copier{}).Copy(context.Background(), copyConfig(t, "file://"+path.Join(dir, "shared"), root))
+	if err != nil {
+		t.Fatalf("Copy() error = %v", err)
+	}
+
+	if digest := info.(*SourceInfo).Digest; digest != si.Digest {
+		t.Errorf("digest changed from %v to %v", si.Digest, digest)
+	}
+
+	// absolute paths are recorded as they are
+	if p := info.(*SourceInfo).Path; p != path.Join(dir, "shared") {
+		t.Errorf("Copy() path = %v, expected %v", p, path.Join(dir, "shared"))
+	}
+
+	writeTree(t, dir, map[string]string{"shared/proto/a.proto": "changed"})
+
+	info, err = (&Copier{}).Copy(context.Background(), copyConfig(t, "../shared", root))
+	if err != nil {
+		t.Fatalf("Copy() error = %v", err)
+	}
+
+	if digest := info.(*SourceInfo).Digest; digest == si.Digest {
+		t.Errorf("digest didn't change with content")
+	}
+}
+
+func TestCopyGitWorkTree(t *testing.T) {
+	dir := t.TempDir()
+	writeTree(t, dir, map[string]string{
+		"proto/a.proto": "a",
+		"other.txt":     "other",
+	})
+
+	runGit(t, dir, "init", "-q")
+	runGit(t, dir, "add", "-A")
+	runGit(t, dir, "commit", "-q", "-m", "first")
+	head := runGit(t, dir, "rev-parse", "HEAD")
+
+	paste := func() *SourceInfo {
+		info, err := (&Copier{}).Copy(context.Background(), copyConfig(t, dir, "/"))
+		if err != nil {
+			t.Fatalf("Copy() error = %v", err)
+		}
+		return info.(*SourceInfo)
+	}
+
+	if si := paste(); si.Commit != head || si.Dirty || si.Digest != "" {
+		t.Errorf("clean work tree: source info = %+v", si)
+	}
+
+	// changes outside of the copied directory don't matter
+	writeTree(t, dir, map[string]string{"other.txt": "changed"})
+
+	if si := paste(); si.Dirty {
+		t.Errorf("change outside of from: source info = %+v", si)
+	}
+
+	writeTree(t, dir, map[string]string{"proto/a.proto": "changed"})
+
+	if si := paste(); si.Commit != head || !si.Dirty {
+		t.Errorf("dirty work tree: source info = %+v", si)
+	}
+}
+
+func TestCopyMissingDirectory(t *testing.T) {
+	if _, err := (&Copier{}).Copy(context.Background(), copyConfig(t, "./missing", t.TempDir())); err == nil {
+		t.Errorf("expected error")
+	}
+}
+
+func TestCopySymlinks(t *testing.T) {
+	dir := t.TempDir()
+	writeTree(t, dir, map[string]string{
+		"shared/proto/a.proto": "a",
+		"shared/c.proto":       "c",
+		"outside.proto":        "outside",
+	})
+
+	if err := os.Chmod(path.Join(dir, "shared/proto/a.proto"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	for p, target := range map[string]string{"link.proto": "a.proto", "up.proto": "../c.proto", "escape.proto": "../../outside.proto"} {
+		if err := os.Symlink(target, path.Join(dir, "shared/proto", p)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		symlinks string
+		keep     []string
+		// want maps the copied files to their content, or the target of symlinks prefixed with "-> "
+		want    map[string]string
+		wantErr bool
+	}{
+		{symlinks: "", keep: []string{"a.proto", "link.proto"}, want: map[string]string{"a.proto": "a", "link.proto": "-> a.proto"}},
+		{symlinks: copier.SymlinksPreserve, keep: []string{"up.proto"}, wantErr: true},
+		{symlinks: copier.SymlinksFollow, keep: []string{"link.proto", "up.proto"}, want: map[string]string{"link.proto": "a", "up.proto": "c"}},
+		{symlinks: copier.SymlinksFollow, keep: []string{"escape.proto"}, wantErr: true},
+		{symlinks: copier.SymlinksSkip, keep: []string{"a.proto", "link.proto", "escape.proto"}, want: map[string]string{"a.proto": "a"}},
+	}
+
+	for _, tt := range tests {
+		config := copyConfig(t, "./shared", dir)
+		config.Symlinks = tt.symlinks
+		config.Keep = func(p string) bool {
+			for _, k := range tt.keep {
+				if k == p {
+					return true
+				}
+			}
+			return false
+		}
+
+		_, err := (&Copier{}).Copy(context.Background(), config)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("symlinks %q, keep %v: Copy() error = %v, expected error = %v", tt.symlinks, tt.keep, err, tt.wantErr)
+			continue
+		}
+
+		if tt.wantErr {
+			continue
+		}
+
+		files := make(map[string]string)
+		for p := range readTree(t, config.TempDir) {
+			if target, err := os.Readlink(path.Join(config.TempDir, p)); err == nil {
+				files[p] = "-> " + target
+				continue
+			}
+
+			content, _ := os.ReadFile(path.Join(config.TempDir, p))
+			files[p] = string(content)
+		}
+
+		if !reflect.DeepEqual(files, tt.want) {
+			t.Errorf("symlinks %q, keep %v: Copy() files = %v, expected %v", tt.symlinks, tt.keep, files, tt.want)
+		}
+
+		// the executable bit is kept
+		if info, err := os.Stat(path.Join(config.TempDir, "a.proto")); err == nil && info.Mode()&0o100 == 0 {
+			t.Errorf("symlinks %q: a.proto isn't executable anymore", tt.symlinks)
+		}
+	}
+}
@@ -0,0 +1,179 @@
+package pasta
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// transaction applies changes to the working tree, such that all of them can be undone.
+//
+// New content is always staged next to its destination first, and then moved in place
+// with a rename. Replaced files and directories are kept as backups until the transaction
+// is committed, or restored on rollback.
+type transaction struct {
+	// undo operations, executed in reverse order on rollback
+	undo []func() error
+	// backups removed on commit
+	backups []string
+}
+
+// mkdirAll creates dir and its parents. Directories it creates are removed on rollback.
+func (tx *transaction) mkdirAll(dir string) error {
+	// find the topmost directory that doesn't exist yet
+	missing := ""
+	for d := dir; ; d = filepath.Dir(d) {
+		if _, err := os.Lstat(d); err == nil {
+			break
+		}
+
+		missing = d
+
+		if filepath.Dir(d) == d {
+			break
+		}
+	}
+
+	if missing == "" {
+		return nil
+	}
+
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return fmt.Errorf("error creating directory %v: %w", dir, err)
+	}
+
+	tx.undo = append(tx.undo, func() error { return os.RemoveAll(missing) })
+
+	return nil
+}
+
+// reserveName returns an unused path in the directory of p, starting with p's name and kind.
+func reserveName(p, kind string) (string, error) {
+	f, err := os.CreateTemp(filepath.Dir(p), "."+filepath.Base(p)+".pasta-"+kind+"-*")
+	if err != nil {
+		return "", err
+	}
+
+	name := f.Name()
+	f.Close()
+
+	return name, os.Remove(name)
+}
+
+// backup moves p out of the way if it exists, so it can be restored on rollback.
+func (tx *transaction) backup(p string) error {
+	if _, err := os.Lstat(p); errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+
+	backup, err := reserveName(p, "old")
+	if err != nil {
+		return fmt.Errorf("error creating backup of %v: %w", p, err)
+	}
+
+	if err := os.Rename(p, backup); err != nil {
+		return fmt.Errorf("error creating backup of %v: %w", p, err)
+	}
+
+	tx.undo = append(tx.undo, func() error { return os.Rename(backup, p) })
+	tx.backups = append(tx.backups, backup)
+
+	return nil
+}
+
+// replaceDir replaces the directory dir with a new one, filled by fill.
+func (tx *transaction) replaceDir(dir string, fill func(stage string) error) error {
+	if err := tx.mkdirAll(filepath.Dir(dir)); err != nil {
+		return err
+	}
+
+	stage, err := os.MkdirTemp(filepath.Dir(dir), "."+filepath.Base(dir)+".pasta-new-*")
+	if err != nil {
+		return fmt.Errorf("error creating staging directory for %v: %w", dir, err)
+	}
+
+	tx.undo = append(tx.undo, func() error { return os.RemoveAll(stage) })
+
+	if err := fill(stage); err != nil {
+		return err
+	}
+
+	if err := tx.backup(dir); err != nil {
+		return err
+	}
+
+	if err := os.Rename(stage, dir); err != nil {
+		return fmt.Errorf("error moving staged directory to %v: %w", dir, err)
+	}
+
+	tx.undo = append(tx.undo, func() error { return os.RemoveAll(dir) })
+
+	return nil
+}
+
+// put creates or replaces the file dst with a new one, written by fill.
+func (tx *transaction) put(dst string, fill func(stage string) error) error {
+	if info, err := os.Lstat(dst); err == nil && info.IsDir() {
+		return fmt.Errorf("can't write file %v, it is a directory", dst)
+	}
+
+	if err := tx.mkdirAll(filepath.Dir(dst)); err != nil {
+		return err
+	}
+
+	stage, err := reserveName(dst, "new")
+	if err != nil {
+		return fmt.Errorf("error staging %v: %w", dst, err)
+	}
+
+	tx.undo = append(tx.undo, func() error { return os.RemoveAll(stage) })
+
+	if err := fill(stage); err != nil {
+		return err
+	}
+
+	if err := tx.backup(dst); err != nil {
+		return err
+	}
+
+	if err := os.Rename(stage, dst); err != nil {
+		return fmt.Errorf("error moving staged file to %v: %w", dst, err)
+	}
+
+	tx.undo = append(tx.undo, func() error { return os.Remove(dst) })
+
+	return nil
+}
+
+// rollback undoes all changes of the transaction.
+func (tx *transaction) rollback() error {
+	var errs []error
+
+	for i := len(tx.undo) - 1; i >= 0; i-- {
+		if err := tx.undo[i](); err != nil && !errors.Is(err, os.ErrNotExist) {
+			errs = append(errs, err)
+		}
+	}
+
+	tx.undo = nil
+	tx.backups = nil
+
+	return errors.Join(errs...)
+}
+
+// commit makes all changes of the transaction permanent, by removing the backups.
+func (tx *transaction) commit() error {
+	var errs []error
+
+	for _, backup := range tx.backups {
+		if err := os.RemoveAll(backup); err != nil {
+			errs = append(errs, fmt.Errorf("error removing backup %v: %w", backup, err))
+		}
+	}
+
+	tx.undo = nil
+	tx.backups = nil
+
+	return errors.Join(errs...)
+}
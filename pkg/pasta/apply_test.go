@@ -0,0 +1,220 @@
+package pasta
+
+import (
+	"context"
+	"os"
+	"path"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/audiotool/pasta/pkg/copier"
+)
+
+// listTree returns all files below root with their content, and fails on leftover staging files.
+func listTree(t *testing.T, root string) map[string]string {
+	files, err := findFiles(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tree := make(map[string]string, len(files))
+	for _, f := range files {
+		if strings.Contains(f, ".pasta-") {
+			t.Errorf("leftover staging file %v", f)
+		}
+
+		tree[f] = readFile(t, path.Join(root, f))
+	}
+
+	return tree
+}
+
+func writeTree(t *testing.T, root string, files map[string]string) {
+	for p, content := range files {
+		p = path.Join(root, p)
+
+		if err := os.MkdirAll(path.Dir(p), os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := os.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestTransactionRollback(t *testing.T) {
+	root := t.TempDir()
+	before := map[string]string{
+		"dir/old.txt":    "old",
+		"dir/keep.txt":   "keep",
+		"file.txt":       "file",
+		"other/file.txt": "other",
+	}
+	writeTree(t, root, before)
+
+	tx := &transaction{}
+
+	err := tx.replaceDir(path.Join(root, "dir"), func(stage string) error {
+		return os.WriteFile(path.Join(stage, "new.txt"), []byte("new"), 0644)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, p := range []string{"file.txt", "new/deep/file.txt"} {
+		err := tx.put(path.Join(root, p), func(stage string) error {
+			return os.WriteFile(stage, []byte("new"), 0644)
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if got := readFile(t, path.Join(root, "dir", "new.txt")); got != "new" {
+		t.Errorf("dir/new.txt = %q before rollback", got)
+	}
+
+	if err := tx.rollback(); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := listTree(t, root); !reflect.DeepEqual(got, before) {
+		t.Errorf("after rollback tree = %v, expected %v", got, before)
+	}
+
+	if _, err := os.Stat(path.Join(root, "new")); !os.IsNotExist(err) {
+		t.Errorf("directory created by transaction wasn't removed")
+	}
+}
+
+func TestTransactionCommit(t *testing.T) {
+	root := t.TempDir()
+	writeTree(t, root, map[string]string{"dir/old.txt": "old", "file.txt": "file"})
+
+	tx := &transaction{}
+
+	err := tx.replaceDir(path.Join(root, "dir"), func(stage string) error {
+		return os.WriteFile(path.Join(stage, "new.txt"), []byte("new"), 0644)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = tx.put(path.Join(root, "file.txt"), func(stage string) error {
+		return os.WriteFile(stage, []byte("new"), 0644)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := tx.commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]string{"dir/new.txt": "new", "file.txt": "new"}
+	if got := listTree(t, root); !reflect.DeepEqual(got, want) {
+		t.Errorf("after commit tree = %v, expected %v", got, want)
+	}
+}
+
+func TestRunRollsBackOnError(t *testing.T) {
+	root := t.TempDir()
+	pastaFile := path.Join(root, "pasta.yaml")
+
+	before := map[string]string{
+		"out/old.txt": "old",
+		// blocks creating the target directory of the second dependency
+		"blocker": "not a directory",
+	}
+	writeTree(t, root, before)
+
+	reg := registryOf(&fakeCopier{
+		head:  "sha1",
+		files: map[string]map[string]string{"sha1": {"a.txt": "a"}},
+	})
+
+	deps := append(fakeDeps(t, root), fakeDeps(t, root)...)
+	// directories are replaced outer first, so out/ is already replaced when this fails
+	deps[1].Target = path.Join(root, "blocker", "sub")
+
+	if _, err := Run(context.Background(), Options{Registry: reg, Deps: deps, PastaFile: pastaFile}); err == nil {
+		t.Fatalf("expected error")
+	}
+
+	if got := listTree(t, root); !reflect.DeepEqual(got, before) {
+		t.Errorf("after failed run tree = %v, expected %v", got, before)
+	}
+}
+
+func TestRunSharedTarget(t *testing.T) {
+	root := t.TempDir()
+	pastaFile := path.Join(root, "pasta.yaml")
+	writeTree(t, root, map[string]string{"out/old.txt": "old"})
+
+	reg := registryOf(&fakeCopier{
+		head:  "sha1",
+		files: map[string]map[string]string{"sha1": {"a.txt": "a"}},
+	}, &otherCopier{})
+
+	deps := append(fakeDeps(t, root), fakeDeps(t, root)...)
+	deps[1].Option.URL = "fake://other"
+
+	if _, err := Run(context.Background(), Options{Registry: reg, Deps: deps, PastaFile: pastaFile}); err != nil {
+		t.Fatal(err)
+	}
+
+	files, err := findFiles(path.Join(root, "out"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(files)
+
+	if want := []string{"a.txt", "b.txt"}; !reflect.DeepEqual(files, want) {
+		t.Errorf("out/ = %v, expected %v", files, want)
+	}
+}
+
+func TestRunNestedTarget(t *testing.T) {
+	root := t.TempDir()
+	pastaFile := path.Join(root, "pasta.yaml")
+	writeTree(t, root, map[string]string{"out/old.txt": "old"})
+
+	reg := registryOf(&fakeCopier{
+		head:  "sha1",
+		files: map[string]map[string]string{"sha1": {"a.txt": "a"}},
+	}, &otherCopier{})
+
+	// the second dependency writes single files into the cleared target of the first
+	deps := append(fakeDeps(t, root), fakeDeps(t, root)...)
+	deps[1].Option.URL = "fake://other"
+	deps[1].Option.ClearTarget = false
+	deps[1].Target = path.Join(root, "out", "sub")
+
+	if _, err := Run(context.Background(), Options{Registry: reg, Deps: deps, PastaFile: pastaFile}); err != nil {
+		t.Fatal(err)
+	}
+
+	files, err := findFiles(path.Join(root, "out"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(files)
+
+	if want := []string{"a.txt", "sub/b.txt"}; !reflect.DeepEqual(files, want) {
+		t.Errorf("out/ = %v, expected %v", files, want)
+	}
+}
+
+// otherCopier always copies b.txt
+type otherCopier struct{}
+
+func (*otherCopier) Matches(url string) bool {
+	return url == "fake://other"
+}
+
+func (*otherCopier) Copy(ctx context.Context, config copier.CopyConfig) (any, error) {
+	return &copier.SourceInfo{Reference: "other"}, os.WriteFile(path.Join(config.TempDir, "b.txt"), []byte("b"), 0644)
+}
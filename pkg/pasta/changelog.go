@@ -0,0 +1,170 @@
+package pasta
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/audiotool/pasta/pkg/copier"
+)
+
+// errCantListCommits is reported for dependencies whose copier can't list commits.
+var errCantListCommits = errors.New("copier can't list commits")
+
+// change is a dependency that was copied at another reference than in the previous run.
+type change struct {
+	dep Dependency
+	// target relative to the directory containing pasta.yaml
+	target string
+	old    sourceFields
+	new    sourceFields
+	// commits between old and new touching the dependency's From path, newest first
+	commits []copier.Commit
+	err     error
+}
+
+// findChanges returns the dependencies that were copied at another reference than the
+// one recorded in prev. Dependencies are matched by url, from and to, so that changing
+// the ref of a dependency is a change as well.
+func findChanges(deps []Dependency, results []CopyResult, prev *pastaResults, root string) []change {
+	recorded := make(map[string]*yamlResult)
+
+	for i := range prev.Deps {
+		r := &prev.Deps[i]
+		k := strings.Join([]string{r.URL, r.From, r.To}, "\x00")
+
+		if _, ok := recorded[k]; !ok {
+			recorded[k] = r
+		}
+	}
+
+	var changes []change
+
+	for i, dep := range deps {
+		if results[i].Err != nil {
+			continue
+		}
+
+		res := resultFor(dep, root)
+
+		r, ok := recorded[strings.Join([]string{res.URL, res.From, res.To}, "\x00")]
+		if !ok {
+			continue
+		}
+
+		old, new := decodeSourceInfo(r.SourceInfo), decodeSourceInfo(results[i].CopierInfo)
+		if old.Reference == "" || new.Reference == "" || old.Reference == new.Reference {
+			continue
+		}
+
+		changes = append(changes, change{dep: dep, target: res.To, old: old, new: new})
+	}
+
+	return changes
+}
+
+// listCommits lists the commits of every change, with the copier of its dependency.
+func listCommits(ctx context.Context, reg *Registry, changes []change) {
+	var wg sync.WaitGroup
+
+	for i := range changes {
+		ch := &changes[i]
+
+		c, err := findCopier(reg, ch.dep)
+		if err != nil {
+			ch.err = err
+			continue
+		}
+
+		lister, ok := c.(copier.CommitLister)
+		if !ok {
+			ch.err = errCantListCommits
+			continue
+		}
+
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+			ch.commits, ch.err = lister.Commits(ctx, ch.dep.Option, ch.old.Reference, ch.new.Reference)
+		}()
+	}
+
+	wg.Wait()
+}
+
+// writeChangelog writes a Markdown summary of changes to w, to be pasted e.g. into the
+// description of a pull request.
+func writeChangelog(w io.Writer, changes []change) error {
+	var b strings.Builder
+
+	b.WriteString("## Dependency updates\n\n")
+
+	if len(changes) == 0 {
+		b.WriteString("No dependency was copied at another reference.\n")
+	}
+
+	for _, ch := range changes {
+		fmt.Fprintf(&b, "### `%v` from %v\n\n", ch.target, ch.dep.Option.URL)
+		fmt.Fprintf(&b, "%v → %v", formatVersion(ch.old), formatVersion(ch.new))
+
+		touching := ""
+		if ch.dep.Option.From != "" {
+			touching = fmt.Sprintf(" touching `%v`", ch.dep.Option.From)
+		}
+
+		switch {
+		case ch.err != nil:
+			fmt.Fprintf(&b, "\n\nCommits couldn't be listed: %v\n\n", ch.err)
+			continue
+		case len(ch.commits) == 0:
+			fmt.Fprintf(&b, ", no commits%v.\n\n", touching)
+			continue
+		case len(ch.commits) == 1:
+			fmt.Fprintf(&b, ", 1 commit%v:\n\n", touching)
+		default:
+			fmt.Fprintf(&b, ", %v commits%v:\n\n", len(ch.commits), touching)
+		}
+
+		for _, com := range ch.commits {
+			sha := fmt.Sprintf("`%v`", shortRef(com.Reference))
+			if com.URL != "" {
+				sha = fmt.Sprintf("[%v](%v)", sha, com.URL)
+			}
+
+			subject, _, _ := strings.Cut(strings.TrimSpace(com.Message), "\n")
+
+			fmt.Fprintf(&b, "- %v %v", sha, subject)
+			if com.Author.Name != "" {
+				fmt.Fprintf(&b, " (%v)", com.Author.Name)
+			}
+			b.WriteString("\n")
+		}
+
+		b.WriteString("\n")
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// formatVersion formats a reference for humans, with its tag if it has one.
+func formatVersion(info sourceFields) string {
+	if info.Tag != "" {
+		return fmt.Sprintf("`%v` (`%v`)", info.Tag, shortRef(info.Reference))
+	}
+
+	return fmt.Sprintf("`%v`", shortRef(info.Reference))
+}
+
+// shortRef abbreviates commit shas.
+func shortRef(ref string) string {
+	if len(ref) == 40 || len(ref) == 64 {
+		return ref[:7]
+	}
+
+	return ref
+}
@@ -0,0 +1,157 @@
+package pasta
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/audiotool/pasta/pkg/copier"
+)
+
+// listingCopier is a resolvingCopier that can list the commits of its history.
+type listingCopier struct {
+	resolvingCopier
+}
+
+func (c *listingCopier) Commits(ctx context.Context, config copier.CopyConfig, from, to string) ([]copier.Commit, error) {
+	var commits []copier.Commit
+	in := false
+
+	for _, ref := range c.history {
+		if in {
+			commits = append([]copier.Commit{{Reference: ref, Message: "release " + ref + "\n\nbody", Author: copier.Author{Name: "Jane"}}}, commits...)
+		}
+
+		in = in || ref == from
+
+		if ref == to {
+			break
+		}
+	}
+
+	return commits, nil
+}
+
+func TestRunChangelog(t *testing.T) {
+	root := t.TempDir()
+	pastaFile := path.Join(root, "pasta.yaml")
+
+	c := &listingCopier{resolvingCopier{
+		fakeCopier: fakeCopier{
+			head: "v1",
+			files: map[string]map[string]string{
+				"v1": {"a.txt": "a1"},
+				"v3": {"a.txt": "a3"},
+			},
+		},
+		history: []string{"v1", "v2", "v3"},
+	}}
+
+	reg := registryOf(c)
+
+	var changelog bytes.Buffer
+
+	if _, err := Run(context.Background(), Options{Registry: reg, Deps: fakeDeps(t, root), PastaFile: pastaFile, Changelog: &changelog}); err != nil {
+		t.Fatal(err)
+	}
+
+	want := "## Dependency updates\n\nNo dependency was copied at another reference.\n"
+	if changelog.String() != want {
+		t.Errorf("changelog of first run = %q, expected %q", changelog.String(), want)
+	}
+
+	c.head = "v3"
+	changelog.Reset()
+
+	deps := fakeDeps(t, root)
+	deps[0].Update = true
+
+	if _, err := Run(context.Background(), Options{Registry: reg, Deps: deps, PastaFile: pastaFile, Changelog: &changelog}); err != nil {
+		t.Fatal(err)
+	}
+
+	want = "## Dependency updates\n\n" +
+		"### `out` from fake://repo\n\n" +
+		"`v1` → `v3`, 2 commits:\n\n" +
+		"- `v3` release v3 (Jane)\n" +
+		"- `v2` release v2 (Jane)\n\n"
+
+	if changelog.String() != want {
+		t.Errorf("changelog = %q, expected %q", changelog.String(), want)
+	}
+}
+
+func TestRunChangelogUnsupported(t *testing.T) {
+	root := t.TempDir()
+	pastaFile := path.Join(root, "pasta.yaml")
+
+	c := &fakeCopier{
+		head: "v1",
+		files: map[string]map[string]string{
+			"v1": {"a.txt": "a1"},
+			"v2": {"a.txt": "a2"},
+		},
+	}
+
+	reg := registryOf(c)
+
+	if _, err := Run(context.Background(), Options{Registry: reg, Deps: fakeDeps(t, root), PastaFile: pastaFile}); err != nil {
+		t.Fatal(err)
+	}
+
+	c.head = "v2"
+
+	deps := fakeDeps(t, root)
+	deps[0].Update = true
+
+	var changelog bytes.Buffer
+	if _, err := Run(context.Background(), Options{Registry: reg, Deps: deps, PastaFile: pastaFile, Changelog: &changelog}); err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Contains(changelog.Bytes(), []byte(errCantListCommits.Error())) {
+		t.Errorf("changelog = %q, expected it to mention %v", changelog.String(), errCantListCommits)
+	}
+}
+
+// failingWriter fails every write.
+type failingWriter struct{}
+
+func (failingWriter) Write(p []byte) (int, error) {
+	return 0, errors.New("disk full")
+}
+
+func TestRunChangelogFailing(t *testing.T) {
+	root := t.TempDir()
+	pastaFile := path.Join(root, "pasta.yaml")
+
+	reg := registryOf(&fakeCopier{head: "v1", files: map[string]map[string]string{"v1": {"a.txt": "a1"}}})
+
+	report, err := Run(context.Background(), Options{Registry: reg, Deps: fakeDeps(t, root), PastaFile: pastaFile, Changelog: failingWriter{}})
+	if err == nil {
+		t.Fatal("Run() succeeded with a failing changelog")
+	}
+
+	// the files were copied nonetheless, which the report tells
+	if report == nil || len(report.Deps) != 1 || len(report.Deps[0].Files) != 1 {
+		t.Fatalf("Run() report = %+v, expected the copied dependency", report)
+	}
+
+	if got := readFile(t, path.Join(root, "out/a.txt")); got != "a1" {
+		t.Errorf("a.txt = %q, expected a1", got)
+	}
+
+	// temp dirs are removed nonetheless, also by dry runs
+	deps := fakeDeps(t, root)
+
+	if _, err := Run(context.Background(), Options{Registry: reg, Deps: deps, PastaFile: pastaFile, DryRun: true, Changelog: failingWriter{}}); err == nil {
+		t.Fatal("dry Run() succeeded with a failing changelog")
+	}
+
+	if _, err := os.Stat(deps[0].Option.TempDir); !os.IsNotExist(err) {
+		t.Errorf("temp dir wasn't removed: %v", err)
+	}
+}
@@ -0,0 +1,168 @@
+package pasta
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+
+	"github.com/audiotool/pasta/pkg/utils"
+)
+
+// Drift describes how the files of a dependency in the working tree differ from the
+// files copied from its source. All paths are relative to the directory containing pasta.yaml.
+type Drift struct {
+	URL    string
+	Target string
+	// Added lists files in the target directory that the dependency doesn't produce.
+	// Only reported for dependencies whose target directory is cleared.
+	Added []string
+	// Removed lists files the dependency produces, but that are missing in the working tree.
+	Removed []string
+	// Modified lists files whose content differs from the source.
+	Modified []string
+	// Skipped is true if the dependency couldn't be copied when pasta last ran, so there
+	// are no files to compare the working tree with.
+	Skipped bool
+}
+
+// Clean returns true if the working tree matches the source.
+func (d *Drift) Clean() bool {
+	return !d.Skipped && len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Modified) == 0
+}
+
+// Check copies every dependency with the copiers of reg at the reference recorded in
+// pasta.result.yaml into its temp directory, and compares the copied files with the
+// working tree. Dependencies without a reference, like local ones, are compared with the
+// hashes of the files recorded for them instead. Dependencies recorded as skipped are
+// reported as skipped, without comparing any files.
+func Check(ctx context.Context, reg *Registry, deps []Dependency, keepDirs bool, pastaFilePath string) (drifts []Drift, err error) {
+	root := filepath.Dir(pastaFilePath)
+
+	defer func() {
+		if clearErr := clearTempDirs(deps); clearErr != nil {
+			err = errors.Join(err, clearErr)
+		}
+	}()
+
+	prev, err := readResult(root)
+	if err != nil {
+		return nil, err
+	}
+
+	records := recordedResults(deps, prev, root)
+	deps, locks := lock(deps, prev, root)
+
+	skipped := make(map[string]bool)
+	for _, r := range prev.Deps {
+		if r.Skipped {
+			skipped[r.key()] = true
+		}
+	}
+
+	// locked dependencies are copied again, the others can't be reproduced and are compared
+	// with the files recorded for them
+	var copied []Dependency
+	var copiedLocks []*yamlResult
+	var indexes []int
+
+	for i, l := range locks {
+		if res := resultFor(deps[i], root); records[i] == nil && skipped[res.key()] {
+			continue
+		}
+
+		if records[i] == nil {
+			return nil, fmt.Errorf("dependency %v has no entry in %v, run pasta first", deps[i].Option.URL, ResultFile)
+		}
+
+		if l != nil {
+			copied = append(copied, deps[i])
+			copiedLocks = append(copiedLocks, l)
+			indexes = append(indexes, i)
+		}
+	}
+
+	copiedResults, err := copyToTemp(ctx, reg, copied)
+	if err != nil {
+		return nil, fmt.Errorf("error copying dependencies: %v", err)
+	}
+
+	if err := hashResults(copied, copiedResults, root); err != nil {
+		return nil, fmt.Errorf("error hashing copied files: %v", err)
+	}
+
+	if err := checkLocks(copied, copiedResults, copiedLocks); err != nil {
+		return nil, err
+	}
+
+	results := make([]CopyResult, len(deps))
+	for i, record := range records {
+		if record != nil {
+			results[i] = CopyResult{Files: record.Files}
+		}
+	}
+
+	for j, i := range indexes {
+		results[i] = copiedResults[j]
+	}
+
+	// files produced by any dependency, so nested targets don't report each other's files as added
+	produced := make(map[string]bool)
+	for _, res := range results {
+		for p := range res.Files {
+			produced[p] = true
+		}
+	}
+
+	for i, dep := range deps {
+		drift := Drift{URL: dep.Option.URL, Target: resultFor(dep, root).To}
+
+		if records[i] == nil {
+			drift.Skipped = true
+			drifts = append(drifts, drift)
+			continue
+		}
+
+		for p, hash := range results[i].Files {
+			actual, err := utils.HashFile(path.Join(root, p))
+
+			if errors.Is(err, os.ErrNotExist) {
+				drift.Removed = append(drift.Removed, p)
+				continue
+			}
+
+			if err != nil {
+				return nil, fmt.Errorf("error hashing %v: %v", p, err)
+			}
+
+			if actual != hash {
+				drift.Modified = append(drift.Modified, p)
+			}
+		}
+
+		if dep.Option.ClearTarget && !keepDirs {
+			files, err := findFiles(dep.Target)
+			if err != nil && !errors.Is(err, os.ErrNotExist) {
+				return nil, fmt.Errorf("error listing files in %v: %v", dep.Target, err)
+			}
+
+			for _, f := range files {
+				p := path.Join(drift.Target, filepath.ToSlash(f))
+				if !produced[p] {
+					drift.Added = append(drift.Added, p)
+				}
+			}
+		}
+
+		sort.Strings(drift.Added)
+		sort.Strings(drift.Removed)
+		sort.Strings(drift.Modified)
+
+		drifts = append(drifts, drift)
+	}
+
+	return drifts, nil
+}
@@ -0,0 +1,167 @@
+package pasta
+
+import (
+	"context"
+	"os"
+	"path"
+	"reflect"
+	"testing"
+
+	"github.com/audiotool/pasta/pkg/copier"
+)
+
+func TestCheck(t *testing.T) {
+	root := t.TempDir()
+	pastaFile := path.Join(root, "pasta.yaml")
+	ctx := context.Background()
+
+	fake := &fakeCopier{
+		head: "sha1",
+		files: map[string]map[string]string{
+			"sha1": {"a.txt": "a", "b.txt": "b", "c.txt": "c"},
+		},
+	}
+	reg := registryOf(fake)
+
+	if _, err := Check(ctx, reg, fakeDeps(t, root), false, pastaFile); err == nil {
+		t.Errorf("expected error checking without %v", ResultFile)
+	}
+
+	if _, err := Run(ctx, Options{Registry: reg, Deps: fakeDeps(t, root), PastaFile: pastaFile}); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+
+	drifts, err := Check(ctx, reg, fakeDeps(t, root), false, pastaFile)
+	if err != nil {
+		t.Fatalf("check: %v", err)
+	}
+
+	if len(drifts) != 1 || !drifts[0].Clean() {
+		t.Fatalf("expected no drift, got %+v", drifts)
+	}
+
+	out := path.Join(root, "out")
+	if err := os.WriteFile(path.Join(out, "a.txt"), []byte("edited"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(path.Join(out, "b.txt")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path.Join(out, "d.txt"), []byte("d"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// upstream moved on, check still compares against the locked reference
+	fake.head = "sha2"
+
+	drifts, err = Check(ctx, reg, fakeDeps(t, root), false, pastaFile)
+	if err != nil {
+		t.Fatalf("check: %v", err)
+	}
+
+	want := Drift{
+		URL:      "fake://repo",
+		Target:   "out",
+		Added:    []string{"out/d.txt"},
+		Removed:  []string{"out/b.txt"},
+		Modified: []string{"out/a.txt"},
+	}
+
+	if len(drifts) != 1 || !reflect.DeepEqual(drifts[0], want) {
+		t.Errorf("check() = %+v, expected %+v", drifts, want)
+	}
+}
+
+// unlockedCopier copies files like local directories, without a reference to lock them to.
+type unlockedCopier struct {
+	files  map[string]string
+	copies int
+}
+
+func (*unlockedCopier) Matches(url string) bool {
+	return url == "fake://repo"
+}
+
+func (c *unlockedCopier) Copy(ctx context.Context, config copier.CopyConfig) (any, error) {
+	c.copies++
+
+	for p, content := range c.files {
+		if err := os.WriteFile(path.Join(config.TempDir, p), []byte(content), 0644); err != nil {
+			return nil, err
+		}
+	}
+
+	return map[string]string{"path": "../sibling"}, nil
+}
+
+func TestCheckUnlocked(t *testing.T) {
+	root := t.TempDir()
+	pastaFile := path.Join(root, "pasta.yaml")
+	ctx := context.Background()
+
+	unlocked := &unlockedCopier{files: map[string]string{"a.txt": "a", "b.txt": "b"}}
+	reg := registryOf(unlocked)
+
+	if _, err := Run(ctx, Options{Registry: reg, Deps: fakeDeps(t, root), PastaFile: pastaFile}); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+
+	drifts, err := Check(ctx, reg, fakeDeps(t, root), false, pastaFile)
+	if err != nil {
+		t.Fatalf("check: %v", err)
+	}
+
+	if len(drifts) != 1 || !drifts[0].Clean() {
+		t.Fatalf("expected no drift, got %+v", drifts)
+	}
+
+	// the source changed, but the working tree is compared with the recorded files
+	unlocked.files["a.txt"] = "changed"
+
+	if err := os.WriteFile(path.Join(root, "out", "b.txt"), []byte("edited"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	drifts, err = Check(ctx, reg, fakeDeps(t, root), false, pastaFile)
+	if err != nil {
+		t.Fatalf("check: %v", err)
+	}
+
+	want := Drift{URL: "fake://repo", Target: "out", Modified: []string{"out/b.txt"}}
+
+	if len(drifts) != 1 || !reflect.DeepEqual(drifts[0], want) {
+		t.Errorf("check() = %+v, expected %+v", drifts, want)
+	}
+
+	if unlocked.copies != 1 {
+		t.Errorf("dependency was copied %v times, expected once by run", unlocked.copies)
+	}
+}
+
+func TestCheckSkipped(t *testing.T) {
+	root := t.TempDir()
+	pastaFile := path.Join(root, "pasta.yaml")
+	ctx := context.Background()
+
+	result := "deps:\n  - url: fake://repo\n    to: out\n    ref: main\n    skipped: true\n    error: 'error during copy: error fetching file b.txt'\n"
+	if err := os.WriteFile(path.Join(root, ResultFile), []byte(result), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	reg := registryOf(&fakeCopier{head: "sha1"})
+
+	drifts, err := Check(ctx, reg, fakeDeps(t, root), false, pastaFile)
+	if err != nil {
+		t.Fatalf("check: %v", err)
+	}
+
+	want := Drift{URL: "fake://repo", Target: "out", Skipped: true}
+
+	if len(drifts) != 1 || !reflect.DeepEqual(drifts[0], want) {
+		t.Errorf("check() = %+v, expected %+v", drifts, want)
+	}
+
+	if drifts[0].Clean() {
+		t.Errorf("skipped dependency is reported as clean")
+	}
+}
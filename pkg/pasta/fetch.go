@@ -0,0 +1,55 @@
+package pasta
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+)
+
+// Fetched is a dependency whose files were stored in the cache by Fetch.
+type Fetched struct {
+	URL    string
+	Target string
+	// Reference is the reference that was fetched
+	Reference string
+	// Locked is false if pasta.result.yaml has no entry for the dependency. Its ref was
+	// resolved to fetch it, but it can't be copied offline before it is locked.
+	Locked bool
+}
+
+// Fetch copies every dependency with the copiers of reg at the reference recorded in
+// pasta.result.yaml into its temp directory, so that its copier stores the files in the
+// cache, without touching the working tree. Afterwards, dependencies can be copied offline.
+func Fetch(ctx context.Context, reg *Registry, deps []Dependency, pastaFilePath string) (fetched []Fetched, err error) {
+	root := filepath.Dir(pastaFilePath)
+
+	defer func() {
+		if clearErr := clearTempDirs(deps); clearErr != nil {
+			err = errors.Join(err, clearErr)
+		}
+	}()
+
+	prev, err := readResult(root)
+	if err != nil {
+		return nil, err
+	}
+
+	deps, locks := lock(deps, prev, root)
+
+	results, err := copyToTemp(ctx, reg, deps)
+	if err != nil {
+		return nil, fmt.Errorf("error copying dependencies: %v", err)
+	}
+
+	for i, dep := range deps {
+		fetched = append(fetched, Fetched{
+			URL:       dep.Option.URL,
+			Target:    resultFor(dep, root).To,
+			Reference: reference(results[i].CopierInfo),
+			Locked:    locks[i] != nil,
+		})
+	}
+
+	return fetched, nil
+}
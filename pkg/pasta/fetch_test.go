@@ -0,0 +1,97 @@
+package pasta
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/audiotool/pasta/pkg/copier"
+)
+
+func TestFetch(t *testing.T) {
+	root := t.TempDir()
+	pastaFile := path.Join(root, "pasta.yaml")
+
+	c := &fakeCopier{
+		head: "v1",
+		files: map[string]map[string]string{
+			"v1": {"a.txt": "a1"},
+			"v2": {"a.txt": "a2"},
+		},
+	}
+
+	reg := registryOf(c)
+
+	if _, err := Run(context.Background(), Options{Registry: reg, Deps: fakeDeps(t, root), PastaFile: pastaFile}); err != nil {
+		t.Fatal(err)
+	}
+
+	c.head = "v2"
+
+	fetched, err := Fetch(context.Background(), reg, fakeDeps(t, root), pastaFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []Fetched{{URL: "fake://repo", Target: "out", Reference: "v1", Locked: true}}
+	if fmt.Sprint(fetched) != fmt.Sprint(want) {
+		t.Errorf("Fetch() = %+v, expected %+v", fetched, want)
+	}
+
+	if got := readFile(t, path.Join(root, "out", "a.txt")); got != "a1" {
+		t.Errorf("working tree was modified: a.txt = %q", got)
+	}
+
+	// dependencies that aren't locked yet are resolved
+	other := t.TempDir()
+
+	fetched, err = Fetch(context.Background(), reg, fakeDeps(t, other), path.Join(other, "pasta.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want = []Fetched{{URL: "fake://repo", Target: "out", Reference: "v2", Locked: false}}
+	if fmt.Sprint(fetched) != fmt.Sprint(want) {
+		t.Errorf("Fetch() = %+v, expected %+v", fetched, want)
+	}
+
+	if entries, _ := os.ReadDir(other); len(entries) != 0 {
+		t.Errorf("Fetch() wrote to the working tree: %v", entries)
+	}
+}
+
+// uncachedCopier fails like copiers do offline, for refs that aren't cached.
+type uncachedCopier struct{}
+
+func (*uncachedCopier) Matches(url string) bool {
+	return url == "fake://uncached"
+}
+
+func (*uncachedCopier) Copy(ctx context.Context, config copier.CopyConfig) (any, error) {
+	return nil, fmt.Errorf("%w: %v isn't cached", copier.ErrOffline, config.Options["ref"])
+}
+
+func TestRunOfflineReportsAllMissing(t *testing.T) {
+	root := t.TempDir()
+	reg := registryOf(&uncachedCopier{})
+
+	var deps []Dependency
+	for _, ref := range []string{"first", "second"} {
+		dep := fakeDeps(t, root)[0]
+		dep.Option.URL = "fake://uncached"
+		dep.Option.Options = map[string]string{"ref": ref}
+		dep.Option.Offline = true
+		dep.Target = path.Join(root, ref)
+
+		deps = append(deps, dep)
+	}
+
+	_, err := Run(context.Background(), Options{Registry: reg, Deps: deps, PastaFile: path.Join(root, "pasta.yaml")})
+
+	if err == nil || !strings.Contains(err.Error(), "first isn't cached") || !strings.Contains(err.Error(), "second isn't cached") {
+		t.Errorf("Run() error = %v, expected both dependencies to be reported", err)
+	}
+}
@@ -0,0 +1,142 @@
+package pasta
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Globs is a list of gitignore-style patterns, matched against slash separated paths
+// relative to `from`.
+type Globs struct {
+	patterns []*regexp.Regexp
+	negated  []bool
+}
+
+// CompileGlobs compiles patterns with gitignore semantics:
+//   - `*` matches anything but `/`, `?` a single character but `/`, `[a-z]` a range of characters
+//   - `**` matches any number of directories, as in `**/testdata/`, `docs/**` or `a/**/b`
+//   - `{png,svg}` matches either of the comma separated alternatives
+//   - patterns without a `/` before their end match at any level, others relative to `from`
+//   - patterns matching a directory match all files below it, patterns ending in `/` only match directories
+//   - patterns starting with `!` negate a previous match, since later patterns override earlier ones
+//   - `\` escapes the following character, e.g. in `\!important.txt`
+func CompileGlobs(patterns []string) (*Globs, error) {
+	g := &Globs{}
+
+	for _, pattern := range patterns {
+		negated := false
+		if strings.HasPrefix(pattern, "!") {
+			negated, pattern = true, pattern[1:]
+		}
+
+		re, err := globRegexp(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid glob %q: %w", pattern, err)
+		}
+
+		g.patterns = append(g.patterns, re)
+		g.negated = append(g.negated, negated)
+	}
+
+	return g, nil
+}
+
+// Match returns true if the last pattern matching p isn't negated, and false if no pattern matches.
+func (g *Globs) Match(p string) bool {
+	for i := len(g.patterns) - 1; i >= 0; i-- {
+		if g.patterns[i].MatchString(p) {
+			return !g.negated[i]
+		}
+	}
+
+	return false
+}
+
+// Empty returns true if g has no patterns.
+func (g *Globs) Empty() bool {
+	return len(g.patterns) == 0
+}
+
+// globRegexp translates a single glob pattern, without negation, to a regexp.
+func globRegexp(pattern string) (*regexp.Regexp, error) {
+	dirOnly := strings.HasSuffix(pattern, "/") && !strings.HasSuffix(pattern, "\\/")
+	pattern = strings.TrimSuffix(pattern, "/")
+
+	if pattern == "" || pattern == "/" {
+		return nil, fmt.Errorf("pattern is empty")
+	}
+
+	var re strings.Builder
+
+	// patterns with a slash before their end are relative to `from`, others match at any level
+	if strings.HasPrefix(pattern, "/") {
+		pattern = pattern[1:]
+		re.WriteString("^")
+	} else if strings.Contains(pattern, "/") || strings.HasPrefix(pattern, "**") {
+		re.WriteString("^")
+	} else {
+		re.WriteString("^(?:.*/)?")
+	}
+
+	braces := 0
+
+	for i := 0; i < len(pattern); i++ {
+		switch c := pattern[i]; {
+		case strings.HasPrefix(pattern[i:], "**/") && (i == 0 || pattern[i-1] == '/'):
+			// any number of directories, including none
+			re.WriteString("(?:.*/)?")
+			i += 2
+		case pattern[i:] == "**" && (i == 0 || pattern[i-1] == '/'):
+			re.WriteString(".*")
+			i++
+		case c == '*':
+			re.WriteString("[^/]*")
+		case c == '?':
+			re.WriteString("[^/]")
+		case c == '[':
+			end := strings.IndexByte(pattern[i+1:], ']')
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated character class")
+			}
+
+			class := pattern[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+
+			re.WriteString("[" + strings.ReplaceAll(class, "/", "") + "]")
+			i += end + 1
+		case c == '{':
+			braces++
+			re.WriteString("(?:")
+		case c == ',' && braces > 0:
+			re.WriteString("|")
+		case c == '}' && braces > 0:
+			braces--
+			re.WriteString(")")
+		case c == '\\':
+			if i+1 == len(pattern) {
+				return nil, fmt.Errorf("pattern ends with an escape character")
+			}
+
+			i++
+			re.WriteString(regexp.QuoteMeta(pattern[i : i+1]))
+		default:
+			re.WriteString(regexp.QuoteMeta(pattern[i : i+1]))
+		}
+	}
+
+	if braces > 0 {
+		return nil, fmt.Errorf("unterminated braces")
+	}
+
+	// a match of a directory matches all files below it, files never end in a slash
+	if dirOnly {
+		re.WriteString("/.*$")
+	} else {
+		re.WriteString("(?:/.*)?$")
+	}
+
+	return regexp.Compile(re.String())
+}
@@ -0,0 +1,74 @@
+package pasta
+
+import "testing"
+
+func TestGlobs(t *testing.T) {
+	tests := []struct {
+		patterns []string
+		matches  []string
+		others   []string
+	}{
+		{
+			patterns: []string{"*.png"},
+			matches:  []string{"a.png", "img/a.png", "img/sub/.png"},
+			others:   []string{"a.png.txt", "apng", "img/a.svg"},
+		},
+		{
+			patterns: []string{"*.{png,svg}"},
+			matches:  []string{"a.png", "img/b.svg"},
+			others:   []string{"a.jpg", "a.{png,svg}"},
+		},
+		{
+			patterns: []string{"docs/**/*.md"},
+			matches:  []string{"docs/a.md", "docs/x/y/b.md"},
+			others:   []string{"a.md", "other/docs/a.md"},
+		},
+		{
+			patterns: []string{"**/testdata/"},
+			matches:  []string{"testdata/a.txt", "pkg/testdata/b/c.txt"},
+			others:   []string{"testdata", "pkg/testdata.txt"},
+		},
+		{
+			patterns: []string{"/a.txt", "b"},
+			matches:  []string{"a.txt", "b", "x/b", "b/c.txt"},
+			others:   []string{"x/a.txt", "bb"},
+		},
+		{
+			patterns: []string{"img/**", "!*.svg", "img/keep.svg"},
+			matches:  []string{"img/a.png", "img/keep.svg"},
+			others:   []string{"img/a.svg", "a.png"},
+		},
+		{
+			patterns: []string{"file?.[a-c]", `\!x.txt`},
+			matches:  []string{"file1.a", "d/fileX.c", "!x.txt"},
+			others:   []string{"file.a", "file1.d", "file1/a", "x.txt"},
+		},
+	}
+
+	for _, tt := range tests {
+		g, err := CompileGlobs(tt.patterns)
+		if err != nil {
+			t.Fatalf("CompileGlobs(%q) = %v", tt.patterns, err)
+		}
+
+		for _, p := range tt.matches {
+			if !g.Match(p) {
+				t.Errorf("%q doesn't match %v", tt.patterns, p)
+			}
+		}
+
+		for _, p := range tt.others {
+			if g.Match(p) {
+				t.Errorf("%q matches %v", tt.patterns, p)
+			}
+		}
+	}
+}
+
+func TestInvalidGlobs(t *testing.T) {
+	for _, pattern := range []string{"", "!", "/", "[a-z", "*.{png", `a\`} {
+		if _, err := CompileGlobs([]string{pattern}); err == nil {
+			t.Errorf("CompileGlobs(%q) succeeded", pattern)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package pasta
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/audiotool/pasta/pkg/copier"
+)
+
+// KeepFunc returns the function deciding which files are copied according to rules, which
+// is true for all paths listed in Files if set, and for paths matched by the globs or the
+// regexes otherwise.
+func KeepFunc(rules copier.Rules) (func(path string) bool, error) {
+	files := make(map[string]bool, len(rules.Files))
+	for _, file := range rules.Files {
+		files[file] = true
+	}
+
+	// prepare & compile include regexps, files matching any of them are included
+	includeRegexps := make([]*regexp.Regexp, len(rules.Include))
+	for i, include := range rules.Include {
+		includeRegexp, err := IncludeRegexp(include)
+
+		if err != nil {
+			return nil, fmt.Errorf("include %v: %w", i, err)
+		}
+		includeRegexps[i] = includeRegexp
+	}
+
+	// everything is included by default
+	if len(includeRegexps) == 0 {
+		includeRegexps = append(includeRegexps, regexp.MustCompile(".*"))
+	}
+
+	// prepare & compile exclude regexps, files matching any of them are excluded
+	excludeRegexps := make([]*regexp.Regexp, len(rules.Exclude))
+	for i, exclude := range rules.Exclude {
+		excludeRegexp, err := ExcludeRegexp(exclude)
+
+		if err != nil {
+			return nil, fmt.Errorf("exclude %v: %w", i, err)
+		}
+		excludeRegexps[i] = excludeRegexp
+	}
+
+	includeGlobs, err := CompileGlobs(rules.IncludeGlob)
+	if err != nil {
+		return nil, fmt.Errorf("include_glob: %w", err)
+	}
+
+	excludeGlobs, err := CompileGlobs(rules.ExcludeGlob)
+	if err != nil {
+		return nil, fmt.Errorf("exclude_glob: %w", err)
+	}
+
+	globs := !includeGlobs.Empty() || !excludeGlobs.Empty()
+
+	return func(path string) bool {
+		if len(files) > 0 {
+			return files[path]
+		}
+		if globs {
+			return (includeGlobs.Empty() || includeGlobs.Match(path)) && !excludeGlobs.Match(path)
+		}
+		return matchAny(includeRegexps, path) && !matchAny(excludeRegexps, path)
+	}, nil
+}
+
+// matchAny returns true if any of res matches s.
+func matchAny(res []*regexp.Regexp, s string) bool {
+	for _, re := range res {
+		if re.MatchString(s) {
+			return true
+		}
+	}
+
+	return false
+}
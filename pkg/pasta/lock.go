@@ -0,0 +1,239 @@
+package pasta
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/audiotool/pasta/pkg/utils"
+	"gopkg.in/yaml.v3"
+)
+
+// ResultFile is the name of the file written next to pasta.yaml after every run. Besides
+// documenting what was copied, it serves as a lockfile: later runs copy exactly the references
+// recorded in it.
+const ResultFile = "pasta.result.yaml"
+
+// readResult reads the pasta.result.yaml in dir. If it doesn't exist, empty results are returned.
+func readResult(dir string) (*pastaResults, error) {
+	content, err := os.ReadFile(path.Join(dir, ResultFile))
+
+	if errors.Is(err, os.ErrNotExist) {
+		return &pastaResults{}, nil
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("error reading %v: %v", ResultFile, err)
+	}
+
+	var res pastaResults
+	if err := yaml.Unmarshal(content, &res); err != nil {
+		return nil, fmt.Errorf("error parsing %v: %v", ResultFile, err)
+	}
+
+	return &res, nil
+}
+
+// resultFor returns a result containing the fields identifying dep in pasta.result.yaml.
+func resultFor(dep Dependency, root string) yamlResult {
+	to, err := filepath.Rel(root, dep.Target)
+	if err != nil {
+		to = dep.Target
+	}
+
+	return yamlResult{
+		URL:  dep.Option.URL,
+		From: dep.Option.From,
+		To:   filepath.ToSlash(to),
+		Ref:  dep.Option.Options["ref"],
+	}
+}
+
+// key identifies the dependency a result belongs to. If any of these fields
+// change in pasta.yaml, the recorded result doesn't apply anymore.
+func (r *yamlResult) key() string {
+	return strings.Join([]string{r.URL, r.From, r.To, r.Ref}, "\x00")
+}
+
+// sourceFields are the fields of a copier's source info pasta cares about.
+type sourceFields struct {
+	Reference string `yaml:"reference"`
+	Tag       string `yaml:"tag"`
+}
+
+// decodeSourceInfo returns the fields of a copier's source info pasta cares about. Fields
+// the source info doesn't have are empty.
+func decodeSourceInfo(sourceInfo any) sourceFields {
+	var info sourceFields
+
+	if sourceInfo == nil {
+		return info
+	}
+
+	content, err := yaml.Marshal(sourceInfo)
+	if err != nil {
+		return info
+	}
+
+	if err := yaml.Unmarshal(content, &info); err != nil {
+		return sourceFields{}
+	}
+
+	return info
+}
+
+// reference returns the "reference" field of a copier's source info, or the
+// empty string if it has none.
+func reference(sourceInfo any) string {
+	return decodeSourceInfo(sourceInfo).Reference
+}
+
+// recordedResults returns the result recorded in prev for each of deps, nil for dependencies
+// without a successful result.
+func recordedResults(deps []Dependency, prev *pastaResults, root string) []*yamlResult {
+	recorded := make(map[string][]*yamlResult)
+
+	for i := range prev.Deps {
+		r := &prev.Deps[i]
+
+		if r.Skipped || r.Error != "" {
+			continue
+		}
+
+		recorded[r.key()] = append(recorded[r.key()], r)
+	}
+
+	records := make([]*yamlResult, len(deps))
+
+	for i, dep := range deps {
+		res := resultFor(dep, root)
+		k := res.key()
+
+		if len(recorded[k]) == 0 {
+			continue
+		}
+
+		// dependencies with identical keys are matched in order
+		records[i] = recorded[k][0]
+		recorded[k] = recorded[k][1:]
+	}
+
+	return records
+}
+
+// lock pins every dependency that isn't marked for update to the reference recorded
+// for it in prev. It returns a copy of deps with Option.Locked set, and the recorded
+// result for each locked dependency (nil for unlocked ones). Dependencies whose recorded
+// source info has no reference, like local ones, are never locked.
+func lock(deps []Dependency, prev *pastaResults, root string) ([]Dependency, []*yamlResult) {
+	records := recordedResults(deps, prev, root)
+
+	locked := make([]Dependency, len(deps))
+	locks := make([]*yamlResult, len(deps))
+
+	for i, dep := range deps {
+		locked[i] = dep
+
+		if dep.Update || records[i] == nil || reference(records[i].SourceInfo) == "" {
+			continue
+		}
+
+		locks[i] = records[i]
+		locked[i].Option.Locked = reference(locks[i].SourceInfo)
+	}
+
+	return locked, locks
+}
+
+// hashResults records the hash of every file a dependency copied into its temp
+// directory, by path relative to root.
+func hashResults(deps []Dependency, results []CopyResult, root string) error {
+	for i, dep := range deps {
+		if results[i].Err != nil {
+			continue
+		}
+
+		files, err := findFiles(dep.Option.TempDir)
+		if err != nil {
+			return fmt.Errorf("error listing files in temp directory %v: %v", dep.Option.TempDir, err)
+		}
+
+		rewritten, err := rewritePaths(dep, files)
+		if err != nil {
+			return fmt.Errorf("dependency %v: %v", dep.Option.URL, err)
+		}
+
+		to := resultFor(dep, root).To
+		results[i].Files = make(map[string]string, len(files))
+
+		for j, p := range files {
+			hash, err := utils.HashFile(path.Join(dep.Option.TempDir, p))
+			if err != nil {
+				return fmt.Errorf("error hashing file %v: %v", p, err)
+			}
+
+			results[i].Files[path.Join(to, rewritten[j])] = hash
+		}
+	}
+
+	return nil
+}
+
+// checkLocks makes sure every locked dependency reproduced exactly what is recorded
+// in its lock, the same files with the same content. The recorded source info is kept, so that a locked run reproduces
+// pasta.result.yaml as well.
+func checkLocks(deps []Dependency, results []CopyResult, locks []*yamlResult) error {
+	for i, l := range locks {
+		if l == nil || results[i].Err != nil {
+			continue
+		}
+
+		url := deps[i].Option.URL
+
+		if ref := reference(results[i].CopierInfo); ref != deps[i].Option.Locked {
+			return fmt.Errorf("dependency %v is locked to %v, but the copier returned %v; run `pasta update` to re-resolve it", url, deps[i].Option.Locked, ref)
+		}
+
+		files := make([]string, 0, len(results[i].Files))
+		for p := range results[i].Files {
+			files = append(files, p)
+		}
+		sort.Strings(files)
+
+		var added, removed []string
+
+		for _, p := range files {
+			hash, ok := l.Files[p]
+
+			switch {
+			case !ok:
+				added = append(added, p)
+			case hash != results[i].Files[p]:
+				return fmt.Errorf("dependency %v: content of %v differs from %v; run `pasta update` to re-resolve it", url, p, ResultFile)
+			}
+		}
+
+		for p := range l.Files {
+			if _, ok := results[i].Files[p]; !ok {
+				removed = append(removed, p)
+			}
+		}
+		sort.Strings(removed)
+
+		if len(added) > 0 {
+			return fmt.Errorf("dependency %v: copied %v, which aren't in %v; run `pasta update` to re-resolve it", url, strings.Join(added, ", "), ResultFile)
+		}
+
+		if len(removed) > 0 {
+			return fmt.Errorf("dependency %v: didn't copy %v, which are in %v; run `pasta update` to re-resolve it", url, strings.Join(removed, ", "), ResultFile)
+		}
+
+		results[i].CopierInfo = l.SourceInfo
+	}
+
+	return nil
+}
@@ -0,0 +1,169 @@
+package pasta
+
+import (
+	"context"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/audiotool/pasta/pkg/copier"
+)
+
+func TestRunLocked(t *testing.T) {
+	root := t.TempDir()
+	pastaFile := path.Join(root, "pasta.yaml")
+	ctx := context.Background()
+
+	fake := &fakeCopier{
+		head: "sha1",
+		files: map[string]map[string]string{
+			"sha1": {"a.txt": "one"},
+			"sha2": {"a.txt": "two"},
+		},
+	}
+	reg := registryOf(fake)
+
+	if _, err := Run(ctx, Options{Registry: reg, Deps: fakeDeps(t, root), PastaFile: pastaFile}); err != nil {
+		t.Fatalf("first run: %v", err)
+	}
+
+	res, err := readResult(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(res.Deps) != 1 || reference(res.Deps[0].SourceInfo) != "sha1" {
+		t.Fatalf("expected result locked to sha1, got %+v", res.Deps)
+	}
+
+	if res.Deps[0].Files["out/a.txt"] == "" {
+		t.Errorf("expected hash of out/a.txt in result, got %v", res.Deps[0].Files)
+	}
+
+	// upstream moves on, the lock keeps the old version
+	fake.head = "sha2"
+
+	if _, err := Run(ctx, Options{Registry: reg, Deps: fakeDeps(t, root), PastaFile: pastaFile}); err != nil {
+		t.Fatalf("locked run: %v", err)
+	}
+
+	if got := readFile(t, path.Join(root, "out", "a.txt")); got != "one" {
+		t.Errorf("locked run copied %q, expected %q", got, "one")
+	}
+
+	// updating re-resolves the ref
+	deps := fakeDeps(t, root)
+	deps[0].Update = true
+
+	if _, err := Run(ctx, Options{Registry: reg, Deps: deps, PastaFile: pastaFile}); err != nil {
+		t.Fatalf("update run: %v", err)
+	}
+
+	if got := readFile(t, path.Join(root, "out", "a.txt")); got != "two" {
+		t.Errorf("update run copied %q, expected %q", got, "two")
+	}
+
+	res, err = readResult(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if ref := reference(res.Deps[0].SourceInfo); ref != "sha2" {
+		t.Errorf("expected result locked to sha2, got %v", ref)
+	}
+}
+
+func TestRunLockedContentChanged(t *testing.T) {
+	root := t.TempDir()
+	pastaFile := path.Join(root, "pasta.yaml")
+	ctx := context.Background()
+
+	fake := &fakeCopier{
+		head:  "sha1",
+		files: map[string]map[string]string{"sha1": {"a.txt": "one"}},
+	}
+	reg := registryOf(fake)
+
+	if _, err := Run(ctx, Options{Registry: reg, Deps: fakeDeps(t, root), PastaFile: pastaFile}); err != nil {
+		t.Fatalf("first run: %v", err)
+	}
+
+	// the same reference now produces different content
+	fake.files["sha1"]["a.txt"] = "tampered"
+
+	if _, err := Run(ctx, Options{Registry: reg, Deps: fakeDeps(t, root), PastaFile: pastaFile}); err == nil {
+		t.Errorf("expected error when locked content changed")
+	}
+}
+
+func TestRunLockedFilesChanged(t *testing.T) {
+	tests := []struct {
+		name  string
+		files map[string]string
+		want  string
+	}{
+		{name: "added", files: map[string]string{"a.txt": "one", "b.txt": "new"}, want: "out/b.txt"},
+		{name: "removed", files: map[string]string{}, want: "out/a.txt"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := t.TempDir()
+			pastaFile := path.Join(root, "pasta.yaml")
+			ctx := context.Background()
+
+			fake := &fakeCopier{
+				head:  "sha1",
+				files: map[string]map[string]string{"sha1": {"a.txt": "one"}},
+			}
+			reg := registryOf(fake)
+
+			if _, err := Run(ctx, Options{Registry: reg, Deps: fakeDeps(t, root), PastaFile: pastaFile}); err != nil {
+				t.Fatalf("first run: %v", err)
+			}
+
+			// the same reference now produces other files
+			fake.files["sha1"] = tt.files
+
+			_, err := Run(ctx, Options{Registry: reg, Deps: fakeDeps(t, root), PastaFile: pastaFile})
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("Run() = %v, expected lock mismatch about %v", err, tt.want)
+			}
+
+			if got := readFile(t, path.Join(root, "out", "a.txt")); got != "one" {
+				t.Errorf("a.txt = %q after lock mismatch, expected it kept", got)
+			}
+		})
+	}
+}
+
+func TestLockKeys(t *testing.T) {
+	root := "/repo"
+	deps := []Dependency{
+		{Option: copier.CopyConfig{URL: "u", From: "a/", Options: map[string]string{"ref": "main"}}, Target: "/repo/x"},
+		{Option: copier.CopyConfig{URL: "u", From: "a/", Options: map[string]string{"ref": "dev"}}, Target: "/repo/x"},
+		{Option: copier.CopyConfig{URL: "u", From: "a/", Options: map[string]string{"ref": "main"}}, Target: "/repo/x"},
+	}
+
+	prev := &pastaResults{Deps: []yamlResult{
+		{URL: "u", From: "a/", To: "x", Ref: "main", SourceInfo: map[string]any{"reference": "s1"}},
+		{URL: "u", From: "a/", To: "x", Ref: "main", SourceInfo: map[string]any{"reference": "s2"}},
+	}}
+
+	locked, locks := lock(deps, prev, root)
+
+	want := []string{"s1", "", "s2"}
+	for i, w := range want {
+		if locked[i].Option.Locked != w {
+			t.Errorf("dependency %v: locked to %q, expected %q", i, locked[i].Option.Locked, w)
+		}
+
+		if (locks[i] != nil) != (w != "") {
+			t.Errorf("dependency %v: unexpected lock %v", i, locks[i])
+		}
+	}
+
+	if deps[0].Option.Locked != "" {
+		t.Errorf("lock must not modify its input")
+	}
+}
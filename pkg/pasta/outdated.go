@@ -0,0 +1,115 @@
+package pasta
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sync"
+
+	"github.com/audiotool/pasta/pkg/copier"
+)
+
+// errCantResolve is reported for dependencies whose copier can't resolve refs, e.g. local directories.
+var errCantResolve = errors.New("copier can't resolve refs")
+
+// Status compares the reference recorded for a dependency in pasta.result.yaml with
+// what its ref resolves to now.
+type Status struct {
+	URL    string
+	Target string
+	Ref    string
+	// Current is the reference recorded in pasta.result.yaml, empty if there is none
+	Current string
+	// CurrentTag is the tag recorded in pasta.result.yaml, if ref is a version constraint
+	CurrentTag string
+	// Wanted is the reference ref resolves to now
+	Wanted string
+	// WantedTag is the tag ref resolves to now, if it is a version constraint
+	WantedTag string
+	// Latest is the newest tag of the source, empty if it has no version tags
+	Latest string
+	// Behind is the number of commits Current is behind Wanted, -1 if unknown
+	Behind int
+	// Err is set if the dependency couldn't be resolved
+	Err error
+}
+
+// Outdated returns true if the ref of the dependency resolves to something else than
+// what was copied.
+func (s *Status) Outdated() bool {
+	return s.Err == nil && s.Wanted != s.Current
+}
+
+// Outdated resolves the ref of every dependency with the copiers of reg, and compares it with the reference
+// recorded in pasta.result.yaml. Nothing is copied.
+func Outdated(ctx context.Context, reg *Registry, deps []Dependency, pastaFilePath string) (statuses []Status, err error) {
+	root := filepath.Dir(pastaFilePath)
+
+	defer func() {
+		if clearErr := clearTempDirs(deps); clearErr != nil {
+			err = errors.Join(err, clearErr)
+		}
+	}()
+
+	prev, err := readResult(root)
+	if err != nil {
+		return nil, err
+	}
+
+	deps, locks := lock(deps, prev, root)
+
+	statuses = make([]Status, len(deps))
+
+	var wg sync.WaitGroup
+
+	for i, dep := range deps {
+		i, dep := i, dep
+
+		status := &statuses[i]
+		res := resultFor(dep, root)
+
+		status.URL = dep.Option.URL
+		status.Target = res.To
+		status.Ref = res.Ref
+		status.Current = dep.Option.Locked
+		status.Behind = -1
+
+		if locks[i] != nil {
+			status.CurrentTag = decodeSourceInfo(locks[i].SourceInfo).Tag
+		}
+
+		c, err := findCopier(reg, dep)
+		if err != nil {
+			status.Err = err
+			continue
+		}
+
+		resolver, ok := c.(copier.Resolver)
+		if !ok {
+			status.Err = errCantResolve
+			continue
+		}
+
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			res, err := resolver.Resolve(ctx, dep.Option)
+			if err != nil {
+				status.Err = fmt.Errorf("error resolving %v: %w", dep.Option.URL, err)
+				return
+			}
+
+			status.Wanted = res.Reference
+			status.WantedTag = res.Tag
+			status.Latest = res.Latest
+			status.Behind = res.Behind
+		}()
+	}
+
+	wg.Wait()
+
+	return statuses, nil
+}
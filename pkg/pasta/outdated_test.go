@@ -0,0 +1,94 @@
+package pasta
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/audiotool/pasta/pkg/copier"
+)
+
+// resolvingCopier is a fakeCopier that can resolve refs. Every reference is one commit
+// ahead of the previous one, in the order of history.
+type resolvingCopier struct {
+	fakeCopier
+	history []string
+}
+
+func (c *resolvingCopier) Resolve(ctx context.Context, config copier.CopyConfig) (*copier.Resolution, error) {
+	res := &copier.Resolution{Reference: c.head, Latest: "v1.0.0", Behind: -1}
+
+	for i, ref := range c.history {
+		if ref == config.Locked {
+			res.Behind = len(c.history) - 1 - i
+		}
+	}
+
+	return res, nil
+}
+
+func TestOutdated(t *testing.T) {
+	root := t.TempDir()
+	pastaFile := path.Join(root, "pasta.yaml")
+
+	c := &resolvingCopier{
+		fakeCopier: fakeCopier{
+			head: "v1",
+			files: map[string]map[string]string{
+				"v1": {"a.txt": "a1"},
+				"v2": {"a.txt": "a2"},
+				"v3": {"a.txt": "a3"},
+			},
+		},
+		history: []string{"v1", "v2", "v3"},
+	}
+
+	reg := registryOf(c)
+
+	if _, err := Run(context.Background(), Options{Registry: reg, Deps: fakeDeps(t, root), PastaFile: pastaFile}); err != nil {
+		t.Fatal(err)
+	}
+
+	c.head = "v3"
+
+	deps := fakeDeps(t, root)
+
+	statuses, err := Outdated(context.Background(), reg, deps, pastaFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(deps[0].Option.TempDir); !os.IsNotExist(err) {
+		t.Errorf("temp dir wasn't removed: %v", err)
+	}
+
+	want := Status{URL: "fake://repo", Target: "out", Ref: "main", Current: "v1", Wanted: "v3", Latest: "v1.0.0", Behind: 2}
+	if len(statuses) != 1 || statuses[0] != want {
+		t.Fatalf("Outdated() = %+v, expected %+v", statuses, want)
+	}
+
+	if !statuses[0].Outdated() {
+		t.Errorf("dependency behind its ref isn't outdated")
+	}
+
+	if got := readFile(t, path.Join(root, "out/a.txt")); got != "a1" {
+		t.Errorf("Outdated() changed the working tree: a.txt = %q", got)
+	}
+}
+
+func TestOutdatedUnsupported(t *testing.T) {
+	root := t.TempDir()
+
+	reg := registryOf(&fakeCopier{head: "v1"})
+
+	statuses, err := Outdated(context.Background(), reg, fakeDeps(t, root), path.Join(root, "pasta.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(statuses) != 1 || !errors.Is(statuses[0].Err, errCantResolve) || statuses[0].Outdated() {
+		t.Errorf("Outdated() = %+v, expected %v", statuses, errCantResolve)
+	}
+}
@@ -2,6 +2,7 @@ package pasta
 
 import (
 	"fmt"
+	"path"
 	"regexp"
 	"strings"
 )
@@ -57,3 +58,50 @@ func ExcludeRegexp(exclude string) (*regexp.Regexp, error) {
 
 	return includeRegexp, nil
 }
+
+// renameSeparator separates the pattern of a rename rule from its replacement.
+const renameSeparator = " -> "
+
+// RewriteFunc returns the function mapping the paths of copied files to the paths they are
+// copied to, given rename rules like `^v1/(.*)\.proto$ -> api/$1.proto`. A path is renamed
+// by the first rule whose pattern matches it, the replacement may refer to submatches. If
+// flatten is set, files are copied to the target directory itself, without their directories.
+// Returns nil if paths are copied unchanged.
+func RewriteFunc(rules []string, flatten bool) (func(p string) string, error) {
+	if len(rules) == 0 && !flatten {
+		return nil, nil
+	}
+
+	patterns := make([]*regexp.Regexp, len(rules))
+	replacements := make([]string, len(rules))
+
+	for i, rule := range rules {
+		pattern, replacement, ok := strings.Cut(rule, renameSeparator)
+		if !ok {
+			return nil, fmt.Errorf("rename rule %q must have the form 'pattern%vreplacement'", rule, renameSeparator)
+		}
+
+		var err error
+		patterns[i], err = regexp.Compile(strings.TrimSpace(pattern))
+		if err != nil {
+			return nil, fmt.Errorf("failed to compile rename regexp: %w", err)
+		}
+
+		replacements[i] = strings.TrimSpace(replacement)
+	}
+
+	return func(p string) string {
+		for i, pattern := range patterns {
+			if pattern.MatchString(p) {
+				p = pattern.ReplaceAllString(p, replacements[i])
+				break
+			}
+		}
+
+		if flatten {
+			p = path.Base(p)
+		}
+
+		return p
+	}, nil
+}
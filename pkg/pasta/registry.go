@@ -0,0 +1,100 @@
+package pasta
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/audiotool/pasta/pkg/archive"
+	"github.com/audiotool/pasta/pkg/copier"
+	"github.com/audiotool/pasta/pkg/git"
+	"github.com/audiotool/pasta/pkg/github"
+	"github.com/audiotool/pasta/pkg/gitlab"
+	"github.com/audiotool/pasta/pkg/local"
+)
+
+// Priorities of the built-in copiers. Archives are matched first, they might e.g. be github
+// release assets. Hosted repositories are matched before git, since their urls might end in .git
+const (
+	PriorityArchive = 40
+	PriorityHosted  = 30
+	PriorityGit     = 20
+	PriorityLocal   = 10
+	// DefaultPriority is the priority of copiers added with Register, which makes them
+	// match before all built-in copiers.
+	DefaultPriority = 100
+	// PriorityDeclared is the priority of plugins declared in pasta.yaml, which match
+	// before all built-in copiers, since the user chose them.
+	PriorityDeclared = DefaultPriority
+	// PriorityDiscovered is the priority of plugins found on the search path, which only
+	// match urls none of the built-in copiers match, since any executable on $PATH could
+	// otherwise take over dependencies.
+	PriorityDiscovered = 0
+)
+
+// Registry holds the copiers dependencies can be copied with. A dependency is copied by
+// the copier with the highest priority that matches its url, copiers with the same priority
+// are tried in the order they were registered.
+//
+// The zero Registry has no copiers, NewRegistry returns one with the built-in copiers.
+// Copiers must be registered before the registry is used.
+type Registry struct {
+	copiers []registered
+}
+
+type registered struct {
+	copier   copier.Copier
+	priority int
+}
+
+// NewRegistry returns a registry containing the built-in copiers.
+func NewRegistry() *Registry {
+	r := &Registry{}
+
+	r.RegisterPriority(&archive.Copier{}, PriorityArchive)
+	r.RegisterPriority(&github.Copier{}, PriorityHosted)
+	r.RegisterPriority(&gitlab.Copier{}, PriorityHosted)
+	r.RegisterPriority(&git.Copier{}, PriorityGit)
+	r.RegisterPriority(&local.Copier{}, PriorityLocal)
+
+	return r
+}
+
+// Register adds c with DefaultPriority.
+func (r *Registry) Register(c copier.Copier) {
+	r.RegisterPriority(c, DefaultPriority)
+}
+
+// RegisterPriority adds c with the given priority, copiers with higher priorities are matched first.
+func (r *Registry) RegisterPriority(c copier.Copier, priority int) {
+	r.copiers = append(r.copiers, registered{copier: c, priority: priority})
+
+	sort.SliceStable(r.copiers, func(a, b int) bool {
+		return r.copiers[a].priority > r.copiers[b].priority
+	})
+}
+
+// Clone returns a copy of r, copiers registered to either of them don't affect the other.
+func (r *Registry) Clone() *Registry {
+	return &Registry{copiers: append([]registered(nil), r.copiers...)}
+}
+
+// Copiers returns all copiers in the order they are matched.
+func (r *Registry) Copiers() []copier.Copier {
+	cs := make([]copier.Copier, len(r.copiers))
+	for i, reg := range r.copiers {
+		cs[i] = reg.copier
+	}
+
+	return cs
+}
+
+// Find returns the first copier matching url.
+func (r *Registry) Find(url string) (copier.Copier, error) {
+	for _, reg := range r.copiers {
+		if reg.copier.Matches(url) {
+			return reg.copier, nil
+		}
+	}
+
+	return nil, fmt.Errorf("no copier found for url %v", url)
+}
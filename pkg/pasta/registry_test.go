@@ -0,0 +1,95 @@
+package pasta
+
+import (
+	"context"
+	"testing"
+
+	"github.com/audiotool/pasta/pkg/archive"
+	"github.com/audiotool/pasta/pkg/copier"
+	"github.com/audiotool/pasta/pkg/git"
+	"github.com/audiotool/pasta/pkg/github"
+	"github.com/audiotool/pasta/pkg/local"
+)
+
+// prefixCopier matches all urls starting with prefix.
+type prefixCopier struct {
+	prefix string
+}
+
+func (c *prefixCopier) Matches(url string) bool {
+	return len(url) >= len(c.prefix) && url[:len(c.prefix)] == c.prefix
+}
+
+func (*prefixCopier) Copy(ctx context.Context, config copier.CopyConfig) (any, error) {
+	return nil, nil
+}
+
+func TestRegistry(t *testing.T) {
+	internal := &prefixCopier{prefix: "https://github.com/acme/"}
+	fallback := &prefixCopier{prefix: "https://"}
+	first := &prefixCopier{prefix: "corp://"}
+	second := &prefixCopier{prefix: "corp://"}
+
+	reg := NewRegistry()
+	reg.Register(internal)
+	reg.RegisterPriority(fallback, 0)
+	reg.Register(first)
+	reg.Register(second)
+
+	tests := []struct {
+		url  string
+		want copier.Copier
+	}{
+		// registered copiers are matched before the built-in ones
+		{url: "https://github.com/acme/protocol", want: internal},
+		{url: "https://github.com/other/protocol", want: &github.Copier{}},
+		{url: "https://example.com/bundle.tar.gz", want: &archive.Copier{}},
+		{url: "git@example.com:acme/protocol", want: &git.Copier{}},
+		{url: "../shared", want: &local.Copier{}},
+		// copiers with lower priority than the built-in ones are a fallback
+		{url: "https://example.com/acme", want: fallback},
+		// copiers with the same priority are matched in the order they were registered
+		{url: "corp://protocol", want: first},
+	}
+
+	for _, tt := range tests {
+		got, err := reg.Find(tt.url)
+		if err != nil {
+			t.Errorf("Find(%v) error = %v", tt.url, err)
+			continue
+		}
+
+		if got != tt.want && !sameType(got, tt.want) {
+			t.Errorf("Find(%v) = %T %v, expected %T %v", tt.url, got, got, tt.want, tt.want)
+		}
+	}
+
+	if _, err := reg.Find("unknown"); err == nil {
+		t.Errorf("Find() of unknown url succeeded")
+	}
+
+	if _, err := (&Registry{}).Find("../shared"); err == nil {
+		t.Errorf("Find() in empty registry succeeded")
+	}
+}
+
+// sameType returns true for built-in copiers of the same type, which are compared by type
+// since NewRegistry creates them.
+func sameType(a, b copier.Copier) bool {
+	switch b.(type) {
+	case *github.Copier:
+		_, ok := a.(*github.Copier)
+		return ok
+	case *archive.Copier:
+		_, ok := a.(*archive.Copier)
+		return ok
+	case *git.Copier:
+		_, ok := a.(*git.Copier)
+		return ok
+	case *local.Copier:
+		_, ok := a.(*local.Copier)
+		return ok
+	default:
+		return false
+	}
+}
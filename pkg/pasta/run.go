@@ -4,52 +4,71 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io"
 	"io/fs"
 	"os"
 	"path"
 	"path/filepath"
+	"sort"
 	"strings"
 	"sync"
 
 	"github.com/audiotool/pasta/pkg/copier"
-	"github.com/audiotool/pasta/pkg/github"
 	"github.com/audiotool/pasta/pkg/utils"
 	"golang.org/x/sync/errgroup"
 	"gopkg.in/yaml.v3"
 )
 
-var copiers = []copier.Copier{&github.Copier{}}
-
 type CopyResult struct {
 	Err        error
 	CopierInfo any
+	// Files maps the path of every copied file, relative to the directory
+	// containing pasta.yaml, to the sha256 hash of its content.
+	Files map[string]string
 }
 
 // tries to find matching copier, then executes copy with that copier
-func executeCopy(ctx context.Context, option copier.CopyConfig) (any, error) {
-	for _, c := range copiers {
-		if !c.Matches(option.URL) {
-			continue
-		}
+func executeCopy(ctx context.Context, reg *Registry, dep Dependency) (any, error) {
+	c, err := findCopier(reg, dep)
+	if err != nil {
+		return nil, err
+	}
 
-		res, err := c.Copy(ctx, option)
-		if err != nil {
-			return nil, fmt.Errorf("copy error: %v", err)
-		}
+	res, err := c.Copy(ctx, dep.Option)
+	if err != nil {
+		return nil, fmt.Errorf("copy error: %v", err)
+	}
 
-		return res, nil
+	return res, nil
+}
+
+// findCopier returns the copier of dep, or the first copier of reg matching its url.
+func findCopier(reg *Registry, dep Dependency) (copier.Copier, error) {
+	if dep.Copier != nil {
+		return dep.Copier, nil
 	}
 
-	// return error if no copier was found
-	return nil, fmt.Errorf("no copier found for url %v", option.URL)
+	return reg.Find(dep.Option.URL)
 }
 
 type Dependency struct {
 	Option copier.CopyConfig
 	Target string
+	// Update is true if the dependency should be resolved again, instead of
+	// being locked to the reference recorded in pasta.result.yaml.
+	Update bool
+	// Copier copies the dependency if set, instead of the first copier of the registry
+	// matching its url.
+	Copier copier.Copier
+	// Rewrite maps the path of a copied file, relative to From, to its path relative to
+	// Target, e.g. to rename or flatten files. Paths are slash separated. Files keep
+	// their path if nil.
+	Rewrite func(p string) string
 }
 
-func copyToTemp(ctx context.Context, deps []Dependency) ([]CopyResult, error) {
+// copyToTemp copies all deps to their temp directories. If any copy fails, the others are
+// canceled, and the results hold the error of every dependency.
+func copyToTemp(ctx context.Context, reg *Registry, deps []Dependency) ([]CopyResult, error) {
 	// dispatch goroutines copying files
 	g, ctx := errgroup.WithContext(ctx)
 
@@ -61,7 +80,10 @@ func copyToTemp(ctx context.Context, deps []Dependency) ([]CopyResult, error) {
 		dep := dep
 
 		g.Go(func() error {
-			res, err := executeCopy(ctx, dep.Option)
+			res, err := executeCopy(ctx, reg, dep)
+			if err != nil {
+				err = fmt.Errorf("dependency %v failed: %w", dep.Option.URL, err)
+			}
 
 			resultsMutex.Lock()
 			results[i] = CopyResult{Err: err, CopierInfo: res}
@@ -74,14 +96,116 @@ func copyToTemp(ctx context.Context, deps []Dependency) ([]CopyResult, error) {
 	err := g.Wait()
 
 	if err != nil {
-		return nil, err
+		// offline copies don't wait for the network, so they complete despite being canceled,
+		// and all of them report what is missing at once
+		if offline(deps) {
+			var errs []error
+			for _, res := range results {
+				errs = append(errs, res.Err)
+			}
+
+			return results, errors.Join(errs...)
+		}
+
+		return results, err
 	}
 
 	return results, nil
 }
 
-func Run(ctx context.Context, deps []Dependency, dryRun, keepDirs bool, pastaFilePath string) (err error) {
-	if !dryRun {
+// offline returns true if any dependency is copied offline.
+func offline(deps []Dependency) bool {
+	for _, dep := range deps {
+		if dep.Option.Offline {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Options configure Run.
+type Options struct {
+	// Registry holds the copiers of dependencies without a Copier, nil for NewRegistry()
+	Registry *Registry
+	Deps     []Dependency
+	// PastaFile is the path of pasta.yaml, the result is recorded next to it
+	PastaFile string
+	// DryRun reports what would be done, without changing the working tree
+	DryRun bool
+	// KeepDirs keeps the files in target directories that were not copied
+	KeepDirs bool
+	// Output receives human readable progress, nothing is written if nil
+	Output io.Writer
+	// Changelog receives a summary of the commits of updated dependencies if not nil
+	Changelog io.Writer
+}
+
+// Report describes what Run did, or would do in a dry run. All paths are relative to the
+// directory containing pasta.yaml.
+type Report struct {
+	Deps []DepReport
+	// Removed lists the files no dependency produces anymore, which were removed
+	Removed []string
+	// Kept lists the files no dependency produces anymore, which were kept since they were
+	// modified after they were copied
+	Kept []string
+}
+
+// DepReport describes the outcome of copying a dependency.
+type DepReport struct {
+	URL    string
+	Target string
+	// Files maps the path of every copied file to the sha256 hash of its content
+	Files map[string]string
+	// SourceInfo is what the copier returned, which is recorded in pasta.result.yaml
+	SourceInfo any
+	// Err is set if copying the dependency failed
+	Err error
+}
+
+// newReport returns the report of the results of deps.
+func newReport(deps []Dependency, results []CopyResult, root string) *Report {
+	report := &Report{}
+
+	for i, dep := range deps {
+		report.Deps = append(report.Deps, DepReport{
+			URL:        dep.Option.URL,
+			Target:     resultFor(dep, root).To,
+			Files:      results[i].Files,
+			SourceInfo: results[i].CopierInfo,
+			Err:        results[i].Err,
+		})
+	}
+
+	return report
+}
+
+// Run copies the dependencies of opts to their targets, and records the result next to
+// pasta.yaml. Nothing is changed if it fails. If copying dependencies fails, the report holds
+// the error of every dependency, other errors return no report. Writing the changelog happens
+// after the working tree was changed, if it fails the report is returned with the error.
+func Run(ctx context.Context, opts Options) (report *Report, err error) {
+	reg := opts.Registry
+	if reg == nil {
+		reg = NewRegistry()
+	}
+
+	out := opts.Output
+	if out == nil {
+		out = io.Discard
+	}
+
+	root := filepath.Dir(opts.PastaFile)
+
+	prev, err := readResult(root)
+	if err != nil {
+		return nil, err
+	}
+
+	deps, locks := lock(opts.Deps, prev, root)
+
+	if !opts.DryRun {
 		defer func() {
 			clearErr := clearTempDirs(deps)
 
@@ -91,44 +215,78 @@ func Run(ctx context.Context, deps []Dependency, dryRun, keepDirs bool, pastaFil
 		}()
 	}
 
-	results, err := copyToTemp(ctx, deps)
+	results, err := copyToTemp(ctx, reg, deps)
 
 	if err != nil {
-		return fmt.Errorf("error copying dependencies: %v", err)
+		return newReport(deps, results, root), fmt.Errorf("error copying dependencies: %v", err)
 	}
 
-	if !dryRun && !keepDirs {
-		// remove target directories if enabled
-		for _, dep := range deps {
-			if !dep.Option.ClearTarget {
-				continue
-			}
+	if err := hashResults(deps, results, root); err != nil {
+		return nil, fmt.Errorf("error hashing copied files: %v", err)
+	}
 
-			if err := os.RemoveAll(dep.Target); err != nil {
-				return fmt.Errorf("error removing target directory %v: %v", dep.Target, err)
-			}
+	if err := checkLocks(deps, results, locks); err != nil {
+		return nil, err
+	}
+
+	// copy from temp to target. All changes are undone if anything fails, so a failed
+	// run never leaves the working tree in a worse state than before.
+	tx := &transaction{}
+
+	if err := copyToTarget(tx, out, deps, results, opts.DryRun, opts.KeepDirs, root); err != nil {
+		return nil, rollback(tx, fmt.Errorf("error copying files from temp to target dir: %v", err))
+	}
+
+	// remove files pasta copied before, but no dependency produces anymore
+	stale, err := findStale(prev, results, root)
+	if err != nil {
+		return nil, rollback(tx, fmt.Errorf("error finding stale files: %v", err))
+	}
+
+	emptied, err := removeStale(tx, out, stale, root, opts.DryRun)
+	if err != nil {
+		return nil, rollback(tx, err)
+	}
+
+	if !opts.DryRun {
+		if err := writeResult(tx, out, deps, results, root); err != nil {
+			return nil, rollback(tx, fmt.Errorf("error writing results file: %v", err))
 		}
 	}
 
-	// copy from temp to target
-	if err := copyToTarget(deps, results, dryRun); err != nil {
-		return fmt.Errorf("error copying files from temp to target dir: %v", err)
+	if err := tx.commit(); err != nil {
+		return nil, err
+	}
+
+	removeEmptyDirs(emptied, root)
+
+	report = newReport(deps, results, root)
+
+	for _, f := range stale {
+		if f.modified {
+			report.Kept = append(report.Kept, f.path)
+		} else {
+			report.Removed = append(report.Removed, f.path)
+		}
 	}
 
-	if !dryRun {
-		if err := writeResult(deps, results, filepath.Dir(pastaFilePath)); err != nil {
-			fmt.Printf("Error writing results file: %v", err)
-			os.Exit(1)
+	// summarize the commits of dependencies that moved to another reference
+	if opts.Changelog != nil {
+		changes := findChanges(deps, results, prev, root)
+		listCommits(ctx, reg, changes)
+
+		if err := writeChangelog(opts.Changelog, changes); err != nil {
+			return report, errors.Join(fmt.Errorf("error writing changelog: %v", err), clearTempDirs(deps))
 		}
 	}
 
-	return clearTempDirs(deps)
+	return report, clearTempDirs(deps)
 }
 
 func clearTempDirs(deps []Dependency) (err error) {
 	for i, dep := range deps {
-		if rmErr := os.RemoveAll(dep.Option.TempDir); err != nil {
-			err = errors.Join(fmt.Errorf("error removing temp dir '%v' for dependency %v, error: %v", dep.Option.TempDir, i, rmErr))
+		if rmErr := os.RemoveAll(dep.Option.TempDir); rmErr != nil {
+			err = errors.Join(err, fmt.Errorf("error removing temp dir '%v' for dependency %v, error: %v", dep.Option.TempDir, i, rmErr))
 		}
 	}
 
@@ -140,6 +298,10 @@ func findFiles(root string) ([]string, error) {
 	var res []string
 
 	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
 		if !d.IsDir() {
 			rel, _ := filepath.Rel(root, path)
 			res = append(res, rel)
@@ -149,14 +311,19 @@ func findFiles(root string) ([]string, error) {
 	})
 
 	if err != nil {
-		return nil, fmt.Errorf("error walking directory: %v", err)
+		return nil, fmt.Errorf("error walking directory: %w", err)
 	}
 
 	return res, nil
 }
 
-func copyToTarget(deps []Dependency, results []CopyResult, dryRun bool) error {
+// copyToTarget copies the files of all dependencies from their temp directories to their
+// targets. Nothing is written outside of root, the directory containing pasta.yaml. In a dry
+// run, what would be copied is written to out instead.
+func copyToTarget(tx *transaction, out io.Writer, deps []Dependency, results []CopyResult, dryRun, keepDirs bool, root string) error {
 	dep2Paths := make([][]string, len(deps))
+	// paths relative to the target directory, by the index of the path in dep2Paths
+	dep2Rewritten := make([][]string, len(deps))
 
 	// retreive all paths, since they're used multiple times
 	for i, dep := range deps {
@@ -165,24 +332,37 @@ func copyToTarget(deps []Dependency, results []CopyResult, dryRun bool) error {
 			return fmt.Errorf("error listing files in temp directory %v: %v", dep.Option.TempDir, err)
 		}
 		dep2Paths[i] = files
+
+		dep2Rewritten[i], err = rewritePaths(dep, files)
+		if err != nil {
+			return fmt.Errorf("dependency %v: %v", dep.Option.URL, err)
+		}
 	}
 
 	// give dryRun output before checking for path uniqueness, makes debugging easier
 	if dryRun {
 		for i, dep := range deps {
-			fmt.Printf("Dependency %v:\n", dep.Option.URL)
+			fmt.Fprintf(out, "Dependency %v:\n", dep.Option.URL)
 
 			if results[i].Err != nil {
-				fmt.Printf(" Would output:\n")
-				fmt.Println("   Error: ", strings.Join(strings.Split(results[i].Err.Error(), "\n"), "\n   "))
+				fmt.Fprintf(out, " Would output:\n")
+				fmt.Fprintln(out, "   Error: ", strings.Join(strings.Split(results[i].Err.Error(), "\n"), "\n   "))
 				continue
 			}
 			// else, print output & message
-			fmt.Printf("  Would copy to %v:\n", dep.Target)
-			for _, path := range dep2Paths[i] {
-				fmt.Println("    *", path)
+			if dep.Option.Locked != "" {
+				fmt.Fprintf(out, "  Locked to %v\n", dep.Option.Locked)
+			}
+			fmt.Fprintf(out, "  Would copy to %v:\n", dep.Target)
+			for j, path := range dep2Paths[i] {
+				if rewritten := dep2Rewritten[i][j]; rewritten != filepath.ToSlash(path) {
+					fmt.Fprintf(out, "    * %v (from %v)\n", rewritten, path)
+					continue
+				}
+
+				fmt.Fprintln(out, "    *", path)
 			}
-			fmt.Printf("  Would output:\n")
+			fmt.Fprintf(out, "  Would output:\n")
 
 			ci, err := yaml.Marshal(results[i].CopierInfo)
 
@@ -190,32 +370,127 @@ func copyToTarget(deps []Dependency, results []CopyResult, dryRun bool) error {
 				return err
 			}
 
-			fmt.Println("   ", strings.Replace(string(ci), "\n", "\n    ", -1))
+			fmt.Fprintln(out, "   ", strings.Replace(string(ci), "\n", "\n    ", -1))
 
-			fmt.Println()
+			fmt.Fprintln(out)
 		}
 
-		if err := assertPathsUnique(dep2Paths); err != nil {
-			fmt.Printf("Wouldn't copy: %v\n", err)
+		if err := assertPathsUnique(targetPaths(deps, dep2Rewritten)); err != nil {
+			fmt.Fprintf(out, "Wouldn't copy: %v\n", err)
 		}
 		return nil
 	}
 
-	if err := assertPathsUnique(dep2Paths); err != nil {
+	if err := assertPathsUnique(targetPaths(deps, dep2Rewritten)); err != nil {
 		return fmt.Errorf("target paths are not unique: %v", err)
 	}
 
+	// the working tree is never written outside of root, or through symlinks
+	repo := utils.Root{Dir: root}
+
+	// cleared target directories are replaced as a whole, all other files one by one
+	clearedDirs := make(map[string][]int)
+	var dirs []string
+	var single []int
+
 	for i, dep := range deps {
+		// never promote the temp dir of a failed dependency, it might be incomplete
+		if results[i].Err != nil {
+			continue
+		}
 
-		for _, p := range dep2Paths[i] {
+		if !dep.Option.ClearTarget || keepDirs {
+			single = append(single, i)
+			continue
+		}
+
+		if _, err := repo.Path(resultFor(dep, root).To); err != nil {
+			return fmt.Errorf("can't replace directory %v: %w", dep.Target, err)
+		}
+
+		if len(clearedDirs[dep.Target]) == 0 {
+			dirs = append(dirs, dep.Target)
+		}
+		clearedDirs[dep.Target] = append(clearedDirs[dep.Target], i)
+	}
+
+	// replace outer directories first, so they don't discard the content of inner ones
+	sort.SliceStable(dirs, func(a, b int) bool {
+		return strings.Count(dirs[a], "/") < strings.Count(dirs[b], "/")
+	})
+
+	for _, dir := range dirs {
+		err := tx.replaceDir(dir, func(stage string) error {
+			staged := utils.Root{Dir: stage}
+
+			for _, i := range clearedDirs[dir] {
+				for j, p := range dep2Paths[i] {
+					src := path.Join(deps[i].Option.TempDir, p)
+
+					link, isLink, err := readSymlink(src)
+					if err != nil {
+						return err
+					}
+
+					if isLink {
+						err = staged.Symlink(link, dep2Rewritten[i][j])
+					} else {
+						err = staged.CopyFile(src, dep2Rewritten[i][j])
+					}
+
+					if err != nil {
+						return fmt.Errorf("error copying file %v: %w", src, err)
+					}
+				}
+			}
+
+			return nil
+		})
+
+		if err != nil {
+			return fmt.Errorf("error replacing directory %v: %v", dir, err)
+		}
+	}
+
+	// single files are written after all directories were replaced, which would otherwise
+	// discard files written into them
+	for _, i := range single {
+		dep := deps[i]
+		to := resultFor(dep, root).To
+
+		for j, p := range dep2Paths[i] {
 			src := path.Join(dep.Option.TempDir, p)
-			tgt := path.Join(dep.Target, p)
 
-			// We copy the file (this also created the target directory) an os.Rename() would fail
-			// if the source and target are on different devices/mounts. As we target only small
-			// files, this should be fine.
-			if err := utils.CopyFile(src, tgt); err != nil {
-				fmt.Printf("Error copying file %v: %v", src, err)
+			// files are staged and renamed, so a symlink at dst is replaced, not written through
+			dst, err := repo.Replace(path.Join(to, dep2Rewritten[i][j]))
+			if err != nil {
+				return fmt.Errorf("can't copy file %v: %w", p, err)
+			}
+
+			link, isLink, err := readSymlink(src)
+			if err != nil {
+				return err
+			}
+
+			// symlinks must stay inside of the target, even after renaming them
+			if isLink {
+				if err := (utils.Root{Dir: dep.Target}).CheckSymlink(dep2Rewritten[i][j], link); err != nil {
+					return fmt.Errorf("can't copy symlink %v: %w", p, err)
+				}
+			}
+
+			// We copy the file instead of using os.Rename(), which would fail if the source and
+			// target are on different devices/mounts. As we target only small files, this should be fine.
+			err = tx.put(dst, func(stage string) error {
+				if isLink {
+					return os.Symlink(filepath.FromSlash(utils.CleanLink(link)), stage)
+				}
+
+				return utils.CopyFile(src, stage)
+			})
+
+			if err != nil {
+				return fmt.Errorf("error copying file %v: %v", src, err)
 			}
 		}
 	}
@@ -223,17 +498,85 @@ func copyToTarget(deps []Dependency, results []CopyResult, dryRun bool) error {
 	return nil
 }
 
+// readSymlink returns the target of the symlink p, and false if p is no symlink.
+func readSymlink(p string) (string, bool, error) {
+	info, err := os.Lstat(p)
+	if err != nil {
+		return "", false, fmt.Errorf("error reading %v: %w", p, err)
+	}
+
+	if info.Mode()&fs.ModeSymlink == 0 {
+		return "", false, nil
+	}
+
+	target, err := os.Readlink(p)
+	if err != nil {
+		return "", false, fmt.Errorf("error reading symlink %v: %w", p, err)
+	}
+
+	return target, true, nil
+}
+
+// rollback undoes all changes of tx, and returns err joined with any error during rollback.
+func rollback(tx *transaction, err error) error {
+	if rbErr := tx.rollback(); rbErr != nil {
+		return errors.Join(err, fmt.Errorf("error restoring previous state: %w", rbErr))
+	}
+
+	return err
+}
+
+// targetPaths returns the paths the files of each dependency are copied to.
+func targetPaths(deps []Dependency, dep2Paths [][]string) [][]string {
+	targets := make([][]string, len(deps))
+
+	for i, dep := range deps {
+		for _, p := range dep2Paths[i] {
+			targets[i] = append(targets[i], path.Join(dep.Target, p))
+		}
+	}
+
+	return targets
+}
+
 // for a list [dependency-index][]paths, returns weather all paths are unique
 func assertPathsUnique(dep2Paths [][]string) error {
-	// make sure only unique paths exist
-	pathSet := make(map[string]bool)
-	for _, paths := range dep2Paths {
+	// make sure only unique paths exist, maps each path to the dependency copying it
+	pathSet := make(map[string]int)
+	for i, paths := range dep2Paths {
 		for _, path := range paths {
-			if pathSet[path] {
+			if j, ok := pathSet[path]; ok {
+				if i == j {
+					return fmt.Errorf("two files of the same dependency would be copied to '%v', check its rename and flatten options", path)
+				}
+
 				return fmt.Errorf("two dependencies would copy to same file '%v'", path)
 			}
-			pathSet[path] = true
+			pathSet[path] = i
 		}
 	}
 	return nil
 }
+
+// rewritePaths returns the paths files are copied to, relative to the target directory of
+// dep, given their paths relative to its temp directory.
+func rewritePaths(dep Dependency, files []string) ([]string, error) {
+	rewritten := make([]string, len(files))
+
+	for i, p := range files {
+		p = filepath.ToSlash(p)
+
+		if dep.Rewrite != nil {
+			p = path.Clean(dep.Rewrite(p))
+
+			// renamed files must stay inside of the target directory
+			if p == "." || !filepath.IsLocal(filepath.FromSlash(p)) {
+				return nil, fmt.Errorf("file %v is renamed to %q, which is outside of the target directory", files[i], p)
+			}
+		}
+
+		rewritten[i] = p
+	}
+
+	return rewritten, nil
+}
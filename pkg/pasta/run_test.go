@@ -0,0 +1,439 @@
+package pasta
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/audiotool/pasta/pkg/copier"
+	"github.com/audiotool/pasta/pkg/utils"
+)
+
+// fakeCopier resolves every dependency to head, and writes the files of the
+// resolved reference. Files whose content starts with "-> " are symlinks to the rest
+// of it, files ending in .sh are executable.
+type fakeCopier struct {
+	head  string
+	files map[string]map[string]string
+}
+
+func (*fakeCopier) Matches(url string) bool {
+	return url == "fake://repo"
+}
+
+func (c *fakeCopier) Copy(ctx context.Context, config copier.CopyConfig) (any, error) {
+	ref := config.Locked
+	if ref == "" {
+		ref = c.head
+	}
+
+	for p, content := range c.files[ref] {
+		if err := os.MkdirAll(path.Dir(path.Join(config.TempDir, p)), os.ModePerm); err != nil {
+			return nil, err
+		}
+
+		if target, ok := strings.CutPrefix(content, "-> "); ok {
+			if err := os.Symlink(target, path.Join(config.TempDir, p)); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		perm := os.FileMode(0644)
+		if strings.HasSuffix(p, ".sh") {
+			perm = 0755
+		}
+
+		if err := os.WriteFile(path.Join(config.TempDir, p), []byte(content), perm); err != nil {
+			return nil, err
+		}
+	}
+
+	return &copier.SourceInfo{Reference: ref}, nil
+}
+
+// registryOf returns a registry containing only cs.
+func registryOf(cs ...copier.Copier) *Registry {
+	reg := &Registry{}
+	for _, c := range cs {
+		reg.Register(c)
+	}
+
+	return reg
+}
+
+func fakeDeps(t *testing.T, root string) []Dependency {
+	tmp, err := os.MkdirTemp("", "pasta-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return []Dependency{{
+		Option: copier.CopyConfig{
+			URL:         "fake://repo",
+			Keep:        func(string) bool { return true },
+			Options:     map[string]string{"ref": "main"},
+			TempDir:     tmp,
+			ClearTarget: true,
+		},
+		Target: path.Join(root, "out"),
+	}}
+}
+
+func readFile(t *testing.T, p string) string {
+	content, err := os.ReadFile(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return string(content)
+}
+
+// failingCopier writes some files, then fails.
+type failingCopier struct{}
+
+func (*failingCopier) Matches(url string) bool {
+	return url == "fake://failing"
+}
+
+func (*failingCopier) Copy(ctx context.Context, config copier.CopyConfig) (any, error) {
+	if err := os.WriteFile(path.Join(config.TempDir, "partial.txt"), []byte("partial"), 0644); err != nil {
+		return nil, err
+	}
+
+	return nil, errors.New("error fetching file b.txt")
+}
+
+func TestRunFailedDependency(t *testing.T) {
+	root := t.TempDir()
+	pastaFile := path.Join(root, "pasta.yaml")
+
+	reg := registryOf(&failingCopier{})
+
+	out := path.Join(root, "out")
+	if err := os.MkdirAll(out, os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path.Join(out, "old.txt"), []byte("old"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	deps := fakeDeps(t, root)
+	deps[0].Option.URL = "fake://failing"
+
+	report, err := Run(context.Background(), Options{Registry: reg, Deps: deps, PastaFile: pastaFile})
+	if err == nil {
+		t.Fatalf("expected error")
+	}
+
+	if report == nil || len(report.Deps) != 1 || report.Deps[0].Err == nil || report.Deps[0].URL != "fake://failing" {
+		t.Errorf("Run() report = %+v, expected the error of the dependency", report)
+	}
+
+	if got := readFile(t, path.Join(out, "old.txt")); got != "old" {
+		t.Errorf("target was modified: old.txt = %q", got)
+	}
+
+	if _, err := os.Stat(path.Join(out, "partial.txt")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("partial file of failed dependency was copied to target")
+	}
+
+	if _, err := os.Stat(path.Join(root, ResultFile)); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("%v was written for failed run", ResultFile)
+	}
+}
+
+func TestRunReport(t *testing.T) {
+	root := t.TempDir()
+	pastaFile := path.Join(root, "pasta.yaml")
+
+	c := &fakeCopier{
+		head: "sha1",
+		files: map[string]map[string]string{
+			"sha1": {"a.txt": "a", "b.txt": "b"},
+			"sha2": {"a.txt": "a2"},
+		},
+	}
+	reg := registryOf(c)
+
+	deps := fakeDeps(t, root)
+	deps[0].Option.ClearTarget = false
+
+	var output strings.Builder
+
+	report, err := Run(context.Background(), Options{Registry: reg, Deps: deps, PastaFile: pastaFile, Output: &output})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := DepReport{
+		URL:        "fake://repo",
+		Target:     "out",
+		Files:      map[string]string{},
+		SourceInfo: &copier.SourceInfo{Reference: "sha1"},
+	}
+
+	for _, p := range []string{"out/a.txt", "out/b.txt"} {
+		if want.Files[p], err = utils.HashFile(path.Join(root, p)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if len(report.Deps) != 1 || !reflect.DeepEqual(report.Deps[0], want) {
+		t.Errorf("Run() report = %+v, expected %+v", report.Deps, want)
+	}
+
+	if !strings.Contains(output.String(), "Copied files from fake://repo") {
+		t.Errorf("output = %q, expected progress", output.String())
+	}
+
+	// a dry run reports what would happen to output, without changing anything
+	c.head = "sha2"
+	output.Reset()
+
+	deps = fakeDeps(t, root)
+	deps[0].Option.ClearTarget = false
+	deps[0].Update = true
+
+	report, err = Run(context.Background(), Options{Registry: reg, Deps: deps, PastaFile: pastaFile, DryRun: true, Output: &output})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(report.Removed, []string{"out/b.txt"}) || report.Deps[0].SourceInfo.(*copier.SourceInfo).Reference != "sha2" {
+		t.Errorf("Run() report = %+v, expected out/b.txt to be removed at sha2", report)
+	}
+
+	if !strings.Contains(output.String(), "Would remove out/b.txt") {
+		t.Errorf("output = %q, expected removal of out/b.txt", output.String())
+	}
+
+	if got := readFile(t, path.Join(root, "out", "b.txt")); got != "b" {
+		t.Errorf("dry run changed out/b.txt to %q", got)
+	}
+}
+
+func TestRunRename(t *testing.T) {
+	root := t.TempDir()
+	pastaFile := path.Join(root, "pasta.yaml")
+
+	reg := registryOf(&fakeCopier{
+		head: "sha1",
+		files: map[string]map[string]string{
+			"sha1": {"v1/a.proto": "a", "v1/sub/b.proto": "b", "README.md": "readme"},
+		},
+	})
+
+	rewrite, err := RewriteFunc([]string{`^v1/(.*)\.proto$ -> api/$1.proto`}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	deps := fakeDeps(t, root)
+	deps[0].Rewrite = rewrite
+
+	if _, err := Run(context.Background(), Options{Registry: reg, Deps: deps, PastaFile: pastaFile}); err != nil {
+		t.Fatal(err)
+	}
+
+	for p, want := range map[string]string{"out/api/a.proto": "a", "out/api/sub/b.proto": "b", "out/README.md": "readme"} {
+		if got := readFile(t, path.Join(root, p)); got != want {
+			t.Errorf("%v = %q, expected %q", p, got, want)
+		}
+	}
+
+	if _, err := os.Stat(path.Join(root, "out", "v1")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("files were copied to their original path")
+	}
+
+	res, err := readResult(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if res.Deps[0].Files["out/api/a.proto"] == "" {
+		t.Errorf("expected hash of renamed file in result, got %v", res.Deps[0].Files)
+	}
+}
+
+func TestRunRenameCollision(t *testing.T) {
+	reg := registryOf(&fakeCopier{
+		head: "sha1",
+		files: map[string]map[string]string{
+			"sha1": {"a/x.txt": "a", "b/x.txt": "b"},
+		},
+	})
+
+	flatten, _ := RewriteFunc(nil, true)
+	escape, _ := RewriteFunc([]string{"^(.*)$ -> ../$1"}, false)
+
+	for name, tt := range map[string]struct {
+		rewrite func(string) string
+		wantErr string
+	}{
+		"flatten": {rewrite: flatten, wantErr: "two files of the same dependency would be copied to"},
+		"escape":  {rewrite: escape, wantErr: "outside of the target directory"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			root := t.TempDir()
+
+			deps := fakeDeps(t, root)
+			deps[0].Rewrite = tt.rewrite
+
+			_, err := Run(context.Background(), Options{Registry: reg, Deps: deps, PastaFile: path.Join(root, "pasta.yaml")})
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("Run() = %v, expected error containing %q", err, tt.wantErr)
+			}
+
+			if _, err := os.Stat(path.Join(root, "out")); !errors.Is(err, os.ErrNotExist) {
+				t.Errorf("files were copied despite the error")
+			}
+		})
+	}
+}
+
+func TestRunSymlinkedTarget(t *testing.T) {
+	reg := registryOf(&fakeCopier{
+		head:  "sha1",
+		files: map[string]map[string]string{"sha1": {"a.txt": "evil"}},
+	})
+
+	for name, tt := range map[string]struct {
+		// link is created in root, pointing to a file or directory outside of root
+		link        string
+		clearTarget bool
+		wantErr     bool
+	}{
+		"cleared target": {link: "out", clearTarget: true, wantErr: true},
+		"target":         {link: "out", wantErr: true},
+		"file in target": {link: "out/a.txt", wantErr: true},
+		// a cleared target is replaced as a whole, which removes the symlink instead
+		"file in cleared target": {link: "out/a.txt", clearTarget: true},
+	} {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			root := path.Join(dir, "repo")
+			outside := path.Join(dir, "outside")
+
+			if err := os.MkdirAll(outside, os.ModePerm); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.MkdirAll(path.Dir(path.Join(root, tt.link)), os.ModePerm); err != nil {
+				t.Fatal(err)
+			}
+
+			victim := outside
+			if path.Base(tt.link) == "a.txt" {
+				victim = path.Join(outside, "a.txt")
+				if err := os.WriteFile(victim, []byte("victim"), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if err := os.Symlink(victim, path.Join(root, tt.link)); err != nil {
+				t.Fatal(err)
+			}
+
+			deps := fakeDeps(t, root)
+			deps[0].Option.ClearTarget = tt.clearTarget
+
+			_, err := Run(context.Background(), Options{Registry: reg, Deps: deps, PastaFile: path.Join(root, "pasta.yaml")})
+			if tt.wantErr && (err == nil || !strings.Contains(err.Error(), "symlink")) {
+				t.Errorf("Run() = %v, expected error about symlink", err)
+			} else if !tt.wantErr && err != nil {
+				t.Errorf("Run() = %v", err)
+			}
+
+			if content, _ := os.ReadFile(path.Join(outside, "a.txt")); string(content) == "evil" {
+				t.Errorf("file outside of root was written")
+			}
+		})
+	}
+}
+
+func TestRunSymlinks(t *testing.T) {
+	reg := registryOf(&fakeCopier{
+		head: "sha1",
+		files: map[string]map[string]string{"sha1": {
+			"a.txt":      "a",
+			"run.sh":     "#!/bin/sh",
+			"link.txt":   "-> a.txt",
+			"sub/up.txt": "-> ../a.txt",
+		}},
+	})
+
+	for name, tt := range map[string]struct {
+		clearTarget bool
+		// flatten moves sub/up.txt next to a.txt, where its target leads outside of the target
+		flatten bool
+		wantErr bool
+	}{
+		"cleared target":           {clearTarget: true},
+		"target":                   {},
+		"flattened cleared target": {clearTarget: true, flatten: true, wantErr: true},
+		"flattened target":         {flatten: true, wantErr: true},
+	} {
+		t.Run(name, func(t *testing.T) {
+			root := t.TempDir()
+
+			// the second run replaces the symlinks of the first one
+			for i := 0; i < 2; i++ {
+				deps := fakeDeps(t, root)
+				deps[0].Option.ClearTarget = tt.clearTarget
+				if tt.flatten {
+					deps[0].Rewrite = path.Base
+				}
+
+				_, err := Run(context.Background(), Options{Registry: reg, Deps: deps, PastaFile: path.Join(root, "pasta.yaml")})
+				if tt.wantErr {
+					if err == nil || !strings.Contains(err.Error(), "symlink") {
+						t.Fatalf("Run() = %v, expected error about symlink", err)
+					}
+					return
+				}
+
+				if err != nil {
+					t.Fatalf("Run() = %v", err)
+				}
+			}
+
+			for p, want := range map[string]string{"link.txt": "a.txt", "sub/up.txt": "../a.txt"} {
+				if target, err := os.Readlink(path.Join(root, "out", p)); err != nil || target != want {
+					t.Errorf("%v links to %q, %v, expected %q", p, target, err, want)
+				}
+			}
+
+			if content := readFile(t, path.Join(root, "out", "sub", "up.txt")); content != "a" {
+				t.Errorf("sub/up.txt resolves to %q, expected %q", content, "a")
+			}
+
+			if info, err := os.Stat(path.Join(root, "out", "run.sh")); err != nil || info.Mode()&0o100 == 0 {
+				t.Errorf("run.sh isn't executable: %v, %v", info, err)
+			}
+		})
+	}
+}
+
+func TestClearTempDirs(t *testing.T) {
+	removed := t.TempDir()
+
+	// paths containing NUL can't be removed
+	deps := []Dependency{
+		{Option: copier.CopyConfig{TempDir: "first\x00"}},
+		{Option: copier.CopyConfig{TempDir: removed}},
+		{Option: copier.CopyConfig{TempDir: "third\x00"}},
+	}
+
+	err := clearTempDirs(deps)
+	if err == nil || !strings.Contains(err.Error(), "dependency 0") || !strings.Contains(err.Error(), "dependency 2") {
+		t.Errorf("clearTempDirs() = %v, expected errors of dependencies 0 and 2", err)
+	}
+
+	if _, err := os.Stat(removed); !os.IsNotExist(err) {
+		t.Errorf("temp dir wasn't removed: %v", err)
+	}
+}
@@ -0,0 +1,126 @@
+package pasta
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/audiotool/pasta/pkg/utils"
+)
+
+// staleFile is a file pasta copied in a previous run, that no dependency produces anymore.
+type staleFile struct {
+	// path relative to the directory containing pasta.yaml
+	path string
+	// modified is true if the file was changed since pasta copied it
+	modified bool
+}
+
+// findStale returns the files recorded in prev that none of the results contain anymore,
+// including the files of dependencies that were removed from pasta.yaml. Only files that
+// still exist are returned.
+func findStale(prev *pastaResults, results []CopyResult, root string) ([]staleFile, error) {
+	produced := make(map[string]bool)
+	for _, res := range results {
+		for p := range res.Files {
+			produced[p] = true
+		}
+	}
+
+	recorded := make(map[string]string)
+	for _, res := range prev.Deps {
+		for p, hash := range res.Files {
+			recorded[p] = hash
+		}
+	}
+
+	var stale []staleFile
+
+	for p, hash := range recorded {
+		if produced[p] {
+			continue
+		}
+
+		// never touch anything outside of root, even if pasta.result.yaml was edited
+		if !filepath.IsLocal(filepath.FromSlash(p)) {
+			return nil, fmt.Errorf("invalid path %v in %v", p, ResultFile)
+		}
+
+		abs := filepath.Join(root, filepath.FromSlash(p))
+
+		info, err := os.Lstat(abs)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("error reading %v: %v", p, err)
+		}
+
+		// symlinks are recreated from upstream, anything else wasn't copied by pasta
+		if !info.Mode().IsRegular() && info.Mode()&fs.ModeSymlink == 0 {
+			continue
+		}
+
+		actual, err := utils.HashFile(abs)
+		if err != nil {
+			return nil, fmt.Errorf("error hashing %v: %v", p, err)
+		}
+
+		stale = append(stale, staleFile{path: p, modified: actual != hash})
+	}
+
+	sort.Slice(stale, func(i, j int) bool { return stale[i].path < stale[j].path })
+
+	return stale, nil
+}
+
+// removeStale removes all unmodified stale files. Modified ones are kept, since they
+// might contain changes that would be lost. What happens to each file is written to out.
+// Returns the directories files were removed from.
+func removeStale(tx *transaction, out io.Writer, stale []staleFile, root string, dryRun bool) ([]string, error) {
+	var dirs []string
+
+	for _, f := range stale {
+		if f.modified {
+			fmt.Fprintf(out, "Not removing %v: no dependency produces it anymore, but it was modified since it was copied\n", f.path)
+			continue
+		}
+
+		if dryRun {
+			fmt.Fprintf(out, "Would remove %v: no dependency produces it anymore\n", f.path)
+			continue
+		}
+
+		// parents might have been replaced by symlinks since the file was copied
+		abs, err := utils.Root{Dir: root}.Replace(f.path)
+		if err != nil {
+			return nil, fmt.Errorf("error removing %v: %w", f.path, err)
+		}
+
+		if err := tx.backup(abs); err != nil {
+			return nil, fmt.Errorf("error removing %v: %v", f.path, err)
+		}
+
+		fmt.Fprintf(out, "Removed %v: no dependency produces it anymore\n", f.path)
+		dirs = append(dirs, filepath.Dir(abs))
+	}
+
+	return dirs, nil
+}
+
+// removeEmptyDirs removes each of dirs and its parents, as long as they are empty, up to root.
+func removeEmptyDirs(dirs []string, root string) {
+	for _, dir := range dirs {
+		for ; dir != root && filepath.Dir(dir) != dir; dir = filepath.Dir(dir) {
+			// fails for non-empty directories
+			if err := os.Remove(dir); err != nil {
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package pasta
+
+import (
+	"context"
+	"os"
+	"path"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRunRemovesStaleFiles(t *testing.T) {
+	root := t.TempDir()
+	pastaFile := path.Join(root, "pasta.yaml")
+	ctx := context.Background()
+
+	writeTree(t, root, map[string]string{"mine.txt": "mine"})
+
+	fake := &fakeCopier{
+		head: "sha1",
+		files: map[string]map[string]string{
+			"sha1": {"a.txt": "a", "b.txt": "b", "c.txt": "c"},
+			"sha2": {"a.txt": "a2"},
+		},
+	}
+	reg := registryOf(fake)
+
+	// copies files next to pasta.yaml, like `to: .` with `files`
+	filesDeps := func() []Dependency {
+		deps := fakeDeps(t, root)
+		deps[0].Target = root
+		deps[0].Option.ClearTarget = false
+		return deps
+	}
+
+	if _, err := Run(ctx, Options{Registry: reg, Deps: filesDeps(), PastaFile: pastaFile}); err != nil {
+		t.Fatalf("first run: %v", err)
+	}
+
+	writeTree(t, root, map[string]string{"c.txt": "edited"})
+
+	fake.head = "sha2"
+	deps := filesDeps()
+	deps[0].Update = true
+
+	if _, err := Run(ctx, Options{Registry: reg, Deps: deps, PastaFile: pastaFile}); err != nil {
+		t.Fatalf("update run: %v", err)
+	}
+
+	tree := listTree(t, root)
+	delete(tree, ResultFile)
+
+	// b.txt is removed, the edited c.txt and unrelated mine.txt are kept
+	want := map[string]string{"a.txt": "a2", "c.txt": "edited", "mine.txt": "mine"}
+	if !reflect.DeepEqual(tree, want) {
+		t.Errorf("after update tree = %v, expected %v", tree, want)
+	}
+}
+
+func TestRunRemovesFilesOfRemovedDependency(t *testing.T) {
+	root := t.TempDir()
+	pastaFile := path.Join(root, "pasta.yaml")
+	ctx := context.Background()
+
+	writeTree(t, root, map[string]string{"lib/mine.txt": "mine"})
+
+	reg := registryOf(&fakeCopier{
+		head:  "sha1",
+		files: map[string]map[string]string{"sha1": {"a.txt": "a"}},
+	})
+
+	deps := fakeDeps(t, root)
+	deps[0].Target = path.Join(root, "lib", "sub", "dir")
+
+	if _, err := Run(ctx, Options{Registry: reg, Deps: deps, PastaFile: pastaFile}); err != nil {
+		t.Fatalf("first run: %v", err)
+	}
+
+	if _, err := Run(ctx, Options{Registry: reg, PastaFile: pastaFile}); err != nil {
+		t.Fatalf("run without dependencies: %v", err)
+	}
+
+	tree := listTree(t, root)
+	delete(tree, ResultFile)
+
+	if want := map[string]string{"lib/mine.txt": "mine"}; !reflect.DeepEqual(tree, want) {
+		t.Errorf("tree = %v, expected %v", tree, want)
+	}
+
+	if _, err := findFiles(path.Join(root, "lib", "sub")); err == nil {
+		t.Errorf("empty directories of removed dependency weren't removed")
+	}
+}
+
+func TestRunStaleFileBehindSymlink(t *testing.T) {
+	dir := t.TempDir()
+	root := path.Join(dir, "repo")
+	outside := path.Join(dir, "outside")
+	pastaFile := path.Join(root, "pasta.yaml")
+	ctx := context.Background()
+
+	reg := registryOf(&fakeCopier{
+		head:  "sha1",
+		files: map[string]map[string]string{"sha1": {"sub/a.txt": "a"}},
+	})
+
+	deps := fakeDeps(t, root)
+	deps[0].Target = path.Join(root, "lib")
+	deps[0].Option.ClearTarget = false
+
+	if _, err := Run(ctx, Options{Registry: reg, Deps: deps, PastaFile: pastaFile}); err != nil {
+		t.Fatalf("first run: %v", err)
+	}
+
+	// the parent of the copied file is replaced by a symlink to an identical file outside of root
+	writeTree(t, outside, map[string]string{"a.txt": "a"})
+
+	if err := os.RemoveAll(path.Join(root, "lib", "sub")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.Symlink(outside, path.Join(root, "lib", "sub")); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := Run(ctx, Options{Registry: reg, PastaFile: pastaFile})
+	if err == nil || !strings.Contains(err.Error(), "symlink") {
+		t.Errorf("Run() = %v, expected error about symlink", err)
+	}
+
+	if got := readFile(t, path.Join(outside, "a.txt")); got != "a" {
+		t.Errorf("file outside of root = %q, expected it kept", got)
+	}
+}
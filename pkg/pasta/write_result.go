@@ -2,6 +2,7 @@ package pasta
 
 import (
 	"fmt"
+	"io"
 	"os"
 	"path"
 
@@ -9,40 +10,39 @@ import (
 )
 
 type yamlResult struct {
-	URL        string `yaml:"url"`
-	SourceInfo any    `yaml:"source_info,omitempty"`
-	Skipped    bool   `yaml:"skipped,omitempty"`
-	Error      string `yaml:"error,omitempty"`
+	URL        string            `yaml:"url"`
+	From       string            `yaml:"from,omitempty"`
+	To         string            `yaml:"to,omitempty"`
+	Ref        string            `yaml:"ref,omitempty"`
+	SourceInfo any               `yaml:"source_info,omitempty"`
+	Files      map[string]string `yaml:"files,omitempty"`
+	Skipped    bool              `yaml:"skipped,omitempty"`
+	Error      string            `yaml:"error,omitempty"`
 }
 
 type pastaResults struct {
 	Deps []yamlResult `yaml:"deps"`
 }
 
-func writeResult(deps []Dependency, copyResults []CopyResult, parentDir string) error {
+func writeResult(tx *transaction, out io.Writer, deps []Dependency, copyResults []CopyResult, parentDir string) error {
 	// convert pasta.CopyResuts to yamlResults
 	var results []yamlResult
 
 	for i, result := range copyResults {
 		url := deps[i].Option.URL
 
-		var res yamlResult
+		res := resultFor(deps[i], parentDir)
 
 		if result.Err != nil {
-			fmt.Printf("error during copy of dependency %v: %v\n", i, result.Err)
+			fmt.Fprintf(out, "error during copy of dependency %v: %v\n", i, result.Err)
 
-			res = yamlResult{
-				Error:   fmt.Sprintf("error during copy: %v", result.Err),
-				Skipped: true,
-				URL:     url,
-			}
+			res.Error = fmt.Sprintf("error during copy: %v", result.Err)
+			res.Skipped = true
 		} else {
-			fmt.Printf("Copied files from %v\n", url)
+			fmt.Fprintf(out, "Copied files from %v\n", url)
 
-			res = yamlResult{
-				URL:        url,
-				SourceInfo: result.CopierInfo,
-			}
+			res.SourceInfo = result.CopierInfo
+			res.Files = result.Files
 		}
 
 		results = append(results, res)
@@ -54,7 +54,9 @@ func writeResult(deps []Dependency, copyResults []CopyResult, parentDir string)
 		return fmt.Errorf("error at marshaling pasta.result.yaml: %v", err)
 	}
 
-	err = os.WriteFile(path.Join(parentDir, "pasta.result.yaml"), rescontent, 0644)
+	err = tx.put(path.Join(parentDir, ResultFile), func(stage string) error {
+		return os.WriteFile(stage, rescontent, 0644)
+	})
 
 	if err != nil {
 		return fmt.Errorf("error saving pasta.result.yaml: %v", err)
@@ -0,0 +1,270 @@
+// Package plugin runs copiers as executables in other processes, so they can be written in
+// any language and added without recompiling pasta.
+//
+// A plugin is an executable named `pasta-copier-<name>`. Pasta runs it once per request,
+// writes a Request as JSON to its stdin, and reads a Response as JSON from its stdout:
+//
+//   - {"type": "matches", "url": "..."} asks whether the plugin copies the url, answered
+//     with {"matches": true} or {"matches": false}
+//   - {"type": "copy", "config": {...}} asks the plugin to write the files of the dependency
+//     to config.temp_dir, by their paths relative to config.from, answered with
+//     {"source_info": {...}}, which is saved to pasta.result.yaml
+//
+// Plugins report failures with {"error": "..."}, adding "offline": true if they failed since
+// content wasn't available offline, or by exiting with a non-zero status and a message on
+// stderr. Whatever they write to stderr is shown in the error.
+package plugin
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/audiotool/pasta/pkg/copier"
+)
+
+// Prefix is the prefix of the names of plugin executables.
+const Prefix = "pasta-copier-"
+
+// Types of requests.
+const (
+	TypeMatches = "matches"
+	TypeCopy    = "copy"
+)
+
+// Request is written to the stdin of a plugin.
+type Request struct {
+	// Type is TypeMatches or TypeCopy
+	Type string `json:"type"`
+	// URL is the url to match, for TypeMatches
+	URL string `json:"url,omitempty"`
+	// Config is the dependency to copy, for TypeCopy
+	Config *Config `json:"config,omitempty"`
+}
+
+// Config is the serializable part of copier.CopyConfig. Rules replace its Keep function.
+type Config struct {
+	URL         string            `json:"url"`
+	From        string            `json:"from"`
+	Rules       copier.Rules      `json:"rules"`
+	Options     map[string]string `json:"options,omitempty"`
+	TempDir     string            `json:"temp_dir"`
+	Root        string            `json:"root"`
+	ClearTarget bool              `json:"clear_target"`
+	Locked      string            `json:"locked,omitempty"`
+	Offline     bool              `json:"offline"`
+	Symlinks    string            `json:"symlinks,omitempty"`
+}
+
+// Response is read from the stdout of a plugin.
+type Response struct {
+	// Matches answers TypeMatches
+	Matches bool `json:"matches,omitempty"`
+	// SourceInfo answers TypeCopy, and is saved to pasta.result.yaml. Its "reference" field
+	// locks the dependency, like the one of copier.SourceInfo.
+	SourceInfo any `json:"source_info,omitempty"`
+	// Error is set if the request failed
+	Error string `json:"error,omitempty"`
+	// Offline is true if the request failed since content wasn't available offline
+	Offline bool `json:"offline,omitempty"`
+}
+
+// Copier runs the plugin executable at Path.
+//
+// Plugins may ignore the rules of the config, files that shouldn't be kept are removed
+// from the temp directory after they copied.
+type Copier struct {
+	// Name is the name of the plugin, e.g. "s3" for pasta-copier-s3
+	Name string
+	// Path is the path of the executable
+	Path string
+
+	mu sync.Mutex
+	// matches memoizes the answers to TypeMatches by url
+	matches map[string]bool
+}
+
+// matchTimeout bounds how long a plugin may take to answer TypeMatches. Plugins are asked
+// while looking up the copier of every dependency, a hanging one would block all commands.
+var matchTimeout = 10 * time.Second
+
+// Matches asks the plugin whether it copies url. Plugins that fail to answer within
+// matchTimeout don't match.
+func (c *Copier) Matches(url string) bool {
+	c.mu.Lock()
+	matches, ok := c.matches[url]
+	c.mu.Unlock()
+
+	if ok {
+		return matches
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), matchTimeout)
+	defer cancel()
+
+	// the plugin runs without holding the lock, so other urls can be matched meanwhile
+	res, err := c.call(ctx, "", &Request{Type: TypeMatches, URL: url})
+	matches = err == nil && res.Matches
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.matches == nil {
+		c.matches = make(map[string]bool)
+	}
+	c.matches[url] = matches
+
+	return matches
+}
+
+func (c *Copier) Copy(ctx context.Context, config copier.CopyConfig) (any, error) {
+	res, err := c.call(ctx, config.Root, &Request{
+		Type: TypeCopy,
+		Config: &Config{
+			URL:         config.URL,
+			From:        config.From,
+			Rules:       config.Rules,
+			Options:     config.Options,
+			TempDir:     config.TempDir,
+			Root:        config.Root,
+			ClearTarget: config.ClearTarget,
+			Locked:      config.Locked,
+			Offline:     config.Offline,
+			Symlinks:    config.Symlinks,
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := prune(config); err != nil {
+		return nil, err
+	}
+
+	return res.SourceInfo, nil
+}
+
+// call runs the plugin inside dir with req on its stdin, and returns its response. dir is
+// the current directory if empty.
+func (c *Copier) call(ctx context.Context, dir string, req *Request) (*Response, error) {
+	in, err := json.Marshal(req)
+	if err != nil {
+		return nil, fmt.Errorf("error encoding request: %w", err)
+	}
+
+	var stdout, stderr bytes.Buffer
+
+	cmd := exec.CommandContext(ctx, c.Path)
+	cmd.Dir = dir
+	cmd.Stdin = bytes.NewReader(in)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	// children of the plugin might keep its output open after it was killed
+	cmd.WaitDelay = time.Second
+
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("plugin %v failed: %v: %v", c.Name, err, strings.TrimSpace(stderr.String()))
+	}
+
+	var res Response
+	if err := json.Unmarshal(stdout.Bytes(), &res); err != nil {
+		return nil, fmt.Errorf("plugin %v returned an invalid response: %w", c.Name, err)
+	}
+
+	switch {
+	case res.Error != "" && res.Offline:
+		return nil, fmt.Errorf("plugin %v: %v: %w", c.Name, res.Error, copier.ErrOffline)
+	case res.Error != "":
+		return nil, fmt.Errorf("plugin %v: %v", c.Name, res.Error)
+	}
+
+	return &res, nil
+}
+
+// prune removes the files the plugin wrote to the temp directory that shouldn't be kept,
+// and symlinks if they should be skipped.
+func prune(config copier.CopyConfig) error {
+	return filepath.WalkDir(config.TempDir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+
+		rel, err := filepath.Rel(config.TempDir, p)
+		if err != nil {
+			return err
+		}
+
+		link := d.Type()&fs.ModeSymlink != 0
+		keep := config.Keep == nil || config.Keep(filepath.ToSlash(rel))
+
+		if keep && !(link && config.Symlinks == copier.SymlinksSkip) {
+			return nil
+		}
+
+		if err := os.Remove(p); err != nil {
+			return fmt.Errorf("error removing %v: %w", rel, err)
+		}
+
+		return nil
+	})
+}
+
+// Find returns the plugin called name, which is the executable pasta-copier-<name> on the
+// search path pathList, formatted like $PATH.
+func Find(name, pathList string) (*Copier, error) {
+	for _, dir := range filepath.SplitList(pathList) {
+		p := filepath.Join(dir, Prefix+name)
+
+		if executable(p) {
+			return &Copier{Name: name, Path: p}, nil
+		}
+	}
+
+	return nil, fmt.Errorf("%v%v not found in search path", Prefix, name)
+}
+
+// Discover returns all plugins on the search path pathList, formatted like $PATH, ordered by
+// name. Like for commands, the first directory containing a plugin of a name wins.
+func Discover(pathList string) []*Copier {
+	found := make(map[string]bool)
+	var plugins []*Copier
+
+	for _, dir := range filepath.SplitList(pathList) {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			// search paths commonly contain directories that don't exist
+			continue
+		}
+
+		for _, entry := range entries {
+			name, ok := strings.CutPrefix(entry.Name(), Prefix)
+			if !ok || name == "" || found[name] || !executable(filepath.Join(dir, entry.Name())) {
+				continue
+			}
+
+			found[name] = true
+			plugins = append(plugins, &Copier{Name: name, Path: filepath.Join(dir, entry.Name())})
+		}
+	}
+
+	sort.Slice(plugins, func(a, b int) bool {
+		return plugins[a].Name < plugins[b].Name
+	})
+
+	return plugins
+}
+
+// executable returns true if p is a file, following symlinks, which is executable by anyone.
+func executable(p string) bool {
+	info, err := os.Stat(p)
+	return err == nil && info.Mode().IsRegular() && info.Mode().Perm()&0o111 != 0
+}
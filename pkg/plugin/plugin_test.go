@@ -0,0 +1,252 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/audiotool/pasta/pkg/copier"
+)
+
+// writeScript writes an executable shell script with body to dir, and returns its path.
+func writeScript(t *testing.T, dir, name, body string) string {
+	t.Helper()
+
+	p := filepath.Join(dir, name)
+	if err := os.WriteFile(p, []byte("#!/bin/sh\n"+body), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	return p
+}
+
+func TestMatches(t *testing.T) {
+	dir := t.TempDir()
+	calls := filepath.Join(dir, "calls")
+
+	c := &Copier{Name: "fake", Path: writeScript(t, dir, "pasta-copier-fake", `
+echo >> `+calls+`
+if grep -q '"url":"fake://'; then echo '{"matches": true}'; else echo '{"matches": false}'; fi
+`)}
+
+	for url, want := range map[string]bool{
+		"fake://protocol":        true,
+		"https://github.com/o/r": false,
+	} {
+		if got := c.Matches(url); got != want {
+			t.Errorf("Matches(%v) = %v, expected %v", url, got, want)
+		}
+	}
+
+	// answers are memoized
+	c.Matches("fake://protocol")
+
+	if content, _ := os.ReadFile(calls); strings.Count(string(content), "\n") != 2 {
+		t.Errorf("plugin was called %v times, expected 2", strings.Count(string(content), "\n"))
+	}
+
+	failing := &Copier{Name: "failing", Path: writeScript(t, dir, "pasta-copier-failing", "exit 1\n")}
+	if failing.Matches("fake://protocol") {
+		t.Errorf("failing plugin matches")
+	}
+}
+
+func TestMatchesTimeout(t *testing.T) {
+	defer func(timeout time.Duration) { matchTimeout = timeout }(matchTimeout)
+	matchTimeout = 100 * time.Millisecond
+
+	dir := t.TempDir()
+	c := &Copier{Name: "hanging", Path: writeScript(t, dir, "pasta-copier-hanging", "sleep 10\necho '{\"matches\": true}'\n")}
+
+	start := time.Now()
+	if c.Matches("fake://protocol") {
+		t.Errorf("hanging plugin matches")
+	}
+
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Matches() took %v, expected it to time out", elapsed)
+	}
+}
+
+func TestCopy(t *testing.T) {
+	dir := t.TempDir()
+	tempDir := t.TempDir()
+	request := filepath.Join(dir, "request")
+
+	// writes some files to the temp directory, ignoring the rules
+	c := &Copier{Name: "fake", Path: writeScript(t, dir, "pasta-copier-fake", `
+tee `+request+` > /dev/null
+out=$(sed -n 's/.*"temp_dir":"\([^"]*\)".*/\1/p' `+request+`)
+mkdir -p "$out/docs"
+echo a > "$out/a.proto"
+echo b > "$out/docs/b.md"
+ln -s a.proto "$out/link.proto"
+pwd > "$out/pwd.proto"
+echo '{"source_info": {"reference": "v1.2.0", "bucket": "protocols"}}'
+`)}
+
+	rules := copier.Rules{IncludeGlob: []string{"*.proto"}}
+	config := copier.CopyConfig{
+		URL:     "fake://protocols",
+		From:    "api/",
+		Keep:    func(p string) bool { return strings.HasSuffix(p, ".proto") },
+		Rules:   rules,
+		Options: map[string]string{"ref": "v1"},
+		TempDir: tempDir,
+		Root:    dir,
+		Locked:  "v1.2.0",
+	}
+
+	res, err := c.Copy(context.Background(), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := map[string]any{"reference": "v1.2.0", "bucket": "protocols"}; !reflect.DeepEqual(res, want) {
+		t.Errorf("Copy() = %v, expected %v", res, want)
+	}
+
+	content, err := os.ReadFile(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var req Request
+	if err := json.Unmarshal(content, &req); err != nil {
+		t.Fatal(err)
+	}
+
+	want := Config{
+		URL:     config.URL,
+		From:    config.From,
+		Rules:   rules,
+		Options: config.Options,
+		TempDir: tempDir,
+		Root:    dir,
+		Locked:  "v1.2.0",
+	}
+
+	if req.Type != TypeCopy || req.Config == nil || !reflect.DeepEqual(*req.Config, want) {
+		t.Errorf("request = %s, expected config %+v", content, want)
+	}
+
+	// files that shouldn't be kept are removed
+	if _, err := os.Stat(filepath.Join(tempDir, "docs", "b.md")); !os.IsNotExist(err) {
+		t.Errorf("docs/b.md wasn't removed: %v", err)
+	}
+
+	if target, err := os.Readlink(filepath.Join(tempDir, "link.proto")); err != nil || target != "a.proto" {
+		t.Errorf("link.proto = %v, %v, expected a symlink to a.proto", target, err)
+	}
+
+	// plugins run inside the directory containing pasta.yaml
+	if pwd, _ := os.ReadFile(filepath.Join(tempDir, "pwd.proto")); strings.TrimSpace(string(pwd)) != dir {
+		t.Errorf("plugin ran in %s, expected %v", pwd, dir)
+	}
+
+	// symlinks are removed if they should be skipped
+	config.Symlinks = copier.SymlinksSkip
+	config.TempDir = t.TempDir()
+
+	if _, err := c.Copy(context.Background(), config); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Lstat(filepath.Join(config.TempDir, "link.proto")); !os.IsNotExist(err) {
+		t.Errorf("link.proto wasn't skipped: %v", err)
+	}
+}
+
+func TestCopyErrors(t *testing.T) {
+	dir := t.TempDir()
+
+	tests := []struct {
+		name    string
+		body    string
+		want    string
+		offline bool
+	}{
+		{
+			name: "error",
+			body: `echo '{"error": "bucket not found"}'`,
+			want: "plugin fake: bucket not found",
+		},
+		{
+			name:    "offline",
+			body:    `echo '{"error": "not cached", "offline": true}'`,
+			want:    "plugin fake: not cached",
+			offline: true,
+		},
+		{
+			name: "exit status",
+			body: "echo 'permission denied' >&2\nexit 3",
+			want: "plugin fake failed: exit status 3: permission denied",
+		},
+		{
+			name: "invalid response",
+			body: "echo 'copied'",
+			want: "plugin fake returned an invalid response",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &Copier{Name: "fake", Path: writeScript(t, dir, tt.name, "cat > /dev/null\n"+tt.body+"\n")}
+
+			_, err := c.Copy(context.Background(), copier.CopyConfig{URL: "fake://protocols", TempDir: t.TempDir()})
+			if err == nil || !strings.HasPrefix(err.Error(), tt.want) {
+				t.Fatalf("Copy() error = %v, expected %v", err, tt.want)
+			}
+
+			if errors.Is(err, copier.ErrOffline) != tt.offline {
+				t.Errorf("Copy() error = %v, offline %v", err, tt.offline)
+			}
+		})
+	}
+}
+
+func TestDiscover(t *testing.T) {
+	first := t.TempDir()
+	second := t.TempDir()
+
+	writeScript(t, first, "pasta-copier-s3", "")
+	writeScript(t, second, "pasta-copier-s3", "")
+	writeScript(t, second, "pasta-copier-rsync", "")
+	writeScript(t, second, "pasta-copier-", "")
+	writeScript(t, second, "pasta", "")
+
+	if err := os.WriteFile(filepath.Join(second, "pasta-copier-readme"), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	pathList := strings.Join([]string{filepath.Join(first, "missing"), first, second}, string(filepath.ListSeparator))
+
+	var got []string
+	for _, c := range Discover(pathList) {
+		got = append(got, c.Name+"="+c.Path)
+	}
+
+	want := []string{
+		"rsync=" + filepath.Join(second, "pasta-copier-rsync"),
+		"s3=" + filepath.Join(first, "pasta-copier-s3"),
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Discover() = %v, expected %v", got, want)
+	}
+
+	c, err := Find("rsync", pathList)
+	if err != nil || c.Path != filepath.Join(second, "pasta-copier-rsync") {
+		t.Errorf("Find() = %v, %v, expected %v", c, err, want[0])
+	}
+
+	if _, err := Find("readme", pathList); err == nil {
+		t.Errorf("Find() found a plugin which isn't executable")
+	}
+}
@@ -0,0 +1,359 @@
+// Package semver parses semantic versions and version constraints, to resolve refs like
+// "^1.2.0" or ">=2, <3" to the newest matching tag of a repository.
+package semver
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Version is a semantic version, see https://semver.org. Build metadata is ignored.
+type Version struct {
+	Major, Minor, Patch uint64
+	// Prerelease identifiers, e.g. "rc.1", empty for releases
+	Prerelease string
+}
+
+var errInvalidVersion = errors.New("invalid version")
+
+// Parse parses a version like "1.2.3", "v1.2.3-rc.1" or "1.2.3+build". Missing minor
+// and patch versions, like in "v1.2", are treated as 0.
+func Parse(s string) (Version, error) {
+	v, n, err := parsePartial(s)
+	if err != nil {
+		return Version{}, err
+	}
+
+	// tags can't contain wildcards, build metadata may contain dots and hyphens
+	core, _, _ := strings.Cut(strings.TrimPrefix(strings.TrimSpace(s), "v"), "+")
+	core, _, _ = strings.Cut(core, "-")
+	if n == 0 || n != strings.Count(core, ".")+1 {
+		return Version{}, fmt.Errorf("%w: %q", errInvalidVersion, s)
+	}
+
+	return v, nil
+}
+
+// parsePartial parses a version of which only the first n components are given. Components
+// that are wildcards, like in "1.x" or "1.*", count as not given.
+func parsePartial(s string) (v Version, n int, err error) {
+	s = strings.TrimPrefix(strings.TrimSpace(s), "v")
+	s, _, _ = strings.Cut(s, "+")
+	s, v.Prerelease, _ = strings.Cut(s, "-")
+
+	parts := strings.Split(s, ".")
+	if len(parts) > 3 || s == "" {
+		return Version{}, 0, fmt.Errorf("%w: %q", errInvalidVersion, s)
+	}
+
+	nums := []*uint64{&v.Major, &v.Minor, &v.Patch}
+
+	for i, p := range parts {
+		if p == "x" || p == "X" || p == "*" {
+			continue
+		}
+
+		// wildcards may only be followed by wildcards
+		if n < i {
+			return Version{}, 0, fmt.Errorf("%w: %q", errInvalidVersion, s)
+		}
+
+		num, err := strconv.ParseUint(p, 10, 64)
+		if err != nil || (len(p) > 1 && p[0] == '0') {
+			return Version{}, 0, fmt.Errorf("%w: %q", errInvalidVersion, s)
+		}
+
+		*nums[i] = num
+		n++
+	}
+
+	if v.Prerelease != "" && n < 3 {
+		return Version{}, 0, fmt.Errorf("%w: prerelease of incomplete version %q", errInvalidVersion, s)
+	}
+
+	return v, n, nil
+}
+
+func (v Version) String() string {
+	s := fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Patch)
+	if v.Prerelease != "" {
+		s += "-" + v.Prerelease
+	}
+	return s
+}
+
+// Compare returns -1, 0 or 1 if v is lower than, equal to or higher than o.
+func (v Version) Compare(o Version) int {
+	for _, c := range [][2]uint64{{v.Major, o.Major}, {v.Minor, o.Minor}, {v.Patch, o.Patch}} {
+		if c[0] != c[1] {
+			if c[0] < c[1] {
+				return -1
+			}
+			return 1
+		}
+	}
+
+	return comparePrerelease(v.Prerelease, o.Prerelease)
+}
+
+// comparePrerelease compares prerelease identifiers by the rules of semver: releases are
+// higher than prereleases, numeric identifiers are lower than alphanumeric ones.
+func comparePrerelease(a, b string) int {
+	switch {
+	case a == b:
+		return 0
+	case a == "":
+		return 1
+	case b == "":
+		return -1
+	}
+
+	as, bs := strings.Split(a, "."), strings.Split(b, ".")
+
+	for i := 0; i < len(as) && i < len(bs); i++ {
+		an, aErr := strconv.ParseUint(as[i], 10, 64)
+		bn, bErr := strconv.ParseUint(bs[i], 10, 64)
+
+		switch {
+		case aErr == nil && bErr == nil:
+			if an != bn {
+				if an < bn {
+					return -1
+				}
+				return 1
+			}
+		case aErr == nil:
+			return -1
+		case bErr == nil:
+			return 1
+		default:
+			if c := strings.Compare(as[i], bs[i]); c != 0 {
+				return c
+			}
+		}
+	}
+
+	switch {
+	case len(as) < len(bs):
+		return -1
+	case len(as) > len(bs):
+		return 1
+	default:
+		return 0
+	}
+}
+
+// comparator is a single condition of a constraint, like ">=1.2.0".
+type comparator struct {
+	op string
+	v  Version
+}
+
+func (c comparator) matches(v Version) bool {
+	cmp := v.Compare(c.v)
+
+	switch c.op {
+	case "=":
+		return cmp == 0
+	case ">":
+		return cmp > 0
+	case ">=":
+		return cmp >= 0
+	case "<=":
+		return cmp <= 0
+	default:
+		// prereleases of the bound itself are excluded, "<2.0.0" shouldn't match "2.0.0-rc.1"
+		if c.v.Prerelease == "" && v.Prerelease != "" && v.Major == c.v.Major && v.Minor == c.v.Minor && v.Patch == c.v.Patch {
+			return false
+		}
+
+		return cmp < 0
+	}
+}
+
+// Constraint is a set of version ranges, e.g. "^1.2.0", ">=2, <3" or "1.x || >=3".
+type Constraint struct {
+	// any of the alternatives must match, all comparators of an alternative must match
+	alternatives [][]comparator
+}
+
+// IsConstraint returns true if ref is a version constraint, rather than the name of a
+// branch, tag or commit. It accepts what ParseConstraint parses, except exact versions like
+// "1.2.3" or "1.2", since they are usually the name of a tag; "=1.2.3" is a constraint.
+func IsConstraint(ref string) bool {
+	ref = strings.TrimSpace(ref)
+
+	if ref == "" || strings.Contains(ref, "/") {
+		return false
+	}
+
+	if _, err := Parse(ref); err == nil {
+		return false
+	}
+
+	_, err := ParseConstraint(ref)
+	return err == nil
+}
+
+// ParseConstraint parses a constraint. Alternatives are separated by "||", the comparators
+// of an alternative by "," or spaces. Supported are:
+//
+//   - "latest", "*": any version
+//   - "^1.2.3": compatible versions, >=1.2.3 <2.0.0 (<0.3.0 for ^0.2.3)
+//   - "~1.2.3": patch versions, >=1.2.3 <1.3.0
+//   - "=", ">", ">=", "<", "<=" followed by a version
+//   - "1.2", "1.x": any version with that prefix
+func ParseConstraint(s string) (*Constraint, error) {
+	c := &Constraint{}
+
+	for _, alt := range strings.Split(s, "||") {
+		var comps []comparator
+
+		for _, part := range strings.Fields(strings.ReplaceAll(alt, ",", " ")) {
+			expanded, err := parseComparator(part)
+			if err != nil {
+				return nil, fmt.Errorf("invalid constraint %q: %w", s, err)
+			}
+
+			comps = append(comps, expanded...)
+		}
+
+		if len(comps) == 0 {
+			return nil, fmt.Errorf("invalid constraint %q: empty range", s)
+		}
+
+		c.alternatives = append(c.alternatives, comps)
+	}
+
+	return c, nil
+}
+
+// parseComparator parses a single part of a constraint into the comparators it stands for.
+func parseComparator(s string) ([]comparator, error) {
+	if s == "latest" || s == "*" || s == "x" || s == "X" {
+		return []comparator{{op: ">=", v: Version{}}}, nil
+	}
+
+	op := ""
+	for _, candidate := range []string{">=", "<=", ">", "<", "=", "^", "~"} {
+		if strings.HasPrefix(s, candidate) {
+			op = candidate
+			break
+		}
+	}
+
+	v, n, err := parsePartial(strings.TrimPrefix(s, op))
+	if err != nil {
+		return nil, err
+	}
+
+	// wildcards match any version, whatever the operator
+	if n == 0 {
+		return []comparator{{op: ">=", v: Version{}}}, nil
+	}
+
+	// next is the lowest version above all versions with the given prefix of components
+	next := func(n int) Version {
+		switch n {
+		case 1:
+			return Version{Major: v.Major + 1}
+		case 2:
+			return Version{Major: v.Major, Minor: v.Minor + 1}
+		default:
+			return Version{Major: v.Major, Minor: v.Minor, Patch: v.Patch + 1}
+		}
+	}
+
+	switch op {
+	case "^":
+		// the first non-zero component given may not change
+		switch {
+		case v.Major > 0 || n == 1:
+			return []comparator{{">=", v}, {"<", next(1)}}, nil
+		case v.Minor > 0 || n == 2:
+			return []comparator{{">=", v}, {"<", next(2)}}, nil
+		default:
+			return []comparator{{">=", v}, {"<", next(3)}}, nil
+		}
+	case "~":
+		if n == 1 {
+			return []comparator{{">=", v}, {"<", next(1)}}, nil
+		}
+		return []comparator{{">=", v}, {"<", next(2)}}, nil
+	case ">":
+		if n < 3 {
+			return []comparator{{">=", next(n)}}, nil
+		}
+		return []comparator{{">", v}}, nil
+	case "<=":
+		if n < 3 {
+			return []comparator{{"<", next(n)}}, nil
+		}
+		return []comparator{{"<=", v}}, nil
+	case ">=", "<":
+		return []comparator{{op, v}}, nil
+	default:
+		if n < 3 {
+			return []comparator{{">=", v}, {"<", next(n)}}, nil
+		}
+		return []comparator{{"=", v}}, nil
+	}
+}
+
+// Matches returns true if v is within the constraint.
+func (c *Constraint) Matches(v Version) bool {
+	for _, alt := range c.alternatives {
+		ok := true
+		for _, comp := range alt {
+			ok = ok && comp.matches(v)
+		}
+
+		if ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Latest returns the tag with the highest version matching constraint. Tags that are no
+// semantic versions are ignored, and so are prereleases, unless prerelease is true.
+func Latest(constraint string, tags []string, prerelease bool) (string, error) {
+	c, err := ParseConstraint(constraint)
+	if err != nil {
+		return "", err
+	}
+
+	type candidate struct {
+		tag string
+		v   Version
+	}
+
+	var matching []candidate
+
+	for _, tag := range tags {
+		v, err := Parse(tag)
+		if err != nil || (v.Prerelease != "" && !prerelease) || !c.Matches(v) {
+			continue
+		}
+
+		matching = append(matching, candidate{tag: tag, v: v})
+	}
+
+	if len(matching) == 0 {
+		return "", fmt.Errorf("no tag matches %q", constraint)
+	}
+
+	// highest version first, equal versions like v1.0.0 and 1.0.0 by name
+	sort.Slice(matching, func(i, j int) bool {
+		if c := matching[i].v.Compare(matching[j].v); c != 0 {
+			return c > 0
+		}
+		return matching[i].tag < matching[j].tag
+	})
+
+	return matching[0].tag, nil
+}
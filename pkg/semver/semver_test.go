@@ -0,0 +1,171 @@
+package semver
+
+import "testing"
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		s    string
+		want string
+		err  bool
+	}{
+		{s: "1.2.3", want: "1.2.3"},
+		{s: "v1.2.3", want: "1.2.3"},
+		{s: "v1.2", want: "1.2.0"},
+		{s: "1.2.3-rc.1+build.5", want: "1.2.3-rc.1"},
+		{s: "v1.2.3+build.5", want: "1.2.3"},
+		{s: "v1.2.3+build-5.x", want: "1.2.3"},
+		{s: "1.x", err: true},
+		{s: "1.2.3.4", err: true},
+		{s: "01.2.3", err: true},
+		{s: "main", err: true},
+		{s: "1.2-rc.1", err: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.s, func(t *testing.T) {
+			v, err := Parse(tt.s)
+			if (err != nil) != tt.err {
+				t.Fatalf("Parse() error = %v, expected error %v", err, tt.err)
+			}
+
+			if err == nil && v.String() != tt.want {
+				t.Errorf("Parse() = %v, expected %v", v, tt.want)
+			}
+		})
+	}
+}
+
+func TestCompare(t *testing.T) {
+	// in ascending order
+	versions := []string{
+		"0.9.0",
+		"1.0.0-alpha",
+		"1.0.0-alpha.1",
+		"1.0.0-alpha.beta",
+		"1.0.0-beta.2",
+		"1.0.0-beta.11",
+		"1.0.0-rc.1",
+		"1.0.0",
+		"1.0.1",
+		"1.10.0",
+		"2.0.0",
+	}
+
+	for i := range versions {
+		for j := range versions {
+			a, _ := Parse(versions[i])
+			b, _ := Parse(versions[j])
+
+			want := 0
+			if i < j {
+				want = -1
+			} else if i > j {
+				want = 1
+			}
+
+			if got := a.Compare(b); got != want {
+				t.Errorf("%v.Compare(%v) = %v, expected %v", a, b, got, want)
+			}
+		}
+	}
+}
+
+func TestConstraint(t *testing.T) {
+	tests := []struct {
+		constraint string
+		matches    []string
+		rejects    []string
+	}{
+		{constraint: "latest", matches: []string{"0.0.1", "9.9.9"}},
+		{constraint: "^1.2.0", matches: []string{"1.2.0", "1.9.9"}, rejects: []string{"1.1.9", "2.0.0", "2.0.0-rc.1"}},
+		{constraint: "^0.2.3", matches: []string{"0.2.3", "0.2.9"}, rejects: []string{"0.3.0"}},
+		{constraint: "^0.0.3", matches: []string{"0.0.3"}, rejects: []string{"0.0.4"}},
+		{constraint: "~1.4", matches: []string{"1.4.0", "1.4.7"}, rejects: []string{"1.3.9", "1.5.0"}},
+		{constraint: "~1", matches: []string{"1.0.0", "1.9.0"}, rejects: []string{"2.0.0"}},
+		{constraint: ">=2, <3", matches: []string{"2.0.0", "2.9.9"}, rejects: []string{"1.9.9", "3.0.0"}},
+		{constraint: ">=2 <3", matches: []string{"2.5.0"}, rejects: []string{"3.0.0"}},
+		{constraint: ">1.2", matches: []string{"1.3.0"}, rejects: []string{"1.2.9"}},
+		{constraint: "<=1.2", matches: []string{"1.2.9"}, rejects: []string{"1.3.0"}},
+		{constraint: "=1.2.3", matches: []string{"1.2.3"}, rejects: []string{"1.2.4"}},
+		{constraint: "1.x || >=3", matches: []string{"1.5.0", "3.1.0"}, rejects: []string{"2.0.0"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.constraint, func(t *testing.T) {
+			c, err := ParseConstraint(tt.constraint)
+			if err != nil {
+				t.Fatalf("ParseConstraint() error = %v", err)
+			}
+
+			for _, s := range tt.matches {
+				if v, _ := Parse(s); !c.Matches(v) {
+					t.Errorf("%v doesn't match %v", s, tt.constraint)
+				}
+			}
+
+			for _, s := range tt.rejects {
+				if v, _ := Parse(s); c.Matches(v) {
+					t.Errorf("%v matches %v", s, tt.constraint)
+				}
+			}
+		})
+	}
+}
+
+func TestIsConstraint(t *testing.T) {
+	for ref, want := range map[string]bool{
+		"latest":     true,
+		"^1.2.0":     true,
+		"~1.4":       true,
+		">=2, <3":    true,
+		"=1.2.3":     true,
+		"*":          true,
+		"1.x":        true,
+		"1.2.x":      true,
+		"v1.X":       true,
+		"1.*":        true,
+		"1.x || 2.x": true,
+		"1.2":        false,
+		"1234567":    false,
+		"1.2.3":      false,
+		"v1.2.3":     false,
+		"main":       false,
+		"heads/main": false,
+		"":           false,
+		"^main":      false,
+	} {
+		if got := IsConstraint(ref); got != want {
+			t.Errorf("IsConstraint(%q) = %v, expected %v", ref, got, want)
+		}
+	}
+}
+
+func TestLatest(t *testing.T) {
+	tags := []string{"v1.3.0", "v1.4.0", "v1.4.2", "v1.5.0-rc.1", "v2.0.0", "nightly", "1.4.2"}
+
+	tests := []struct {
+		constraint string
+		prerelease bool
+		want       string
+		err        bool
+	}{
+		{constraint: "latest", want: "v2.0.0"},
+		{constraint: "~1.4", want: "1.4.2"},
+		{constraint: "^1.0.0", want: "1.4.2"},
+		{constraint: "^1.0.0", prerelease: true, want: "v1.5.0-rc.1"},
+		{constraint: ">=3", err: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.constraint, func(t *testing.T) {
+			got, err := Latest(tt.constraint, tags, tt.prerelease)
+			if (err != nil) != tt.err {
+				t.Fatalf("Latest() error = %v, expected error %v", err, tt.err)
+			}
+
+			if got != tt.want {
+				t.Errorf("Latest() = %v, expected %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,145 @@
+package utils
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+)
+
+// ExtractTarGz extracts the regular files and symlinks of the gzip compressed tar archive read
+// from r into root.
+//
+// For each file, dest is called with its path inside the archive and, for symlinks, their
+// target, and returns the path to extract it to, relative to root. Files for which dest
+// returns the empty string are skipped. Executable files stay executable.
+func ExtractTarGz(r io.Reader, root Root, dest func(name, link string) string) error {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return fmt.Errorf("error reading gzip stream: %w", err)
+	}
+
+	defer gz.Close()
+
+	tr := tar.NewReader(gz)
+
+	for {
+		header, err := tr.Next()
+
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+
+		if err != nil {
+			return fmt.Errorf("error reading tar archive: %w", err)
+		}
+
+		if header.Typeflag != tar.TypeReg && header.Typeflag != tar.TypeSymlink {
+			continue
+		}
+
+		tgt := dest(header.Name, header.Linkname)
+		if tgt == "" {
+			continue
+		}
+
+		if header.Typeflag == tar.TypeSymlink {
+			err = root.Symlink(header.Linkname, tgt)
+		} else {
+			err = root.saveReader(tr, tgt, perm(header.FileInfo().Mode()))
+		}
+
+		if err != nil {
+			return fmt.Errorf("error extracting %v: %w", header.Name, err)
+		}
+	}
+}
+
+// ExtractZip extracts the regular files and symlinks of the zip archive at src into root,
+// like ExtractTarGz.
+func ExtractZip(src string, root Root, dest func(name, link string) string) error {
+	zr, err := zip.OpenReader(src)
+	if err != nil {
+		return fmt.Errorf("error reading zip archive: %w", err)
+	}
+
+	defer zr.Close()
+
+	for _, f := range zr.File {
+		if err := extractZipFile(f, root, dest); err != nil {
+			return fmt.Errorf("error extracting %v: %w", f.Name, err)
+		}
+	}
+
+	return nil
+}
+
+func extractZipFile(f *zip.File, root Root, dest func(name, link string) string) error {
+	mode := f.Mode()
+	if !mode.IsRegular() && mode&fs.ModeSymlink == 0 {
+		return nil
+	}
+
+	r, err := f.Open()
+	if err != nil {
+		return err
+	}
+
+	defer r.Close()
+
+	if mode.IsRegular() {
+		if tgt := dest(f.Name, ""); tgt != "" {
+			return root.saveReader(r, tgt, perm(mode))
+		}
+
+		return nil
+	}
+
+	// the content of symlinks is their target
+	link, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+
+	if tgt := dest(f.Name, string(link)); tgt != "" {
+		return root.Symlink(string(link), tgt)
+	}
+
+	return nil
+}
+
+// perm returns the permissions to extract a file with mode with: executable files stay
+// executable, the umask decides about the rest.
+func perm(mode fs.FileMode) fs.FileMode {
+	if mode&0o111 != 0 {
+		return 0o777
+	}
+
+	return 0o666
+}
+
+// saveReader writes everything read from r to the file tgt, creating its parent directories.
+// The file is created with perm, before the umask.
+func saveReader(r io.Reader, tgt string, perm fs.FileMode) error {
+	if err := os.MkdirAll(path.Dir(tgt), os.ModePerm); err != nil {
+		return fmt.Errorf("error creating parents of tgt: %w", err)
+	}
+
+	f, err := os.OpenFile(tgt, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return fmt.Errorf("error creating file: %w", err)
+	}
+
+	defer f.Close()
+
+	if _, err := io.Copy(f, r); err != nil {
+		return fmt.Errorf("could not write to file: %w", err)
+	}
+
+	return nil
+}
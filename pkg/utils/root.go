@@ -0,0 +1,238 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// ErrUnsafePath is returned for paths that could be used to write outside of a Root.
+var ErrUnsafePath = errors.New("unsafe path")
+
+// Root is a directory that files are written to, e.g. the temp directory of a dependency.
+//
+// Upstream content decides which paths are written, so its methods never write outside of
+// Dir: names must be relative and must not contain `..`, directories on the way must not be
+// symlinks resolving outside of Dir, and existing symlinks are never written through.
+type Root struct {
+	Dir string
+}
+
+// Path returns the path of the file name inside of the root, or an error wrapping
+// ErrUnsafePath if writing to it could modify anything outside of the root. Name is
+// slash separated.
+func (r Root) Path(name string) (string, error) {
+	return r.path(name, false)
+}
+
+// Replace returns the path of the file name inside of the root like Path, for files that are
+// replaced by renaming another file to it, rather than written to. Name may be a symlink
+// then, as long as it stays inside of the root, since renaming replaces the symlink itself.
+func (r Root) Replace(name string) (string, error) {
+	return r.path(name, true)
+}
+
+func (r Root) path(name string, replace bool) (string, error) {
+	if err := checkLocal(name); err != nil {
+		return "", err
+	}
+
+	dir, err := filepath.EvalSymlinks(r.Dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		// nothing exists inside of the root yet, so there are no symlinks either
+		return filepath.Join(r.Dir, filepath.FromSlash(name)), nil
+	}
+
+	if err != nil {
+		return "", fmt.Errorf("error resolving %v: %w", r.Dir, err)
+	}
+
+	parts := strings.Split(name, "/")
+	p := r.Dir
+
+	for i, part := range parts {
+		p = filepath.Join(p, part)
+
+		info, err := os.Lstat(p)
+		if errors.Is(err, fs.ErrNotExist) {
+			// neither does anything below
+			break
+		}
+
+		if err != nil {
+			return "", fmt.Errorf("error reading %v: %w", p, err)
+		}
+
+		if info.Mode()&fs.ModeSymlink == 0 {
+			continue
+		}
+
+		if i == len(parts)-1 && !replace {
+			return "", fmt.Errorf("%w: refusing to write through symlink %v", ErrUnsafePath, name)
+		}
+
+		if i == len(parts)-1 {
+			target, err := os.Readlink(p)
+			if err != nil || r.checkSymlink(p, name, target) != nil {
+				return "", fmt.Errorf("%w: refusing to replace symlink %v, it leads outside of %v", ErrUnsafePath, name, r.Dir)
+			}
+
+			break
+		}
+
+		resolved, err := filepath.EvalSymlinks(p)
+		if err != nil || !inside(dir, resolved) {
+			return "", fmt.Errorf("%w: %v leads outside of %v through symlink %v", ErrUnsafePath, name, r.Dir, strings.Join(parts[:i+1], "/"))
+		}
+	}
+
+	return filepath.Join(r.Dir, filepath.FromSlash(name)), nil
+}
+
+// SaveFile writes b to the file name inside of the root, see SaveFile.
+func (r Root) SaveFile(b []byte, name string, perm fs.FileMode) error {
+	p, err := r.Path(name)
+	if err != nil {
+		return err
+	}
+
+	return SaveFile(b, p, perm)
+}
+
+// CopyFile copies the file src to the file name inside of the root, see CopyFile.
+func (r Root) CopyFile(src, name string) error {
+	p, err := r.Path(name)
+	if err != nil {
+		return err
+	}
+
+	return CopyFile(src, p)
+}
+
+// Symlink creates the symlink name inside of the root, pointing to target, see CheckSymlink.
+// Target is cleaned, so it only contains `..` at its start.
+func (r Root) Symlink(target, name string) error {
+	if err := r.CheckSymlink(name, target); err != nil {
+		return err
+	}
+
+	p, err := r.Path(name)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(p), os.ModePerm); err != nil {
+		return fmt.Errorf("error creating parents of %v: %w", name, err)
+	}
+
+	return os.Symlink(filepath.FromSlash(CleanLink(target)), p)
+}
+
+// CheckSymlink returns an error wrapping ErrUnsafePath unless a symlink name inside of the
+// root, pointing to target, can be created and stays inside of the root. Target must be
+// relative, and is resolved from where name is located physically, since its parents
+// might be symlinks themselves.
+func (r Root) CheckSymlink(name, target string) error {
+	p, err := r.Replace(name)
+	if err != nil {
+		return err
+	}
+
+	return r.checkSymlink(p, name, target)
+}
+
+// checkSymlink is CheckSymlink for the path p of name, which was checked already.
+func (r Root) checkSymlink(p, name, target string) error {
+	dir, err := evalExisting(r.Dir)
+	if err != nil {
+		return err
+	}
+
+	parent, err := evalExisting(filepath.Dir(p))
+	if err != nil {
+		return err
+	}
+
+	rel, err := filepath.Rel(dir, parent)
+	if err != nil || !inside(dir, parent) {
+		return fmt.Errorf("%w: %v is outside of %v", ErrUnsafePath, name, r.Dir)
+	}
+
+	target = filepath.ToSlash(target)
+	resolved := path.Join(filepath.ToSlash(rel), target)
+
+	if path.IsAbs(target) || filepath.IsAbs(filepath.FromSlash(target)) || !filepath.IsLocal(filepath.FromSlash(resolved)) {
+		return fmt.Errorf("%w: symlink %v points to %v, outside of the directory it is copied to", ErrUnsafePath, name, target)
+	}
+
+	return nil
+}
+
+// CleanLink returns the shortest form of the relative symlink target, which only contains
+// `..` at its start. Unlike the original, it can't pass through other symlinks to go up.
+func CleanLink(target string) string {
+	return path.Clean(filepath.ToSlash(target))
+}
+
+// evalExisting returns p with all symlinks evaluated, for the part of p that exists.
+func evalExisting(p string) (string, error) {
+	var rest []string
+
+	for {
+		resolved, err := filepath.EvalSymlinks(p)
+		if err == nil {
+			return filepath.Join(append([]string{resolved}, rest...)...), nil
+		}
+
+		if !errors.Is(err, fs.ErrNotExist) || filepath.Dir(p) == p {
+			return "", fmt.Errorf("error resolving %v: %w", p, err)
+		}
+
+		rest = append([]string{filepath.Base(p)}, rest...)
+		p = filepath.Dir(p)
+	}
+}
+
+// saveReader writes everything read from rd to the file name inside of the root.
+func (r Root) saveReader(rd io.Reader, name string, perm fs.FileMode) error {
+	p, err := r.Path(name)
+	if err != nil {
+		return err
+	}
+
+	return saveReader(rd, p, perm)
+}
+
+// checkLocal returns an error if the slash separated path name is absolute, or contains `..`.
+func checkLocal(name string) error {
+	if name == "" {
+		return fmt.Errorf("%w: empty path", ErrUnsafePath)
+	}
+
+	if strings.HasPrefix(name, "/") || filepath.IsAbs(filepath.FromSlash(name)) {
+		return fmt.Errorf("%w: %v is absolute", ErrUnsafePath, name)
+	}
+
+	for _, part := range strings.Split(name, "/") {
+		if part == ".." {
+			return fmt.Errorf("%w: %v contains '..'", ErrUnsafePath, name)
+		}
+	}
+
+	if !filepath.IsLocal(filepath.FromSlash(name)) {
+		return fmt.Errorf("%w: %v is not a local path", ErrUnsafePath, name)
+	}
+
+	return nil
+}
+
+// inside returns true if p is dir, or inside of it. Both must be clean.
+func inside(dir, p string) bool {
+	rel, err := filepath.Rel(dir, p)
+	return err == nil && (rel == "." || filepath.IsLocal(rel))
+}
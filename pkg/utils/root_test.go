@@ -0,0 +1,161 @@
+package utils
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRootPath(t *testing.T) {
+	dir := t.TempDir()
+	root := Root{Dir: filepath.Join(dir, "root")}
+	outside := filepath.Join(dir, "outside")
+
+	for _, d := range []string{filepath.Join(root.Dir, "inner"), outside} {
+		if err := os.MkdirAll(d, os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for link, target := range map[string]string{
+		"escape":  outside,
+		"up":      "..",
+		"alias":   "inner",
+		"file":    filepath.Join(outside, "file.txt"),
+		"dangles": filepath.Join(dir, "missing"),
+	} {
+		if err := os.Symlink(target, filepath.Join(root.Dir, link)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for name, wantErr := range map[string]bool{
+		"a.txt":           false,
+		"new/dir/a.txt":   false,
+		"inner/a.txt":     false,
+		"alias/a.txt":     false,
+		"./a.txt":         false,
+		"":                true,
+		"/etc/passwd":     true,
+		"../a.txt":        true,
+		"inner/../a.txt":  true,
+		"a/../../a.txt":   true,
+		"escape/a.txt":    true,
+		"up/outside/a":    true,
+		"file":            true,
+		"alias":           true,
+		"dangles/a.txt":   true,
+		"escape/../a.txt": true,
+	} {
+		_, err := root.Path(name)
+		if (err != nil) != wantErr {
+			t.Errorf("Path(%q) = %v, expected error: %v", name, err, wantErr)
+		}
+
+		if err != nil && !errors.Is(err, ErrUnsafePath) {
+			t.Errorf("Path(%q) = %v, expected %v", name, err, ErrUnsafePath)
+		}
+	}
+}
+
+func TestRootSaveFile(t *testing.T) {
+	dir := t.TempDir()
+	root := Root{Dir: filepath.Join(dir, "root")}
+
+	// the root doesn't need to exist
+	if err := root.SaveFile([]byte("a"), "sub/a.txt", 0o666); err != nil {
+		t.Fatal(err)
+	}
+
+	if bs, err := os.ReadFile(filepath.Join(root.Dir, "sub", "a.txt")); err != nil || string(bs) != "a" {
+		t.Errorf("sub/a.txt = %q, %v", bs, err)
+	}
+
+	// pre-existing symlinks are never written through
+	if err := os.WriteFile(filepath.Join(dir, "victim.txt"), []byte("victim"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.Symlink(filepath.Join(dir, "victim.txt"), filepath.Join(root.Dir, "link.txt")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := root.SaveFile([]byte("evil"), "link.txt", 0o666); !errors.Is(err, ErrUnsafePath) {
+		t.Errorf("SaveFile() through symlink = %v, expected %v", err, ErrUnsafePath)
+	}
+
+	if bs, _ := os.ReadFile(filepath.Join(dir, "victim.txt")); string(bs) != "victim" {
+		t.Errorf("file outside of root was overwritten with %q", bs)
+	}
+}
+
+func TestRootSymlink(t *testing.T) {
+	dir := t.TempDir()
+	root := Root{Dir: filepath.Join(dir, "root")}
+
+	if err := os.MkdirAll(filepath.Join(root.Dir, "a"), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+
+	// a/self is the root itself, so links below it are less deep than their name suggests
+	if err := os.Symlink("..", filepath.Join(root.Dir, "a", "self")); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		name, target string
+		// want is what the created symlink points to
+		want    string
+		wantErr bool
+	}{
+		{name: "link.txt", target: "a.txt", want: "a.txt"},
+		{name: "a/b/link.txt", target: "../../a.txt", want: "../../a.txt"},
+		{name: "a/dot.txt", target: "./b/../a.txt", want: "a.txt"},
+		{name: "a/dir", target: "..", want: ".."},
+		{name: "up.txt", target: "../a.txt", wantErr: true},
+		{name: "abs.txt", target: "/etc/passwd", wantErr: true},
+		{name: "a/self/b/up.txt", target: "../../a.txt", wantErr: true},
+		{name: "a/self/up.txt", target: "../a.txt", wantErr: true},
+		{name: "../up.txt", target: "a.txt", wantErr: true},
+	} {
+		err := root.Symlink(tt.target, tt.name)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("Symlink(%q, %q) = %v, expected error: %v", tt.target, tt.name, err, tt.wantErr)
+			continue
+		}
+
+		if err != nil {
+			if !errors.Is(err, ErrUnsafePath) {
+				t.Errorf("Symlink(%q, %q) = %v, expected %v", tt.target, tt.name, err, ErrUnsafePath)
+			}
+			continue
+		}
+
+		if got, err := os.Readlink(filepath.Join(root.Dir, tt.name)); err != nil || got != tt.want {
+			t.Errorf("Symlink(%q, %q) points to %q, %v, expected %q", tt.target, tt.name, got, err, tt.want)
+		}
+	}
+}
+
+func TestRootReplace(t *testing.T) {
+	dir := t.TempDir()
+	root := Root{Dir: filepath.Join(dir, "root")}
+
+	if err := os.MkdirAll(root.Dir, os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+
+	for link, target := range map[string]string{"inside": "a.txt", "outside": filepath.Join(dir, "victim.txt"), "up": "../victim.txt"} {
+		if err := os.Symlink(target, filepath.Join(root.Dir, link)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// renaming replaces symlinks staying inside of the root, others are left alone
+	for name, wantErr := range map[string]bool{"a.txt": false, "inside": false, "outside": true, "up": true} {
+		if _, err := root.Replace(name); (err != nil) != wantErr {
+			t.Errorf("Replace(%q) = %v, expected error: %v", name, err, wantErr)
+		}
+	}
+}
@@ -1,14 +1,18 @@
 package utils
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"io"
+	"io/fs"
 	"net/http"
 	"os"
 	"path"
 )
 
-// CopyFile copies a file from src and tgt.
+// CopyFile copies a file from src and tgt, keeping its permissions.
 //
 // If tgt ends with /, it is assumed to point to a directory, and the
 // same file name from src is appended.
@@ -25,13 +29,18 @@ func CopyFile(src string, tgt string) error {
 
 	defer r.Close()
 
+	info, err := r.Stat()
+	if err != nil {
+		return fmt.Errorf("error reading src: %v", err)
+	}
+
 	// make parent dirs of target
 	if err := os.MkdirAll(path.Dir(tgt), os.ModePerm); err != nil {
 		return fmt.Errorf("error creating parents of tgt: %w", err)
 	}
 
 	// create target file
-	w, err := os.Create(tgt)
+	w, err := os.OpenFile(tgt, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, info.Mode().Perm())
 	if err != nil {
 		return fmt.Errorf("error creating tgt: %v", err)
 	}
@@ -47,12 +56,13 @@ func CopyFile(src string, tgt string) error {
 	return nil
 }
 
-func SaveFile(b []byte, tgt string) error {
+// SaveFile writes b to the file tgt, which is created with perm (before the umask) if it doesn't exist.
+func SaveFile(b []byte, tgt string, perm fs.FileMode) error {
 	if err := os.MkdirAll(path.Dir(tgt), os.ModePerm); err != nil {
 		return fmt.Errorf("error creating parents of tgt: %w", err)
 	}
 
-	f, err := os.Create(tgt)
+	f, err := os.OpenFile(tgt, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
 
 	if err != nil {
 		return fmt.Errorf("error creating file: %w", err)
@@ -70,29 +80,63 @@ func SaveFile(b []byte, tgt string) error {
 }
 
 // DownloadFile downloads a file from the given url and saves it to the given path.
-func DownloadFile(url string, path string) error {
+// It returns the headers of the response.
+func DownloadFile(ctx context.Context, url string, path string) (http.Header, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error creating request: %w", err)
+	}
+
+	// download the file via http
+	fresp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error downloading resource: %w", err)
+	}
+
+	defer fresp.Body.Close()
+
+	if fresp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("error downloading resource: unexpected status %v", fresp.Status)
+	}
+
 	// create target file
 	out, err := os.Create(path)
 
 	if err != nil {
-		return fmt.Errorf("error creating target file: %w", err)
+		return nil, fmt.Errorf("error creating target file: %w", err)
 	}
 
 	defer out.Close()
 
-	// download the file via http and copy it to the target file
-	fresp, err := http.Get(url)
+	// copy the response to the target file
+	_, err = io.Copy(out, fresp.Body)
+
 	if err != nil {
-		return fmt.Errorf("error downloading resource: %w", err)
+		return nil, fmt.Errorf("error copying file: %w", err)
 	}
 
-	_, err = io.Copy(out, fresp.Body)
+	return fresp.Header, nil
+}
 
+// HashFile returns the hex encoded sha256 hash of the file's content. For symlinks, it
+// returns the hash of their cleaned target, so that a changed target changes the hash.
+func HashFile(p string) (string, error) {
+	if target, err := os.Readlink(p); err == nil {
+		h := sha256.Sum256([]byte("symlink " + CleanLink(target)))
+		return hex.EncodeToString(h[:]), nil
+	}
+
+	f, err := os.Open(p)
 	if err != nil {
-		return fmt.Errorf("error copying file: %w", err)
+		return "", fmt.Errorf("error opening file: %w", err)
 	}
 
-	fmt.Printf("Downloaded file %s to %s\n", url, path)
+	defer f.Close()
 
-	return nil
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", fmt.Errorf("error reading file: %w", err)
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
 }